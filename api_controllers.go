@@ -0,0 +1,484 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiError - the JSON body returned by the write endpoints of the API when
+// a request cannot be completed. `Fields` is only set for validation errors.
+type apiError struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// writeJSON - encodes `v` as the JSON response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError - responds with an apiError carrying `message`.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
+
+// Page sizes of GET /api/events.
+const (
+	defaultEventPageSize = 20
+	maxEventPageSize     = 100
+)
+
+// eventPage - the JSON body of GET /api/events. `Next` is the URL of the
+// next page, and is left out on the last one.
+type eventPage struct {
+	Events []Event `json:"events"`
+	Next   string  `json:"next,omitempty"`
+}
+
+// apiListEventsController - handles GET /api/events. It accepts these
+// query parameters, all optional:
+//
+//	limit   events per page, up to maxEventPageSize
+//	cursor  the cursor from the previous page's next link
+//	from    only events still going on at this time
+//	to      only events starting before this time
+//	q       only events whose title or location contains this text
+//	sort    date (the default), -date or title
+//
+// Times are RFC 3339 or YYYY-MM-DD, which means midnight in the default
+// time zone.
+func (s *server) apiListEventsController(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := eventQuery{
+		Search:    strings.TrimSpace(params.Get("q")),
+		Sort:      params.Get("sort"),
+		Limit:     defaultEventPageSize,
+		Attendees: true,
+	}
+
+	if q.Sort == "" {
+		q.Sort = eventSortDate
+	} else if !isValidEventSort(q.Sort) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid sort: must be date, -date or title")
+		return
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxEventPageSize {
+			writeJSONError(w, http.StatusBadRequest, "Invalid limit: must be between 1 and "+strconv.Itoa(maxEventPageSize))
+			return
+		}
+		q.Limit = n
+	}
+
+	for name, t := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if value := params.Get(name); value != "" {
+			parsed, ok := parseQueryTime(value, s.defaults.location())
+			if !ok {
+				writeJSONError(w, http.StatusBadRequest, "Invalid "+name+": must be RFC 3339 or YYYY-MM-DD")
+				return
+			}
+			*t = parsed
+		}
+	}
+
+	if cursor := params.Get("cursor"); cursor != "" {
+		after, err := decodeEventCursor(cursor)
+		if err != nil || after.Sort != q.Sort {
+			writeJSONError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+		q.After = &after
+	}
+
+	events, next, err := s.store.ListEvents(q)
+	if err != nil {
+		apiServerError(w, r, "Error retrieving events", err)
+		return
+	}
+
+	// Recurring events list their occurrences within the requested dates,
+	// or the next occurrenceWindow by default
+	from := q.From
+	if from.IsZero() {
+		from = time.Now()
+	}
+	to := q.To
+	if to.IsZero() {
+		to = from.Add(occurrenceWindow)
+	}
+	for i := range events {
+		events[i].listOccurrences(from, to, 0)
+		events[i].restrictAttendees()
+	}
+
+	page := eventPage{Events: events}
+	if page.Events == nil {
+		page.Events = []Event{}
+	}
+	if next != nil {
+		params.Set("cursor", encodeEventCursor(*next))
+		page.Next = "/api/events?" + params.Encode()
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// parseQueryTime - parses a time given in a query parameter, as RFC 3339
+// or as a YYYY-MM-DD date in `loc`.
+func parseQueryTime(value string, loc *time.Location) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, loc)
+	return t, err == nil
+}
+
+// encodeEventCursor - encodes `cursor` for use in a URL.
+func encodeEventCursor(cursor eventCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeEventCursor - decodes a cursor made by encodeEventCursor.
+func decodeEventCursor(s string) (eventCursor, error) {
+	var cursor eventCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(b, &cursor)
+	return cursor, err
+}
+
+// apiCreateEventController - handles POST /api/events. The body is a JSON
+// object with the same fields as the /events/new form.
+func (s *server) apiCreateEventController(w http.ResponseWriter, r *http.Request) {
+	var fields eventFields
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	newEvent := Event{TimeZone: s.defaults.TimeZone}
+	if fieldErrors := fields.apply(&newEvent, false); len(fieldErrors) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, apiError{Error: "Invalid event", Fields: fieldErrors})
+		return
+	}
+
+	token := newOrganizerToken()
+	newEvent.OrganizerTokenHash = hashOrganizerToken(token)
+	user, loggedIn, err := s.currentUser(r)
+	if err != nil {
+		apiServerError(w, r, "Error creating event", err)
+		return
+	}
+	if loggedIn {
+		newEvent.OwnerID = user.ID
+	}
+
+	id, err := s.store.CreateEvent(newEvent)
+	if err != nil {
+		apiServerError(w, r, "Error creating event", err)
+		return
+	}
+	event, _, err := s.store.GetEvent(id)
+	if err != nil {
+		apiServerError(w, r, "Error retrieving event", err)
+		return
+	}
+
+	// The organizer token is only ever returned here; it must be sent in
+	// the X-Organizer-Token header to update or delete the event.
+	type createdEventResponse struct {
+		Event
+		OrganizerToken string `json:"organizer_token"`
+	}
+	w.Header().Set("Location", "/api/events/"+event.PublicID)
+	writeJSON(w, http.StatusCreated, createdEventResponse{Event: event, OrganizerToken: token})
+}
+
+// apiUpdateEventController - handles PUT and PATCH /api/events/{id}. PUT
+// replaces every field and so requires all of them; PATCH only changes the
+// fields present in the body. Requires the event's organizer token.
+func (s *server) apiUpdateEventController(w http.ResponseWriter, r *http.Request) {
+	id, err := s.eventIDParam(r)
+	if err == errEventNotFound {
+		writeJSONError(w, http.StatusNotFound, "Event not found")
+		return
+	} else if err != nil {
+		apiServerError(w, r, "Error retrieving event", err)
+		return
+	}
+
+	event, found, err := s.store.GetEvent(id)
+	if err != nil {
+		apiServerError(w, r, "Error retrieving event", err)
+		return
+	}
+	if !found {
+		writeJSONError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if !s.canManageEvent(r, event) {
+		writeJSONError(w, http.StatusForbidden, "Invalid organizer token")
+		return
+	}
+
+	var fields eventFields
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	partial := r.Method == http.MethodPatch
+	if fieldErrors := fields.apply(&event, partial); len(fieldErrors) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, apiError{Error: "Invalid event", Fields: fieldErrors})
+		return
+	}
+
+	if err := s.store.UpdateEvent(event); err == errEventNotFound {
+		writeJSONError(w, http.StatusNotFound, "Event not found")
+		return
+	} else if err != nil {
+		apiServerError(w, r, "Error updating event", err)
+		return
+	}
+
+	// Re-read the event so the response carries what the store saved,
+	// including the bumped sequence and update time
+	event, found, err = s.store.GetEvent(id)
+	if err != nil {
+		apiServerError(w, r, "Error retrieving event", err)
+		return
+	}
+	if !found {
+		writeJSONError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, event)
+}
+
+// apiDeleteEventController - handles DELETE /api/events/{id}. Requires the
+// event's organizer token.
+func (s *server) apiDeleteEventController(w http.ResponseWriter, r *http.Request) {
+	id, err := s.eventIDParam(r)
+	if err == errEventNotFound {
+		writeJSONError(w, http.StatusNotFound, "Event not found")
+		return
+	} else if err != nil {
+		apiServerError(w, r, "Error retrieving event", err)
+		return
+	}
+
+	event, found, err := s.store.GetEvent(id)
+	if err != nil {
+		apiServerError(w, r, "Error retrieving event", err)
+		return
+	}
+	if !found {
+		writeJSONError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if !s.canManageEvent(r, event) {
+		writeJSONError(w, http.StatusForbidden, "Invalid organizer token")
+		return
+	}
+
+	if err := s.store.DeleteEvent(id); err == errEventNotFound {
+		writeJSONError(w, http.StatusNotFound, "Event not found")
+		return
+	} else if err != nil {
+		apiServerError(w, r, "Error deleting event", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rsvpRequest - the JSON body accepted by the RSVP endpoints. `Name` and
+// `Affiliation` are only needed to RSVP. For a recurring event,
+// `Occurrence` is the key of a single occurrence, or empty for the whole
+// series.
+type rsvpRequest struct {
+	Email            string `json:"email"`
+	Name             string `json:"name"`
+	Affiliation      string `json:"affiliation"`
+	ConfirmationCode string `json:"confirmation_code"`
+	Occurrence       string `json:"occurrence"`
+}
+
+// rsvpResponse - the JSON body returned after an RSVP is made or cancelled.
+// `Status` is "pending" for a new RSVP, since it only counts once the email
+// address is verified.
+type rsvpResponse struct {
+	Status           string `json:"status,omitempty"`
+	Occurrence       string `json:"occurrence,omitempty"`
+	ConfirmationCode string `json:"confirmation_code,omitempty"`
+	WaitlistPosition int    `json:"waitlist_position,omitempty"`
+	Attending        *int   `json:"attending,omitempty"`
+}
+
+// apiRSVPController - handles POST /api/events/{id}/rsvp. It applies the
+// same checks as the RSVP form on the event page and responds with 202 once
+// the verification email is sent, 409 if the email already RSVP-ed, or 422
+// if the email is malformed or not allowed or the name is missing. The
+// confirmation code is shown on the page the emailed link leads to.
+func (s *server) apiRSVPController(w http.ResponseWriter, r *http.Request) {
+	id, err := s.eventIDParam(r)
+	if err == errEventNotFound {
+		writeJSONError(w, http.StatusNotFound, "Event not found")
+		return
+	} else if err != nil {
+		apiServerError(w, r, "Error retrieving event", err)
+		return
+	}
+
+	var req rsvpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	event, found, err := s.store.GetEvent(id)
+	if err != nil {
+		apiServerError(w, r, "Error retrieving event", err)
+		return
+	}
+	if !found {
+		writeJSONError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if req.Occurrence != "" && (event.Recurrence == nil || !event.selectOccurrence(req.Occurrence)) {
+		writeJSONError(w, http.StatusUnprocessableEntity, "Unknown occurrence")
+		return
+	}
+
+	addr, err := mail.ParseAddress(req.Email)
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, "Invalid email format")
+		return
+	}
+	if allowed, message := event.checkEmailPolicy(addr, s.defaults); !allowed {
+		writeJSONError(w, http.StatusUnprocessableEntity, message)
+		return
+	}
+	attendee, problem := newAttendee(addr.Address, req.Name, req.Affiliation)
+	if problem != "" {
+		writeJSONError(w, http.StatusUnprocessableEntity, problem)
+		return
+	}
+	if isAttending(event, addr.Address) || event.isPending(addr.Address) {
+		writeJSONError(w, http.StatusConflict, "Email is already RSVP-ed")
+		return
+	}
+	if event.waitlistPosition(addr.Address) > 0 {
+		writeJSONError(w, http.StatusConflict, "Email is already on the waitlist")
+		return
+	}
+
+	rsvp, err := s.store.AddRSVP(event.ID, attendee, req.Occurrence, s.confirmationCode(event.PublicID, attendee.Email))
+	if err != nil {
+		apiServerError(w, r, "Error saving RSVP", err)
+		return
+	}
+
+	// Without a token the RSVP was made by another request since the event
+	// was loaded, and is left to that request
+	if rsvp.VerifyToken == "" {
+		writeJSONError(w, http.StatusConflict, "Email is already RSVP-ed")
+		return
+	}
+	if err := s.sendRSVPVerification(event, addr.Address, rsvp); err != nil {
+		if _, cancelErr := s.store.CancelRSVP(event.ID, addr.Address, req.Occurrence); cancelErr != nil {
+			err = fmt.Errorf("%v; cancelling the RSVP: %w", err, cancelErr)
+		}
+		apiServerError(w, r, "Error sending confirmation email", err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, rsvpResponse{
+		Status:           "pending",
+		Occurrence:       rsvp.Occurrence,
+		WaitlistPosition: rsvp.WaitlistPosition,
+		Attending:        event.publicAttendeeCount(),
+	})
+}
+
+// apiCancelRSVPController - handles DELETE /api/events/{id}/rsvp, removing
+// the RSVP of the email in the request body. The body must also carry the
+// confirmation code that was issued for that RSVP.
+func (s *server) apiCancelRSVPController(w http.ResponseWriter, r *http.Request) {
+	id, err := s.eventIDParam(r)
+	if err == errEventNotFound {
+		writeJSONError(w, http.StatusNotFound, "Event not found")
+		return
+	} else if err != nil {
+		apiServerError(w, r, "Error retrieving event", err)
+		return
+	}
+
+	var req rsvpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	event, found, err := s.store.GetEvent(id)
+	if err != nil {
+		apiServerError(w, r, "Error retrieving event", err)
+		return
+	}
+	if !found {
+		writeJSONError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	if req.Occurrence != "" && (event.Recurrence == nil || !event.selectOccurrence(req.Occurrence)) {
+		writeJSONError(w, http.StatusNotFound, "Unknown occurrence")
+		return
+	}
+	if !isAttending(event, req.Email) && !event.isPending(req.Email) && event.waitlistPosition(req.Email) == 0 {
+		writeJSONError(w, http.StatusNotFound, "No RSVP found for this email")
+		return
+	}
+	valid, err := s.verifyConfirmationCode(event.ID, req.Email, req.ConfirmationCode)
+	if err != nil {
+		apiServerError(w, r, "Error checking confirmation code", err)
+		return
+	}
+	if !valid {
+		writeJSONError(w, http.StatusForbidden, "Invalid confirmation code")
+		return
+	}
+
+	removed, err := s.store.CancelRSVP(event.ID, req.Email, req.Occurrence)
+	if err != nil {
+		apiServerError(w, r, "Error cancelling RSVP", err)
+		return
+	}
+	if !removed {
+		writeJSONError(w, http.StatusNotFound, "No RSVP found for this email")
+		return
+	}
+
+	// Reload, since cancelling may have moved someone off the waitlist
+	event, _, err = s.store.GetEvent(event.ID)
+	if err != nil {
+		apiServerError(w, r, "Error retrieving event", err)
+		return
+	}
+	if req.Occurrence != "" {
+		event.selectOccurrence(req.Occurrence)
+	}
+	writeJSON(w, http.StatusOK, rsvpResponse{Occurrence: req.Occurrence, Attending: event.publicAttendeeCount()})
+}
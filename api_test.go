@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validEventJSON - returns the JSON body of a valid new event.
+func validEventJSON() string {
+	return `{"title":"Test party","location":"Evans Hall","image":"http://i.imgur.com/pXjrQ.gif","date":"` + futureDate() + `"}`
+}
+
+// createdEvent - the response to creating an event through the API.
+type createdEvent struct {
+	Event
+	OrganizerToken string `json:"organizer_token"`
+}
+
+// createAPIEvent - creates a valid event through the API and returns it
+// as the API sent it back, with its organizer token and the ID it is
+// stored under.
+func createAPIEvent(t *testing.T) createdEvent {
+	t.Helper()
+	w := serve(t, http.MethodPost, "/api/events", validEventJSON())
+	expectStatus(t, w, http.StatusCreated)
+	var event createdEvent
+	if err := json.Unmarshal(w.Body.Bytes(), &event); err != nil {
+		t.Fatalf("decoding the created event: %v", err)
+	}
+	id, found, err := testServer.store.EventIDByPublicID(event.PublicID)
+	if err != nil || !found {
+		t.Fatalf("EventIDByPublicID(%q) = %v, %v", event.PublicID, found, err)
+	}
+	event.ID = id
+	return event
+}
+
+// fieldsOf - decodes an apiError from `body` and returns the names of the
+// fields it rejected.
+func fieldsOf(t *testing.T, body []byte) []string {
+	t.Helper()
+	var apiErr apiError
+	if err := json.Unmarshal(body, &apiErr); err != nil {
+		t.Fatalf("decoding the error: %v", err)
+	}
+	fields := []string{}
+	for _, field := range apiErr.Fields {
+		fields = append(fields, field.Field)
+	}
+	return fields
+}
+
+func TestAPICreateEvent(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantFields []string
+	}{
+		{name: "valid", body: validEventJSON(), wantStatus: http.StatusCreated},
+		{name: "empty", body: `{}`, wantStatus: http.StatusUnprocessableEntity, wantFields: []string{"title", "location", "image", "date"}},
+		{
+			name:       "bad fields",
+			body:       `{"title":"Short","location":"Evans Hall","image":"http://i.imgur.com/pXjrQ.txt","date":"2001-01-01T10:00"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []string{"title", "image", "date"},
+		},
+		{name: "not JSON", body: `title=Test+party`, wantStatus: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := serve(t, http.MethodPost, "/api/events", test.body)
+			expectStatus(t, w, test.wantStatus)
+			if test.wantFields != nil {
+				if got := fieldsOf(t, w.Body.Bytes()); !reflect.DeepEqual(got, test.wantFields) {
+					t.Errorf("rejected fields = %v, want %v", got, test.wantFields)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIUpdateAndDeleteEvent(t *testing.T) {
+	event := createAPIEvent(t)
+	path := "/api/events/" + event.PublicID
+	if event.OrganizerToken == "" {
+		t.Fatal("no organizer token was returned")
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		token      string
+		wantStatus int
+		wantTitle  string
+	}{
+		{name: "patch without token", method: http.MethodPatch, body: `{"title":"Renamed party"}`, wantStatus: http.StatusForbidden},
+		{name: "patch with wrong token", method: http.MethodPatch, body: `{"title":"Renamed party"}`, token: newOrganizerToken(), wantStatus: http.StatusForbidden},
+		{name: "delete without token", method: http.MethodDelete, wantStatus: http.StatusForbidden},
+		{name: "patch title", method: http.MethodPatch, body: `{"title":"Renamed party"}`, token: event.OrganizerToken, wantStatus: http.StatusOK, wantTitle: "Renamed party"},
+		{name: "patch bad date", method: http.MethodPatch, body: `{"date":"yesterday"}`, token: event.OrganizerToken, wantStatus: http.StatusUnprocessableEntity},
+		{name: "put missing fields", method: http.MethodPut, body: `{"title":"Renamed again"}`, token: event.OrganizerToken, wantStatus: http.StatusUnprocessableEntity},
+		{name: "put everything", method: http.MethodPut, body: validEventJSON(), token: event.OrganizerToken, wantStatus: http.StatusOK, wantTitle: "Test party"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := serveOrganizer(t, test.method, path, test.body, test.token)
+			expectStatus(t, w, test.wantStatus)
+			if test.wantTitle == "" {
+				return
+			}
+			saved := serveOrganizer(t, http.MethodGet, path, "", event.OrganizerToken).Body.String()
+			if w.Body.String() != saved {
+				t.Errorf("response = %s, want the saved event %s", w.Body, saved)
+			}
+			var updated Event
+			if err := json.Unmarshal([]byte(saved), &updated); err != nil {
+				t.Fatalf("decoding the event: %v", err)
+			}
+			if updated.Title != test.wantTitle {
+				t.Errorf("title = %q, want %q", updated.Title, test.wantTitle)
+			}
+		})
+	}
+
+	expectStatus(t, serveOrganizer(t, http.MethodDelete, path, "", event.OrganizerToken), http.StatusNoContent)
+	expectStatus(t, serve(t, http.MethodGet, path, ""), http.StatusNotFound)
+	expectStatus(t, serveOrganizer(t, http.MethodDelete, path, "", event.OrganizerToken), http.StatusNotFound)
+	expectStatus(t, serveOrganizer(t, http.MethodPatch, path, `{"title":"Renamed party"}`, event.OrganizerToken), http.StatusNotFound)
+	expectStatus(t, serve(t, http.MethodDelete, "/api/events/nope", ""), http.StatusNotFound)
+}
+
+func TestAPIRSVP(t *testing.T) {
+	event := createAPIEvent(t)
+	path := "/api/events/" + event.PublicID + "/rsvp"
+	code := testServer.confirmationCode(event.PublicID, "a@yale.edu")
+
+	// The steps run in order; {code} stands for the confirmation code of
+	// the first RSVP, and `verify` follows the link emailed to that address
+	steps := []struct {
+		name          string
+		method        string
+		body          string
+		verify        string
+		wantStatus    int
+		wantAttending int
+	}{
+		{name: "RSVP", method: http.MethodPost, body: `{"email":"a@yale.edu","name":"Ann"}`, wantStatus: http.StatusAccepted},
+		{name: "RSVP while pending", method: http.MethodPost, body: `{"email":"a@yale.edu","name":"Ann"}`, verify: "a@yale.edu", wantStatus: http.StatusConflict},
+		{name: "RSVP again", method: http.MethodPost, body: `{"email":"a@yale.edu","name":"Ann"}`, wantStatus: http.StatusConflict},
+		{name: "no name", method: http.MethodPost, body: `{"email":"c@yale.edu","name":" "}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "malformed email", method: http.MethodPost, body: `{"email":"a-yale.edu","name":"Ann"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "email not allowed", method: http.MethodPost, body: `{"email":"a@gmail.com","name":"Ann"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "second RSVP", method: http.MethodPost, body: `{"email":"b@yale.edu","name":"Ann"}`, verify: "b@yale.edu", wantStatus: http.StatusAccepted},
+		{name: "cancel without code", method: http.MethodDelete, body: `{"email":"a@yale.edu"}`, wantStatus: http.StatusForbidden},
+		{name: "cancel with someone else's code", method: http.MethodDelete, body: `{"email":"b@yale.edu","confirmation_code":"{code}"}`, wantStatus: http.StatusForbidden},
+		{name: "cancel", method: http.MethodDelete, body: `{"email":"a@yale.edu","confirmation_code":"{code}"}`, wantStatus: http.StatusOK, wantAttending: 1},
+		{name: "cancel again", method: http.MethodDelete, body: `{"email":"a@yale.edu","confirmation_code":"{code}"}`, wantStatus: http.StatusNotFound},
+	}
+	for _, step := range steps {
+		w := serve(t, step.method, path, strings.ReplaceAll(step.body, "{code}", code))
+		if w.Code != step.wantStatus {
+			t.Fatalf("%s: status = %d, want %d; body: %s", step.name, w.Code, step.wantStatus, w.Body.String())
+		}
+		if step.verify != "" {
+			token := mailedToken(t, step.verify, "/verify")
+			expectStatus(t, serve(t, http.MethodGet, "/events/"+event.PublicID+"/verify?token="+url.QueryEscape(token), ""), http.StatusOK)
+		}
+		if step.wantAttending == 0 {
+			continue
+		}
+		var resp rsvpResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: decoding the response: %v", step.name, err)
+		}
+		if resp.Attending == nil || *resp.Attending != step.wantAttending {
+			t.Errorf("%s: attending = %v, want %d", step.name, resp.Attending, step.wantAttending)
+		}
+	}
+
+	expectStatus(t, serve(t, http.MethodPost, "/api/events/0/rsvp", `{"email":"a@yale.edu","name":"Ann"}`), http.StatusNotFound)
+}
+
+// listAPIEvents - follows the next links from `path` and returns the
+// titles of every event listed, in order, and how many pages there were.
+func listAPIEvents(t *testing.T, path string) ([]string, int) {
+	t.Helper()
+	titles := []string{}
+	pages := 0
+	for path != "" {
+		w := serve(t, http.MethodGet, path, "")
+		expectStatus(t, w, http.StatusOK)
+		var page eventPage
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("decoding the page: %v", err)
+		}
+		for _, event := range page.Events {
+			titles = append(titles, event.Title)
+		}
+		pages++
+		path = page.Next
+	}
+	return titles, pages
+}
+
+func TestAPIListEvents(t *testing.T) {
+	start := time.Now().AddDate(2, 0, 0)
+	for i, title := range []string{"Paging party C", "Paging party A", "Paging party D", "Paging party B", "Paging party E"} {
+		mustAddEvent(t, Event{Title: title, Location: "Evans Hall", Date: start.AddDate(0, 0, i)})
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		want      []string
+		wantPages int
+	}{
+		{name: "by date", path: "/api/events?q=paging&limit=2", want: []string{"Paging party C", "Paging party A", "Paging party D", "Paging party B", "Paging party E"}, wantPages: 3},
+		{name: "by date, latest first", path: "/api/events?q=Paging&limit=2&sort=-date", want: []string{"Paging party E", "Paging party B", "Paging party D", "Paging party A", "Paging party C"}, wantPages: 3},
+		{name: "by title", path: "/api/events?q=Paging&limit=3&sort=title", want: []string{"Paging party A", "Paging party B", "Paging party C", "Paging party D", "Paging party E"}, wantPages: 2},
+		{name: "one page", path: "/api/events?q=Paging", want: []string{"Paging party C", "Paging party A", "Paging party D", "Paging party B", "Paging party E"}, wantPages: 1},
+		{
+			name:      "date range",
+			path:      "/api/events?q=Paging&from=" + url.QueryEscape(start.AddDate(0, 0, 1).Format(time.RFC3339)) + "&to=" + url.QueryEscape(start.AddDate(0, 0, 3).Format(time.RFC3339)),
+			want:      []string{"Paging party A", "Paging party D"},
+			wantPages: 1,
+		},
+		{name: "no match", path: "/api/events?q=nothing+like+this", want: []string{}, wantPages: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			titles, pages := listAPIEvents(t, test.path)
+			if !reflect.DeepEqual(titles, test.want) {
+				t.Errorf("titles = %v, want %v", titles, test.want)
+			}
+			if pages != test.wantPages {
+				t.Errorf("%d pages, want %d", pages, test.wantPages)
+			}
+		})
+	}
+
+	// A cursor only works with the sort it was made for
+	var page eventPage
+	json.Unmarshal(serve(t, http.MethodGet, "/api/events?q=Paging&limit=2", "").Body.Bytes(), &page)
+	next, err := url.Parse(page.Next)
+	if err != nil || next.Query().Get("cursor") == "" {
+		t.Fatalf("next link %q has no cursor", page.Next)
+	}
+	for _, path := range []string{
+		"/api/events?sort=title&cursor=" + next.Query().Get("cursor"),
+		"/api/events?cursor=not-a-cursor",
+		"/api/events?limit=0",
+		"/api/events?limit=" + strconv.Itoa(maxEventPageSize+1),
+		"/api/events?sort=location",
+		"/api/events?from=yesterday",
+	} {
+		expectStatus(t, serve(t, http.MethodGet, path, ""), http.StatusBadRequest)
+	}
+}
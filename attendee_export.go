@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Attendee export statuses: whether the RSVP's email address was verified,
+// or whether they are still waiting for a spot.
+const (
+	rsvpStatusAttending  = "attending"
+	rsvpStatusPending    = "pending"
+	rsvpStatusWaitlisted = "waitlisted"
+)
+
+// AttendeeRecord - one RSVP to an event as exported to its organizer.
+// RSVPAt is nil for RSVPs made before their time was recorded.
+// Occurrence is the key of the occurrence of a recurring event the RSVP is
+// for, or empty for the whole event. WaitlistPosition is where a waitlisted
+// person is in line for it, counting from 1, and 0 for everyone else.
+type AttendeeRecord struct {
+	Name             string     `json:"name"`
+	Affiliation      string     `json:"affiliation"`
+	Email            string     `json:"email"`
+	RSVPAt           *time.Time `json:"rsvp_at"`
+	Status           string     `json:"status"`
+	Occurrence       string     `json:"occurrence,omitempty"`
+	ConfirmationCode string     `json:"confirmation_code"`
+	WaitlistPosition int        `json:"waitlist_position,omitempty"`
+}
+
+// attendeeCSVHeader names the columns of attendees.csv.
+var attendeeCSVHeader = []string{"name", "affiliation", "email", "rsvp_at", "status", "occurrence", "confirmation_code", "waitlist_position"}
+
+// csvRow - returns `record` as a row of attendees.csv.
+func (record AttendeeRecord) csvRow() []string {
+	rsvpAt := ""
+	if record.RSVPAt != nil {
+		rsvpAt = record.RSVPAt.Format(time.RFC3339)
+	}
+	position := ""
+	if record.WaitlistPosition > 0 {
+		position = strconv.Itoa(record.WaitlistPosition)
+	}
+	return []string{
+		csvCell(record.Name),
+		csvCell(record.Affiliation),
+		csvCell(record.Email),
+		rsvpAt,
+		record.Status,
+		record.Occurrence,
+		record.ConfirmationCode,
+		position,
+	}
+}
+
+// csvCell - escapes text typed in by attendees so spreadsheets show it
+// rather than running it as a formula, by putting a quote in front of
+// anything that starts like one.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// attendeeExportController - handles GET /events/{id}/attendees.csv and
+// /events/{id}/attendees.json, which give the event's organizer every RSVP
+// to it, verified or not, and everyone on its waitlist, for name badges and
+// catering. The rows are written as they are read rather than loaded into
+// memory first.
+func (s *server) attendeeExportController(format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := s.eventIDParam(r)
+		if err == errEventNotFound {
+			s.renderError(w, http.StatusNotFound, "Event not found")
+			return
+		} else if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+
+		event, found, err := s.store.GetEvent(id)
+		if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+		if !found {
+			s.renderError(w, http.StatusNotFound, "Event not found")
+			return
+		}
+		if !s.canManageEvent(r, event) {
+			s.renderError(w, http.StatusForbidden, "Invalid organizer link")
+			return
+		}
+
+		// Times are given in the event's time zone
+		loc := event.location()
+		localize := func(record *AttendeeRecord) {
+			if record.RSVPAt != nil {
+				rsvpAt := record.RSVPAt.In(loc)
+				record.RSVPAt = &rsvpAt
+			}
+		}
+
+		// Once the first row is written the status can no longer change, so
+		// errors after that can only be logged
+		w.Header().Set("Content-Disposition", `attachment; filename="`+event.Slug+`-attendees.`+format+`"`)
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			out := csv.NewWriter(w)
+			out.Write(attendeeCSVHeader)
+			err = s.store.EachAttendeeRecord(id, func(record AttendeeRecord) error {
+				localize(&record)
+				return out.Write(record.csvRow())
+			})
+			out.Flush()
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			separator := "["
+			err = s.store.EachAttendeeRecord(id, func(record AttendeeRecord) error {
+				localize(&record)
+				if _, err := w.Write([]byte(separator)); err != nil {
+					return err
+				}
+				separator = ","
+				return enc.Encode(record)
+			})
+			// After an error the array is left unclosed, so that a cut-off
+			// list is not mistaken for the whole one
+			if err == nil {
+				if separator == "[" {
+					w.Write([]byte("["))
+				}
+				w.Write([]byte("]\n"))
+			}
+		}
+		if err != nil {
+			log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		}
+	}
+}
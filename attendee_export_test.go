@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSVCell(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "", want: ""},
+		{in: "Ann", want: "Ann"},
+		{in: "=HYPERLINK(\"x\")", want: "'=HYPERLINK(\"x\")"},
+		{in: "+1 555", want: "'+1 555"},
+		{in: "-2", want: "'-2"},
+		{in: "@SUM(A1)", want: "'@SUM(A1)"},
+		{in: "\tcmd", want: "'\tcmd"},
+		{in: "a=b", want: "a=b"},
+	}
+	for _, test := range tests {
+		if got := csvCell(test.in); got != test.want {
+			t.Errorf("csvCell(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestAttendeeExport(t *testing.T) {
+	event := createAPIEvent(t)
+	base := "/events/" + mustGetEvent(t, event.ID).PathID()
+
+	// Organizers who came in through their link keep the token on the
+	// links to the export
+	w := serve(t, http.MethodGet, base+"?token="+event.OrganizerToken, "")
+	expectStatus(t, w, http.StatusOK)
+	if want := base + "/attendees.csv?token=" + event.OrganizerToken; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("event page does not link to %s", want)
+	}
+
+	// Nobody has RSVP-ed yet
+	w = serveOrganizer(t, http.MethodGet, base+"/attendees.json", "", event.OrganizerToken)
+	expectStatus(t, w, http.StatusOK)
+	if got := strings.TrimSpace(w.Body.String()); got != "[]" {
+		t.Errorf("empty export = %q, want []", got)
+	}
+
+	rsvp, err := testServer.store.AddRSVP(event.ID, Attendee{Email: "ann@yale.edu", DisplayName: "=Ann", Affiliation: "SOM"}, "", testServer.confirmationCode(event.PublicID, "ann@yale.edu"))
+	if err != nil {
+		t.Fatalf("AddRSVP: %v", err)
+	}
+	if _, _, err := testServer.store.VerifyRSVP(event.ID, rsvp.VerifyToken); err != nil {
+		t.Fatalf("VerifyRSVP: %v", err)
+	}
+	if _, err := testServer.store.AddRSVP(event.ID, Attendee{Email: "bob@yale.edu", DisplayName: "Bob"}, "", testServer.confirmationCode(event.PublicID, "bob@yale.edu")); err != nil {
+		t.Fatalf("AddRSVP: %v", err)
+	}
+
+	for _, format := range []string{"csv", "json"} {
+		expectStatus(t, serve(t, http.MethodGet, base+"/attendees."+format, ""), http.StatusForbidden)
+		expectStatus(t, serveOrganizer(t, http.MethodGet, base+"/attendees."+format, "", "wrong"), http.StatusForbidden)
+	}
+
+	w = serveOrganizer(t, http.MethodGet, base+"/attendees.csv", "", event.OrganizerToken)
+	expectStatus(t, w, http.StatusOK)
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/csv") {
+		t.Errorf("Content-Type = %q, want text/csv", got)
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("reading the CSV: %v", err)
+	}
+	if len(rows) != 3 || !reflect.DeepEqual(rows[0], attendeeCSVHeader) {
+		t.Fatalf("CSV = %q, want the header and 2 rows", rows)
+	}
+	if got := rows[1][:3]; !reflect.DeepEqual(got, []string{"'=Ann", "SOM", "ann@yale.edu"}) {
+		t.Errorf("first row starts %q, want the name escaped", got)
+	}
+	if rows[1][4] != rsvpStatusAttending || rows[2][4] != rsvpStatusPending {
+		t.Errorf("statuses = %q, %q, want attending then pending", rows[1][4], rows[2][4])
+	}
+	if _, err := time.Parse(time.RFC3339, rows[1][3]); err != nil {
+		t.Errorf("rsvp_at %q is not RFC 3339", rows[1][3])
+	}
+
+	w = serveOrganizer(t, http.MethodGet, base+"/attendees.json", "", event.OrganizerToken)
+	expectStatus(t, w, http.StatusOK)
+	var records []AttendeeRecord
+	if err := json.Unmarshal(w.Body.Bytes(), &records); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+	if len(records) != 2 || records[0].Name != "=Ann" || records[1].Email != "bob@yale.edu" {
+		t.Errorf("JSON records = %+v, want Ann's unescaped name then Bob", records)
+	}
+}
+
+// brokenExportStore - a Store that fails after exporting the first
+// attendee record of an event.
+type brokenExportStore struct {
+	Store
+}
+
+// EachAttendeeRecord - passes the first record to `fn`, then fails.
+func (s brokenExportStore) EachAttendeeRecord(eventID int, fn func(AttendeeRecord) error) error {
+	return s.Store.EachAttendeeRecord(eventID, func(record AttendeeRecord) error {
+		if err := fn(record); err != nil {
+			return err
+		}
+		return errors.New("database went away")
+	})
+}
+
+func TestAttendeeExportError(t *testing.T) {
+	s := *testServer
+	s.store = brokenExportStore{testServer.store}
+	event := createAPIEvent(t)
+	for _, email := range []string{"ann@yale.edu", "bob@yale.edu"} {
+		if _, err := testServer.store.AddRSVP(event.ID, Attendee{Email: email, DisplayName: "Ann"}, "", testServer.confirmationCode(event.PublicID, email)); err != nil {
+			t.Fatalf("AddRSVP: %v", err)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/events/"+event.PublicID+"/attendees.json?token="+event.OrganizerToken, nil)
+	w := httptest.NewRecorder()
+	createRoutes(&s).ServeHTTP(w, r)
+	var records []AttendeeRecord
+	if err := json.Unmarshal(w.Body.Bytes(), &records); err == nil {
+		t.Errorf("an export cut off by an error is valid JSON: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+// Attendee visibilities decide how much of an event's attendee list is
+// shown to people other than its organizer, who always sees all of it.
+const (
+	attendeeVisibilityPublic = "public" // names and affiliations are shown
+	attendeeVisibilityCount  = "count"  // only the number attending is shown
+	attendeeVisibilityHidden = "hidden" // nothing about attendees is shown
+)
+
+// isValidAttendeeVisibility - reports whether `visibility` is one of the
+// known attendee visibilities.
+func isValidAttendeeVisibility(visibility string) bool {
+	switch visibility {
+	case attendeeVisibilityPublic, attendeeVisibilityCount, attendeeVisibilityHidden:
+		return true
+	}
+	return false
+}
+
+// attendeeVisibility - returns the attendee visibility in effect for
+// `event`. Events that do not set one show their attendees, as all events
+// did before the setting existed.
+func (event Event) attendeeVisibility() string {
+	if event.AttendeeVisibility == "" {
+		return attendeeVisibilityPublic
+	}
+	return event.AttendeeVisibility
+}
+
+// ShowsAttendeeNames - reports whether everyone may see who is attending.
+func (event Event) ShowsAttendeeNames() bool {
+	return event.attendeeVisibility() == attendeeVisibilityPublic
+}
+
+// ShowsAttendeeCount - reports whether everyone may see how many are
+// attending.
+func (event Event) ShowsAttendeeCount() bool {
+	return event.attendeeVisibility() != attendeeVisibilityHidden
+}
+
+// publicAttendeeCount - returns the number attending `event` if everyone
+// may see it, or nil if not.
+func (event Event) publicAttendeeCount() *int {
+	if !event.ShowsAttendeeCount() {
+		return nil
+	}
+	count := len(event.Attending)
+	return &count
+}
+
+// restrictAttendees - cuts the attendees of the event, and of each of its
+// occurrences, down to what its attendee visibility lets anyone but its
+// organizer see. Emails are always blanked; a count-only event keeps just
+// AttendeeCount and a hidden one nothing at all.
+func (event *Event) restrictAttendees() {
+	event.hideAttendeeEmails()
+	if event.ShowsAttendeeNames() {
+		return
+	}
+	if event.ShowsAttendeeCount() {
+		event.AttendeeCount = event.publicAttendeeCount()
+		for i := range event.Occurrences {
+			count := len(event.Occurrences[i].Attending)
+			event.Occurrences[i].AttendeeCount = &count
+		}
+	}
+	event.Attending = nil
+	for i := range event.Occurrences {
+		event.Occurrences[i].Attending = nil
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestrictAttendees(t *testing.T) {
+	attendees := []Attendee{
+		{Email: "a@yale.edu", DisplayName: "Ann", Affiliation: "SOM"},
+		{Email: "b@yale.edu", DisplayName: "Bob"},
+	}
+	two := 2
+	tests := []struct {
+		visibility    string
+		wantAttending []Attendee
+		wantCount     *int
+	}{
+		{visibility: "", wantAttending: []Attendee{{DisplayName: "Ann", Affiliation: "SOM"}, {DisplayName: "Bob"}}},
+		{visibility: attendeeVisibilityPublic, wantAttending: []Attendee{{DisplayName: "Ann", Affiliation: "SOM"}, {DisplayName: "Bob"}}},
+		{visibility: attendeeVisibilityCount, wantCount: &two},
+		{visibility: attendeeVisibilityHidden},
+	}
+	for _, test := range tests {
+		t.Run("visibility "+test.visibility, func(t *testing.T) {
+			event := Event{
+				Date:               time.Date(2030, 1, 7, 18, 0, 0, 0, time.UTC),
+				AttendeeVisibility: test.visibility,
+				Attending:          append([]Attendee{}, attendees...),
+				Recurrence:         &Recurrence{Freq: recurWeekly, Interval: 1, Count: 2},
+			}
+			event.listOccurrences(event.Date, event.Date.AddDate(0, 1, 0), 0)
+			event.restrictAttendees()
+
+			if !reflect.DeepEqual(event.Attending, test.wantAttending) {
+				t.Errorf("attending = %v, want %v", event.Attending, test.wantAttending)
+			}
+			if !reflect.DeepEqual(event.AttendeeCount, test.wantCount) {
+				t.Errorf("attendee count = %v, want %v", event.AttendeeCount, test.wantCount)
+			}
+			for _, occurrence := range event.Occurrences {
+				if !reflect.DeepEqual(occurrence.Attending, test.wantAttending) {
+					t.Errorf("attending %s = %v, want %v", occurrence.Key, occurrence.Attending, test.wantAttending)
+				}
+				if !reflect.DeepEqual(occurrence.AttendeeCount, test.wantCount) {
+					t.Errorf("attendee count of %s = %v, want %v", occurrence.Key, occurrence.AttendeeCount, test.wantCount)
+				}
+			}
+			if attendees[0].Email != "a@yale.edu" {
+				t.Error("restrictAttendees changed the attendees it was given")
+			}
+		})
+	}
+}
+
+func TestAttendeeVisibilityPages(t *testing.T) {
+	tests := []struct {
+		visibility string
+		wantName   bool
+		wantCount  bool
+	}{
+		{visibility: attendeeVisibilityPublic, wantName: true, wantCount: true},
+		{visibility: attendeeVisibilityCount, wantCount: true},
+		{visibility: attendeeVisibilityHidden},
+	}
+	for _, test := range tests {
+		t.Run(test.visibility, func(t *testing.T) {
+			event := createAPIEvent(t)
+			body := `{"attendee_visibility":"` + test.visibility + `","capacity":5}`
+			expectStatus(t, serveOrganizer(t, http.MethodPatch, "/api/events/"+event.PublicID, body, event.OrganizerToken), http.StatusOK)
+			rsvp, err := testServer.store.AddRSVP(event.ID, Attendee{Email: "a@yale.edu", DisplayName: "Ann Attendee"}, "", testServer.confirmationCode(event.PublicID, "a@yale.edu"))
+			if err != nil {
+				t.Fatalf("AddRSVP: %v", err)
+			}
+			if _, _, err := testServer.store.VerifyRSVP(event.ID, rsvp.VerifyToken); err != nil {
+				t.Fatalf("VerifyRSVP: %v", err)
+			}
+
+			for _, path := range []string{"/events/" + event.PathID(), "/api/events/" + event.PublicID} {
+				w := serve(t, http.MethodGet, path, "")
+				expectStatus(t, w, http.StatusOK)
+				if got := strings.Contains(w.Body.String(), "Ann Attendee"); got != test.wantName {
+					t.Errorf("%s: name shown = %v, want %v", path, got, test.wantName)
+				}
+				w = serveOrganizer(t, http.MethodGet, path, "", event.OrganizerToken)
+				if !strings.Contains(w.Body.String(), "Ann Attendee") {
+					t.Errorf("%s: the organizer does not see the attendee", path)
+				}
+			}
+
+			// The number of spots left gives the attendee count away
+			w := serve(t, http.MethodGet, "/events/"+event.PathID(), "")
+			if got := strings.Contains(w.Body.String(), "4 spots left"); got != test.wantCount {
+				t.Errorf("spots left shown = %v, want %v", got, test.wantCount)
+			}
+			w = serveOrganizer(t, http.MethodGet, "/events/"+event.PathID(), "", event.OrganizerToken)
+			if !strings.Contains(w.Body.String(), "4 spots left") {
+				t.Error("the organizer does not see the spots left")
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadAttendees(t *testing.T) {
+	var ids []int
+	for i, emails := range [][]string{{"a@yale.edu", "b@yale.edu"}, {}, {"c@yale.edu"}} {
+		id := mustAddEvent(t, Event{Title: "Batch party", Date: time.Now().AddDate(1, 0, i)})
+		for _, email := range emails {
+			rsvp, err := testServer.store.AddRSVP(id, Attendee{Email: email}, "", testConfirmationCode(t, testServer.store, id, email))
+			if err != nil {
+				t.Fatalf("AddRSVP: %v", err)
+			}
+			testServer.store.VerifyRSVP(id, rsvp.VerifyToken)
+		}
+		if _, err := testServer.store.AddRSVP(id, Attendee{Email: "pending@yale.edu"}, "", testConfirmationCode(t, testServer.store, id, "pending@yale.edu")); err != nil {
+			t.Fatalf("AddRSVP: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	want := map[int][]string{ids[0]: {"a@yale.edu", "b@yale.edu"}, ids[1]: {}, ids[2]: {"c@yale.edu"}}
+
+	// Put the events past the first batch, behind events that do not exist
+	events := make([]Event, attendeeBatchSize)
+	for i := range events {
+		events[i].ID = -1 - i
+	}
+	for _, id := range ids {
+		events = append(events, Event{ID: id})
+	}
+	store := &sqliteStore{db: testDB}
+	if err := store.loadAttendees(events); err != nil {
+		t.Fatalf("loadAttendees: %v", err)
+	}
+	for _, event := range events {
+		if event.ID < 0 && event.Attending != nil {
+			t.Errorf("event %d that does not exist has attendees %v", event.ID, event.Attending)
+		}
+		if event.ID > 0 && !reflect.DeepEqual(attendeeEmails(event.Attending), want[event.ID]) {
+			t.Errorf("attending %d = %v, want %v", event.ID, event.Attending, want[event.ID])
+		}
+	}
+
+	all, _, err := store.ListEvents(eventQuery{Attendees: true})
+	if err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	for _, event := range all {
+		if single := mustGetEvent(t, event.ID); !reflect.DeepEqual(event.Attending, single.Attending) {
+			t.Errorf("ListEvents lists %v attending %d, GetEvent %v", event.Attending, event.ID, single.Attending)
+		}
+	}
+}
+
+func TestNewAttendee(t *testing.T) {
+	long := strings.Repeat("x", maxAttendeeFieldLength+1)
+	tests := []struct {
+		name        string
+		displayName string
+		affiliation string
+		want        Attendee
+		wantProblem bool
+	}{
+		{name: "name and affiliation", displayName: " Ann ", affiliation: " Yale SOM ", want: Attendee{Email: "a@yale.edu", DisplayName: "Ann", Affiliation: "Yale SOM"}},
+		{name: "no affiliation", displayName: "Ann", want: Attendee{Email: "a@yale.edu", DisplayName: "Ann"}},
+		{name: "no name", displayName: "  ", wantProblem: true},
+		{name: "long name", displayName: long, wantProblem: true},
+		{name: "long affiliation", displayName: "Ann", affiliation: long, wantProblem: true},
+		{name: "multi-byte name at the limit", displayName: strings.Repeat("ü", maxAttendeeFieldLength), want: Attendee{Email: "a@yale.edu", DisplayName: strings.Repeat("ü", maxAttendeeFieldLength)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, problem := newAttendee("a@yale.edu", test.displayName, test.affiliation)
+			if (problem != "") != test.wantProblem {
+				t.Fatalf("problem = %q, want one: %v", problem, test.wantProblem)
+			}
+			if !test.wantProblem && got != test.want {
+				t.Errorf("newAttendee = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAttendeeEmailsHidden(t *testing.T) {
+	event := createAPIEvent(t)
+	rsvp, err := testServer.store.AddRSVP(event.ID, Attendee{Email: "a@yale.edu", DisplayName: "Ann", Affiliation: "Yale SOM"}, "", testServer.confirmationCode(event.PublicID, "a@yale.edu"))
+	if err != nil {
+		t.Fatalf("AddRSVP: %v", err)
+	}
+	if _, _, err := testServer.store.VerifyRSVP(event.ID, rsvp.VerifyToken); err != nil {
+		t.Fatalf("VerifyRSVP: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		token     string
+		wantEmail bool
+	}{
+		{name: "event page", path: "/events/" + event.PathID()},
+		{name: "event page for the organizer", path: "/events/" + event.PathID() + "?token=" + url.QueryEscape(event.OrganizerToken), wantEmail: true},
+		{name: "API", path: "/api/events/" + event.PublicID},
+		{name: "API for the organizer", path: "/api/events/" + event.PublicID, token: event.OrganizerToken, wantEmail: true},
+		{name: "API listing", path: "/api/events?q=" + url.QueryEscape(event.Title), token: event.OrganizerToken},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := serveOrganizer(t, http.MethodGet, test.path, "", test.token)
+			expectStatus(t, w, http.StatusOK)
+			if !strings.Contains(w.Body.String(), "Ann") || !strings.Contains(w.Body.String(), "Yale SOM") {
+				t.Errorf("the attendee's name and affiliation are not shown: %s", w.Body.String())
+			}
+			if got := strings.Contains(w.Body.String(), "a@yale.edu"); got != test.wantEmail {
+				t.Errorf("email shown = %v, want %v", got, test.wantEmail)
+			}
+		})
+	}
+}
+
+func TestLegacyAttendeeName(t *testing.T) {
+	// Someone who RSVP-ed before names were asked for
+	event := createAPIEvent(t)
+	rsvp, err := testServer.store.AddRSVP(event.ID, Attendee{Email: "a@yale.edu"}, "", testServer.confirmationCode(event.PublicID, "a@yale.edu"))
+	if err != nil {
+		t.Fatalf("AddRSVP: %v", err)
+	}
+	if _, _, err := testServer.store.VerifyRSVP(event.ID, rsvp.VerifyToken); err != nil {
+		t.Fatalf("VerifyRSVP: %v", err)
+	}
+
+	w := serve(t, http.MethodGet, "/api/events/"+event.PublicID, "")
+	expectStatus(t, w, http.StatusOK)
+	if body := w.Body.String(); !strings.Contains(body, `"attending":[{}]`) {
+		t.Errorf("the attendee without a name is not listed without one: %s", body)
+	}
+
+	w = serve(t, http.MethodGet, "/events/"+event.PathID(), "")
+	expectStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), "Guest") {
+		t.Errorf("the attendee without a name is not shown as a guest")
+	}
+}
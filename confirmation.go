@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// loadConfirmationKey - returns the server-side secret that confirmation
+// codes are derived from. Set CONFIRMATION_SECRET so that codes issued by
+// different runs of the server come from the same key; otherwise a random
+// key is generated at startup. Codes already handed out stay valid either
+// way because they are stored alongside each RSVP.
+func loadConfirmationKey() []byte {
+	if secret := getEnv("CONFIRMATION_SECRET", ""); secret != "" {
+		return []byte(secret)
+	}
+	log.Println("CONFIRMATION_SECRET is not set; using a random key for confirmation codes")
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// confirmationCode - returns the code issued to `email` when they RSVP to
+// the event with the public ID `publicID`. It is an HMAC of the public ID
+// and the email under the server's confirmationKey, so it differs from
+// event to event, even if a deleted event's ID is reused, and cannot be
+// computed by someone who only knows the email.
+func (s *server) confirmationCode(publicID string, email string) string {
+	mac := hmac.New(sha256.New, s.confirmationKey)
+	mac.Write([]byte(publicID + "\x00" + strings.ToLower(email)))
+	return base32.StdEncoding.EncodeToString(mac.Sum(nil))[:10]
+}
+
+// verifyConfirmationCode - reports whether `code` is the confirmation code
+// stored for the RSVP of `email` to the event with the specified id.
+func (s *server) verifyConfirmationCode(eventID int, email string, code string) (bool, error) {
+	expected, found, err := s.store.ConfirmationCode(eventID, email)
+	if err != nil || !found {
+		return false, err
+	}
+	code = strings.ToUpper(strings.TrimSpace(code))
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1, nil
+}
+
+// sendRSVPVerification - emails `email` the link that confirms their
+// pending RSVP to `event`.
+func (s *server) sendRSVPVerification(event Event, email string, rsvp RSVP) error {
+	link := s.baseURL + "/events/" + event.PathID() + "/verify?token=" + url.QueryEscape(rsvp.VerifyToken)
+
+	var body strings.Builder
+	body.WriteString("Hi,\n\n")
+	if rsvp.WaitlistPosition > 0 {
+		body.WriteString(event.Title + " is full, so you have been put on the waitlist at #" + strconv.Itoa(rsvp.WaitlistPosition) + ".\n")
+		body.WriteString("Please confirm your email address to keep your place by visiting:\n\n")
+	} else if event.Recurrence != nil && rsvp.Occurrence == "" {
+		body.WriteString("Please confirm your RSVP to every date of " + event.Title + " (" + event.Recurrence.Describe() + ") by visiting:\n\n")
+	} else {
+		body.WriteString("Please confirm your RSVP to " + event.Title + " on " + event.Date.Format("January 2, 2006 at 3:04 PM MST") + " by visiting:\n\n")
+	}
+	body.WriteString(link + "\n\n")
+	body.WriteString("The link expires in " + strconv.Itoa(int(pendingRSVPLifetime.Hours())) + " hours. If you did not RSVP, you can ignore this email.\n")
+
+	return s.mailer.Send(email, "Confirm your RSVP to "+event.Title, body.String())
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfirmationCode(t *testing.T) {
+	s := &server{confirmationKey: []byte("test secret")}
+	code := s.confirmationCode("k3xq7vnb", "a@yale.edu")
+	if len(code) != 10 {
+		t.Errorf("code %q has %d characters, want 10", code, len(code))
+	}
+	tests := []struct {
+		name     string
+		publicID string
+		email    string
+		wantSame bool
+	}{
+		{name: "same RSVP", publicID: "k3xq7vnb", email: "a@yale.edu", wantSame: true},
+		{name: "email in other case", publicID: "k3xq7vnb", email: "A@Yale.edu", wantSame: true},
+		{name: "other event", publicID: "m2pq4rst", email: "a@yale.edu"},
+		{name: "other email", publicID: "k3xq7vnb", email: "b@yale.edu"},
+		{name: "ID and email run together", publicID: "k3xq7vnba", email: "@yale.edu"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := s.confirmationCode(test.publicID, test.email); (got == code) != test.wantSame {
+				t.Errorf("confirmationCode(%q, %q) = %q, first code %q", test.publicID, test.email, got, code)
+			}
+		})
+	}
+
+	other := &server{confirmationKey: []byte("another secret")}
+	if other.confirmationCode("k3xq7vnb", "a@yale.edu") == code {
+		t.Error("code does not depend on the secret key")
+	}
+}
+
+func TestVerifyConfirmationCode(t *testing.T) {
+	event := createAPIEvent(t)
+	id, otherID := event.ID, createAPIEvent(t).ID
+	rsvp, err := testServer.store.AddRSVP(id, Attendee{Email: "a@yale.edu"}, "", testServer.confirmationCode(event.PublicID, "a@yale.edu"))
+	if err != nil {
+		t.Fatalf("AddRSVP: %v", err)
+	}
+	otherRSVP, err := testServer.store.AddRSVP(id, Attendee{Email: "b@yale.edu"}, "", testServer.confirmationCode(event.PublicID, "b@yale.edu"))
+	if err != nil {
+		t.Fatalf("AddRSVP: %v", err)
+	}
+	code, otherCode := rsvp.ConfirmationCode, otherRSVP.ConfirmationCode
+
+	tests := []struct {
+		name    string
+		eventID int
+		email   string
+		code    string
+		want    bool
+	}{
+		{name: "issued code", eventID: id, email: "a@yale.edu", code: code, want: true},
+		{name: "typed loosely", eventID: id, email: "a@yale.edu", code: " " + strings.ToLower(code) + " ", want: true},
+		{name: "someone else's code", eventID: id, email: "a@yale.edu", code: otherCode},
+		{name: "no code", eventID: id, email: "a@yale.edu"},
+		{name: "no RSVP", eventID: id, email: "c@yale.edu", code: testServer.confirmationCode(event.PublicID, "c@yale.edu")},
+		{name: "other event", eventID: otherID, email: "a@yale.edu", code: code},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := testServer.verifyConfirmationCode(test.eventID, test.email, test.code)
+			if err != nil {
+				t.Fatalf("verifyConfirmationCode: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("verifyConfirmationCode = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
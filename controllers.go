@@ -1,15 +1,16 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/mail"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 func isValidURL(u string) bool {
@@ -31,9 +32,10 @@ func isValidImageURL(filename string) bool {
 	return false
 }
 
-func isFutureDate(dateStr string) (bool, time.Time) {
-	// Parse the input string to a time.Time object (assuming the format is "2006-01-02" for date)
-	date, err := time.Parse("2006-01-02T15:04", dateStr)
+func isFutureDate(dateStr string, loc *time.Location) (bool, time.Time) {
+	// Parse the input string to a time.Time object, as a wall-clock time in
+	// the event's time zone
+	date, err := parseEventTime(dateStr, loc)
 	if err != nil {
 		return false, date
 	}
@@ -51,166 +53,630 @@ func isFutureDate(dateStr string) (bool, time.Time) {
 	// return fiveHoursLater.Before(date), date
 }
 
-func indexController(w http.ResponseWriter, r *http.Request) {
+// FieldError - describes why a single submitted event field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// eventFields - the user-editable fields of an event as submitted through
+// the HTML form or the JSON API. A nil field was not submitted at all.
+type eventFields struct {
+	Title              *string           `json:"title"`
+	Location           *string           `json:"location"`
+	Image              *string           `json:"image"`
+	Date               *string           `json:"date"`
+	EndDate            *string           `json:"end_date"`
+	TimeZone           *string           `json:"time_zone"`
+	Recurrence         *recurrenceFields `json:"recurrence"`
+	EmailPolicy        *string           `json:"email_policy"`
+	EmailDomains       *[]string         `json:"email_domains"`
+	Capacity           *int              `json:"capacity"`
+	AttendeeVisibility *string           `json:"attendee_visibility"`
+}
+
+// apply validates each submitted field and copies the valid ones onto
+// `event`, returning one FieldError per rejected field. When `partial` is
+// true, fields that were not submitted are left alone; otherwise they are
+// reported as missing. The end date, time zone, recurrence, email policy,
+// domains, capacity and attendee visibility are always optional; an empty
+// end date removes it.
+// Dates are read in the submitted time zone, or else the event's. A new
+// date must be in the future, but the event's current date is kept even
+// once it has passed.
+func (f eventFields) apply(event *Event, partial bool) []FieldError {
+	var errs []FieldError
+	missing := func(field string) {
+		if !partial {
+			errs = append(errs, FieldError{Field: field, Message: "Missing " + field + "."})
+		}
+	}
+
+	if f.Title == nil {
+		missing("title")
+	} else if len(*f.Title) < 6 || len(*f.Title) > 49 {
+		errs = append(errs, FieldError{Field: "title", Message: "Bad Title! Must be between 6 and 49 characters."})
+	} else if strings.IndexFunc(*f.Title, unicode.IsControl) >= 0 {
+		errs = append(errs, FieldError{Field: "title", Message: "Bad Title! Must be a single line without control characters."})
+	} else {
+		event.Title = *f.Title
+	}
+
+	if f.Location == nil {
+		missing("location")
+	} else if len(*f.Location) < 6 || len(*f.Location) > 49 {
+		errs = append(errs, FieldError{Field: "location", Message: "Bad Location! Must be between 6 and 49 characters."})
+	} else if strings.IndexFunc(*f.Location, unicode.IsControl) >= 0 {
+		errs = append(errs, FieldError{Field: "location", Message: "Bad Location! Must be a single line without control characters."})
+	} else {
+		event.Location = *f.Location
+	}
+
+	if f.Image == nil {
+		missing("image")
+	} else if !isValidImageURL(*f.Image) {
+		errs = append(errs, FieldError{Field: "image", Message: "Bad URL! Must link to a .png, .jpg, .jpeg, .gif or .gifv image."})
+	} else {
+		event.Image = *f.Image
+	}
+
+	loc := event.location()
+	if f.TimeZone != nil && *f.TimeZone != "" {
+		if tz, ok := loadTimeZone(*f.TimeZone); !ok {
+			errs = append(errs, FieldError{Field: "time_zone", Message: "Bad Time Zone! Must be an IANA time zone such as America/New_York."})
+		} else {
+			if *f.TimeZone != event.TimeZone {
+				// Moving an event to another time zone keeps its wall-clock
+				// times, so a party at 7 PM is still at 7 PM there unless a
+				// new date is also given
+				event.Date = wallClockIn(event.Date, tz)
+				if event.EndDate != nil {
+					end := wallClockIn(*event.EndDate, tz)
+					event.EndDate = &end
+				}
+			}
+			event.TimeZone = *f.TimeZone
+			loc = tz
+		}
+	}
+
+	if f.Date == nil {
+		missing("date")
+	} else if date, err := parseEventTime(*f.Date, loc); err == nil && date.Equal(event.Date) {
+		// Resubmitting the start of an event, or series, that has begun
+		// must not stop the rest of it from being edited
+	} else if ok, date := isFutureDate(*f.Date, loc); !ok {
+		errs = append(errs, FieldError{Field: "date", Message: "Bad Date! Must be in the future, formatted as YYYY-MM-DDTHH:MM."})
+	} else {
+		event.Date = date
+	}
+
+	if f.EndDate != nil && *f.EndDate == "" {
+		event.EndDate = nil
+	} else if f.EndDate != nil {
+		if end, err := parseEventTime(*f.EndDate, loc); err != nil {
+			errs = append(errs, FieldError{Field: "end_date", Message: "Bad End Date! Must be formatted as YYYY-MM-DDTHH:MM."})
+		} else {
+			event.EndDate = &end
+		}
+	}
+	if event.EndDate != nil && !event.EndDate.After(event.Date) {
+		errs = append(errs, FieldError{Field: "end_date", Message: "Bad End Date! Must be after the start of the event."})
+	}
+
+	if f.Recurrence != nil {
+		if recurrence, fieldError := f.Recurrence.parse(*event); fieldError != nil {
+			errs = append(errs, *fieldError)
+		} else {
+			event.Recurrence = recurrence
+		}
+	}
+
+	policy, domains := event.EmailPolicy, event.EmailDomains
+	if f.EmailPolicy != nil {
+		policy = *f.EmailPolicy
+	}
+	if f.EmailDomains != nil {
+		domains = parseEmailDomains(strings.Join(*f.EmailDomains, ","))
+	}
+	if policy != "" && !isValidEmailPolicy(policy) {
+		errs = append(errs, FieldError{Field: "email_policy", Message: "Bad Email Policy! Must be open, allowlist or blocklist."})
+	} else if policy == emailPolicyAllowlist && len(domains) == 0 {
+		errs = append(errs, FieldError{Field: "email_domains", Message: "Bad Email Domains! An allowlist needs at least one domain."})
+	} else {
+		validDomains := true
+		for _, domain := range domains {
+			if !isValidEmailDomain(domain) {
+				errs = append(errs, FieldError{Field: "email_domains", Message: "Bad Email Domain! " + domain + " is not a domain name."})
+				validDomains = false
+				break
+			}
+		}
+		if validDomains {
+			event.EmailPolicy = policy
+			event.EmailDomains = domains
+		}
+	}
+
+	if f.Capacity != nil {
+		if *f.Capacity < 0 {
+			errs = append(errs, FieldError{Field: "capacity", Message: "Bad Capacity! Must be a whole number, or blank for no limit."})
+		} else {
+			event.Capacity = *f.Capacity
+		}
+	}
+
+	if f.AttendeeVisibility != nil {
+		if *f.AttendeeVisibility != "" && !isValidAttendeeVisibility(*f.AttendeeVisibility) {
+			errs = append(errs, FieldError{Field: "attendee_visibility", Message: "Bad Attendee Visibility! Must be public, count or hidden."})
+		} else {
+			event.AttendeeVisibility = *f.AttendeeVisibility
+		}
+	}
+
+	return errs
+}
+
+// isAttending - reports whether `email` has already RSVP-ed to `event`.
+func isAttending(event Event, email string) bool {
+	for _, attendee := range event.Attending {
+		if strings.EqualFold(attendee.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// newAttendee - returns the attendee RSVP-ing as `email` with the display
+// name and affiliation they gave, trimmed. The message says what is wrong
+// with those, if anything.
+func newAttendee(email string, name string, affiliation string) (Attendee, string) {
+	attendee := Attendee{
+		Email:       email,
+		DisplayName: strings.TrimSpace(name),
+		Affiliation: strings.TrimSpace(affiliation),
+	}
+	switch {
+	case attendee.DisplayName == "":
+		return attendee, "Please enter your name."
+	case utf8.RuneCountInString(attendee.DisplayName) > maxAttendeeFieldLength:
+		return attendee, "Your name must be at most " + strconv.Itoa(maxAttendeeFieldLength) + " characters."
+	case utf8.RuneCountInString(attendee.Affiliation) > maxAttendeeFieldLength:
+		return attendee, "Your affiliation must be at most " + strconv.Itoa(maxAttendeeFieldLength) + " characters."
+	}
+	return attendee, ""
+}
+
+// EventForm - the data rendered by create.gohtml. The same form is used
+// both to create new events and to edit existing ones.
+type EventForm struct {
+	ErrorMessage       string
+	Heading            string
+	Action             string
+	SubmitLabel        string
+	Token              string
+	DeleteURL          string
+	AttendeesCSVURL    string
+	AttendeesJSONURL   string
+	Title              string
+	Location           string
+	Image              string
+	Date               string
+	EndDate            string
+	TimeZone           string
+	TimeZones          []string
+	Recurrence         recurrenceForm
+	EmailPolicy        string
+	EmailDomains       string
+	Capacity           string
+	AttendeeVisibility string
+}
+
+// recurrenceForm - the recurrence fields of EventForm.
+type recurrenceForm struct {
+	Freq       string
+	Interval   string
+	Until      string
+	Count      string
+	Exceptions string
+}
+
+// newEventForm - returns an empty form for /events/new, set to `defaults`.
+func newEventForm(defaults eventDefaults) EventForm {
+	return EventForm{
+		Heading:            "RSVP",
+		Action:             "/events/new",
+		SubmitLabel:        "Create Event",
+		TimeZone:           defaults.TimeZone,
+		TimeZones:          commonTimeZones,
+		EmailPolicy:        defaults.EmailPolicy,
+		EmailDomains:       strings.Join(defaults.EmailDomains, ", "),
+		AttendeeVisibility: attendeeVisibilityPublic,
+	}
+}
+
+// editEventForm - returns a form prefilled with the details of `event`
+// that submits to its edit page. `token` is the organizer token, if the
+// organizer is not logged in. An event without its own email policy shows
+// that of `defaults`.
+func editEventForm(event Event, token string, defaults eventDefaults) EventForm {
+	form := EventForm{
+		Heading:          "Edit Event",
+		Action:           "/events/" + event.PathID() + "/edit",
+		SubmitLabel:      "Save Changes",
+		Token:            token,
+		DeleteURL:        "/events/" + event.PathID() + "/delete",
+		AttendeesCSVURL:  "/events/" + event.PathID() + "/attendees.csv",
+		AttendeesJSONURL: "/events/" + event.PathID() + "/attendees.json",
+		Title:            event.Title,
+		Location:         event.Location,
+		Image:            event.Image,
+		Date:             event.Date.Format("2006-01-02T15:04"),
+		TimeZone:         event.TimeZone,
+		TimeZones:        commonTimeZones,
+	}
+	policy, domains := event.emailPolicy(defaults)
+	form.EmailPolicy = policy
+	form.EmailDomains = strings.Join(domains, ", ")
+	form.AttendeeVisibility = event.attendeeVisibility()
+	if event.Capacity > 0 {
+		form.Capacity = strconv.Itoa(event.Capacity)
+	}
+	if event.EndDate != nil {
+		form.EndDate = event.EndDate.Format("2006-01-02T15:04")
+	}
+	if r := event.Recurrence; r != nil {
+		form.Recurrence = recurrenceForm{
+			Freq:       r.Freq,
+			Interval:   strconv.Itoa(r.Interval),
+			Until:      r.Until,
+			Exceptions: strings.Join(r.Exceptions, ", "),
+		}
+		if r.Count > 0 {
+			form.Recurrence.Count = strconv.Itoa(r.Count)
+		}
+	}
+	if token != "" {
+		form.DeleteURL += "?token=" + url.QueryEscape(token)
+		form.AttendeesCSVURL += "?token=" + url.QueryEscape(token)
+		form.AttendeesJSONURL += "?token=" + url.QueryEscape(token)
+	}
+	return form
+}
+
+// read - copies the submitted values onto the form, so they are shown
+// again if validation fails, and returns them for validation.
+func (form *EventForm) read(r *http.Request) eventFields {
+	form.Title = r.FormValue("title")
+	form.Location = r.FormValue("location")
+	form.Image = r.FormValue("image")
+	form.Date = r.FormValue("date")
+	form.EndDate = r.FormValue("end_date")
+	form.TimeZone = r.FormValue("time_zone")
+	form.Recurrence = recurrenceForm{
+		Freq:       r.FormValue("recur_freq"),
+		Interval:   strings.TrimSpace(r.FormValue("recur_interval")),
+		Until:      r.FormValue("recur_until"),
+		Count:      strings.TrimSpace(r.FormValue("recur_count")),
+		Exceptions: r.FormValue("recur_exceptions"),
+	}
+	form.EmailPolicy = r.FormValue("email_policy")
+	form.EmailDomains = r.FormValue("email_domains")
+	domains := parseEmailDomains(form.EmailDomains)
+	form.AttendeeVisibility = r.FormValue("attendee_visibility")
+
+	// A blank capacity means no limit
+	form.Capacity = strings.TrimSpace(r.FormValue("capacity"))
+	capacity := atoiOrInvalid(form.Capacity)
+	recurrence := recurrenceFields{
+		Freq:       form.Recurrence.Freq,
+		Interval:   atoiOrInvalid(form.Recurrence.Interval),
+		Until:      form.Recurrence.Until,
+		Count:      atoiOrInvalid(form.Recurrence.Count),
+		Exceptions: strings.Split(form.Recurrence.Exceptions, ","),
+	}
+	return eventFields{
+		Title:              &form.Title,
+		Location:           &form.Location,
+		Image:              &form.Image,
+		Date:               &form.Date,
+		EndDate:            &form.EndDate,
+		TimeZone:           &form.TimeZone,
+		Recurrence:         &recurrence,
+		EmailPolicy:        &form.EmailPolicy,
+		EmailDomains:       &domains,
+		Capacity:           &capacity,
+		AttendeeVisibility: &form.AttendeeVisibility,
+	}
+}
+
+// atoiOrInvalid - parses a whole number typed into the form, where blank
+// means 0. Anything unparseable is passed on as -1 so that validation
+// rejects it.
+func atoiOrInvalid(s string) int {
+	if s == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// setErrors - joins the messages of `errs` into the form's error banner.
+func (form *EventForm) setErrors(errs []FieldError) {
+	messages := make([]string, len(errs))
+	for i, fieldError := range errs {
+		messages[i] = fieldError.Message
+	}
+	form.ErrorMessage = strings.Join(messages, " ")
+}
+
+func (s *server) indexController(w http.ResponseWriter, r *http.Request) {
 
 	type indexContextData struct {
 		Events []Event
 		Today  time.Time
 	}
 
-	theEvents, err := getAllEvents()
+	// Only events that have not finished yet, and series with occurrences
+	// still to come, are listed
+	theEvents, _, err := s.store.ListEvents(eventQuery{From: time.Now()})
 	if err != nil {
-		http.Error(w, "database error", http.StatusInternalServerError)
+		s.serverError(w, r, err)
 		return
 	}
 
 	contextData := indexContextData{
-		Events: theEvents,
+		Events: upcomingOccurrences(theEvents),
 		Today:  time.Now(),
 	}
 
-	tmpl["index"].Execute(w, contextData)
+	s.templates["index"].Execute(w, contextData)
 }
 
-func createEventController(w http.ResponseWriter, r *http.Request) {
+func (s *server) createEventController(w http.ResponseWriter, r *http.Request) {
+	form := newEventForm(s.defaults)
 	if r.Method == http.MethodPost {
 		// Parse form data from the POST request
 		if err := r.ParseForm(); err != nil {
-			http.Error(w, "Invalid form submission", http.StatusBadRequest)
+			s.renderError(w, http.StatusBadRequest, "Invalid form submission")
 			return
 		}
 
-		// Error message
+		// Create new event
+		newEvent := Event{TimeZone: s.defaults.TimeZone}
+		fields := form.read(r)
+		form.setErrors(fields.apply(&newEvent, false))
+
+		if form.ErrorMessage == "" {
+			// The organizer token is only ever shown on the page below;
+			// we keep just its hash.
+			token := newOrganizerToken()
+			newEvent.OrganizerTokenHash = hashOrganizerToken(token)
+			user, loggedIn, err := s.currentUser(r)
+			if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			if loggedIn {
+				newEvent.OwnerID = user.ID
+			}
 
-		data := EventError{
-			ErrorMessage: "",
-		}
+			// Add the event to the list of all events
+			id, err := s.store.CreateEvent(newEvent)
+			if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			newEvent, _, err = s.store.GetEvent(id)
+			if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
 
-		// Extract form values
-		title := r.FormValue("title")
-		if len(title) < 6 || len(title) > 49 {
-			data.ErrorMessage += "Bad Title!"
-		}
-		location := r.FormValue("location")
-		if len(location) < 6 || len(location) > 49 {
-			data.ErrorMessage += " Bad Location!"
-		}
-		image := r.FormValue("image")
-		if !isValidImageURL(image) {
-			data.ErrorMessage += " Bad URL!"
+			type createdContextData struct {
+				Event
+				OrganizerToken string
+			}
+			s.templates["created"].Execute(w, createdContextData{Event: newEvent, OrganizerToken: token})
+		} else {
+			s.templates["create"].Execute(w, form)
 		}
-		dateStr := r.FormValue("date")
 
-		// Parse the date
-		// date, err := time.Parse("2006-01-02", dateStr)
-		// if err != nil {
-		// 	http.Error(w, "Invalid date format. Please use YYYY-MM-DD.", http.StatusBadRequest)
-		// 	return
-		// }
-		//date, err := time.Parse("2006-01-02T15:04", dateStr)
-		err, date := isFutureDate(dateStr)
-		if !err {
-			// http.Error(w, "Invalid date-time format. Please use YYYY-MM-DDTHH:MM.", http.StatusBadRequest)
-			// return
-			data.ErrorMessage += " Bad Date!"
-			//data.ErrorMessage += dateStr
-		}
+	} else {
+		// Render the form if the request is a GET request
+		s.templates["create"].Execute(w, form)
+	}
+}
 
-		// Create new event
-		newEvent := Event{
-			Title:    title,
-			Location: location,
-			Image:    image,
-			Date:     date,
+// editEventController - lets the organizer of an event change its details
+// using the same form and validation as createEventController. Requires
+// the organizer token that was shown when the event was created.
+func (s *server) editEventController(w http.ResponseWriter, r *http.Request) {
+	id, err := s.eventIDParam(r)
+	if err == errEventNotFound {
+		s.renderError(w, http.StatusNotFound, "Event not found")
+		return
+	} else if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+
+	event, exists, err := s.store.GetEvent(id)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	if !exists {
+		s.renderError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	if !s.canManageEvent(r, event) {
+		s.renderError(w, http.StatusForbidden, "Invalid organizer link")
+		return
+	}
+	token := organizerToken(r)
+
+	form := editEventForm(event, token, s.defaults)
+	if r.Method == http.MethodPost {
+		fields := form.read(r)
+		form.setErrors(fields.apply(&event, false))
+		if form.ErrorMessage == "" {
+			if err := s.store.UpdateEvent(event); err == errEventNotFound {
+				s.renderError(w, http.StatusNotFound, "Event not found")
+				return
+			} else if err != nil {
+				s.serverError(w, r, err)
+				return
+			}
+			http.Redirect(w, r, "/events/"+event.PathID(), http.StatusSeeOther)
+			return
 		}
-		if data.ErrorMessage == "" {
-			// Add the event to the list of all events
-			addEvent(newEvent)
+	}
 
-			// Redirect or render success page
-			http.Redirect(w, r, "/events/"+strconv.Itoa(getMaxEventID()), http.StatusSeeOther)
-		} else {
-			tmpl["create"].Execute(w, data)
+	s.templates["create"].Execute(w, form)
+}
+
+// deleteEventController - asks the organizer of an event to confirm and,
+// on POST, deletes the event. Requires the organizer token.
+func (s *server) deleteEventController(w http.ResponseWriter, r *http.Request) {
+	id, err := s.eventIDParam(r)
+	if err == errEventNotFound {
+		s.renderError(w, http.StatusNotFound, "Event not found")
+		return
+	} else if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+
+	event, exists, err := s.store.GetEvent(id)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	if !exists {
+		s.renderError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	if !s.canManageEvent(r, event) {
+		s.renderError(w, http.StatusForbidden, "Invalid organizer link")
+		return
+	}
+	token := organizerToken(r)
+
+	if r.Method == http.MethodPost {
+		if err := s.store.DeleteEvent(event.ID); err != nil && err != errEventNotFound {
+			s.serverError(w, r, err)
+			return
 		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 
-	} else {
-		// Render the form if the request is a GET request
-		tmpl["create"].Execute(w, nil)
+	type deleteContextData struct {
+		Event
+		OrganizerToken string
 	}
+	s.templates["delete"].Execute(w, deleteContextData{Event: event, OrganizerToken: token})
 }
 
-func accessEventController(w http.ResponseWriter, r *http.Request) {
+func (s *server) accessEventController(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		//temp := r.URL. Path
 		if err := r.ParseForm(); err != nil {
-			http.Error(w, "Invalid form submission", http.StatusBadRequest)
+			s.renderError(w, http.StatusBadRequest, "Invalid form submission")
 			return
 		}
 
-		idStr := r.FormValue("eventID")
-		id, err := strconv.Atoi(idStr)
-		if err != nil {
-			http.Error(w, "Invalid event ID", http.StatusBadRequest)
+		id, err := s.eventIDParam(r)
+		if err == errEventNotFound {
+			s.renderError(w, http.StatusNotFound, "Event not found")
+			return
+		} else if err != nil {
+			s.serverError(w, r, err)
 			return
 		}
 
-		email := r.FormValue("email")
-		_, err = mail.ParseAddress(email)
+		addr, err := mail.ParseAddress(r.FormValue("email"))
 		if err != nil {
-			http.Error(w, "Invalid email format. Please enter a valid email address.", http.StatusBadRequest)
+			s.renderError(w, http.StatusBadRequest, "Invalid email format. Please enter a valid email address.")
 			return
 		}
+		email := addr.Address
 
-		contextEvent, exists := getEventByID(id)
+		contextEvent, exists, err := s.store.GetEvent(id)
+		if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
 		if !exists {
-			http.Error(w, "Event not found", http.StatusNotFound)
+			s.renderError(w, http.StatusNotFound, "Event not found")
+			return
+		}
+		occurrence := r.FormValue("occurrence")
+		if !showOccurrence(&contextEvent, occurrence) {
+			s.renderError(w, http.StatusNotFound, "Occurrence not found")
 			return
 		}
 
 		contextEvent.RSVPMessage = ""
 		contextEvent.RSVPClass = ""
-		if !strings.HasSuffix(email, "@yale.edu") {
-			contextEvent.RSVPMessage = "Bad email. Yalies only" //`<div class="error">Bad email. Yalies only</div>`
+		if allowed, message := contextEvent.checkEmailPolicy(addr, s.defaults); !allowed {
+			contextEvent.RSVPMessage = message //`<div class="error">Bad email. Yalies only</div>`
 			contextEvent.RSVPClass = "error"
 			//tmpl["access"].Execute(w, contextEvent)
 		}
 
-		if contextEvent.RSVPMessage == "" {
-			for _, event := range contextEvent.Attending {
-				if event == email {
-					//http.Error(w, "Email is already RSVP-ed", http.StatusBadRequest)
-					contextEvent.RSVPMessage = "Email is already RSVP-ed"
-					break
-					//tmpl["access"].Execute(w, contextEvent)
-				}
-			}
+		attendee, problem := newAttendee(email, r.FormValue("name"), r.FormValue("affiliation"))
+		if contextEvent.RSVPMessage == "" && problem != "" {
+			contextEvent.RSVPMessage = problem
+			contextEvent.RSVPClass = "error"
+		}
+
+		if contextEvent.RSVPMessage == "" && isAttending(contextEvent, email) {
+			contextEvent.RSVPMessage = "Email is already RSVP-ed"
+		}
+		if contextEvent.RSVPMessage == "" && contextEvent.isPending(email) {
+			contextEvent.RSVPMessage = "Email is already RSVP-ed. Check your inbox for the link to confirm it."
+		}
+		if position := contextEvent.waitlistPosition(email); contextEvent.RSVPMessage == "" && position > 0 {
+			contextEvent.RSVPMessage = "Email is already #" + strconv.Itoa(position) + " on the waitlist"
 		}
 
 		//addAttendee(id, email)
 		if contextEvent.RSVPMessage == "" {
-			err = addAttendee(contextEvent.ID, email)
+			rsvp, err := s.store.AddRSVP(contextEvent.ID, attendee, occurrence, s.confirmationCode(contextEvent.PublicID, email))
 			if err != nil {
-				http.Error(w, "Event not found", http.StatusNotFound)
+				s.serverError(w, r, err)
 				return
 			}
 
-			// Compute the SHA-1 hash
-			hasher := sha256.New()
-			hasher.Write([]byte(email))
-			hash := hasher.Sum(nil)
-
-			// Convert the hash to a hexadecimal string
-			hashHex := hex.EncodeToString(hash)
-
-			// Print the first 7 characters of the hash
-			contextEvent.SHA256Hash = hashHex[:7]
-
-			contextEvent.RSVPMessage = "Thank You for your RSVP!"
-
-			contextEvent.Attending = append(contextEvent.Attending, email)
+			// The RSVP only counts once the attendee follows the emailed link.
+			// Without a token it was made by another request since the event
+			// was loaded, and is left to that request.
+			if rsvp.VerifyToken == "" {
+				contextEvent.RSVPMessage = "Email is already RSVP-ed"
+			} else if err := s.sendRSVPVerification(contextEvent, email, rsvp); err != nil {
+				if _, cancelErr := s.store.CancelRSVP(contextEvent.ID, email, occurrence); cancelErr != nil {
+					s.serverError(w, r, fmt.Errorf("%v; cancelling the RSVP: %w", err, cancelErr))
+					return
+				}
+				s.renderError(w, http.StatusInternalServerError, "Could not send the confirmation email. Please try again later.")
+				return
+			} else if rsvp.WaitlistPosition > 0 {
+				contextEvent.RSVPMessage = "This event is full. You are #" + strconv.Itoa(rsvp.WaitlistPosition) + " on the waitlist. We have emailed you a link to confirm your place."
+				contextEvent.Waitlist = append(contextEvent.Waitlist, email)
+			} else {
+				contextEvent.RSVPMessage = "Almost there! We have emailed you a link to confirm your RSVP."
+				contextEvent.Pending = append(contextEvent.Pending, email)
+			}
 		}
 
-		tmpl["access"].Execute(w, contextEvent)
+		s.templates["access"].Execute(w, contextEvent)
 
 		//http.Redirect(w, r, r.URL.Path, http.StatusFound)
 		// data := map[string]interface{}{
@@ -220,23 +686,171 @@ func accessEventController(w http.ResponseWriter, r *http.Request) {
 		// tmpl["access"].Execute(w, data)
 		// fmt. Fprint(w, '«script>location.href = "http://localhost:8080/events/{id}";</script>*)
 	} else {
-		idStr := strings.TrimPrefix(r.URL.Path, "/events/")
-		id, err := strconv.Atoi(idStr)
-		if err != nil {
-			http.Error(w, "Invalid event ID", http.StatusBadRequest)
+		id, err := s.eventIDParam(r)
+		if err == errEventNotFound {
+			s.renderError(w, http.StatusNotFound, "Event not found")
+			return
+		} else if err != nil {
+			s.serverError(w, r, err)
 			return
 		}
 
-		contextEvent, exists := getEventByID(id)
+		contextEvent, exists, err := s.store.GetEvent(id)
+		if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
 		if !exists {
-			http.Error(w, "Event not found", http.StatusNotFound)
+			s.renderError(w, http.StatusNotFound, "Event not found")
 			return
 		}
+		if !showOccurrence(&contextEvent, r.URL.Query().Get("occurrence")) {
+			s.renderError(w, http.StatusNotFound, "Occurrence not found")
+			return
+		}
+		contextEvent.CanEdit = s.canManageEvent(r, contextEvent)
+		if contextEvent.CanEdit && isOrganizer(contextEvent, organizerToken(r)) {
+			contextEvent.OrganizerToken = organizerToken(r)
+		}
 
-		tmpl["access"].Execute(w, contextEvent)
+		s.templates["access"].Execute(w, contextEvent)
 	}
 }
 
+// upcomingOccurrenceLimit is how many upcoming occurrences of a recurring
+// event its page lists.
+const upcomingOccurrenceLimit = 10
+
+// showOccurrence - lists the upcoming occurrences of a recurring event for
+// its page and, if `key` is set, narrows the event down to that
+// occurrence. Returns false if the event has no such occurrence.
+func showOccurrence(event *Event, key string) bool {
+	now := time.Now()
+	event.listOccurrences(now, now.Add(occurrenceWindow), upcomingOccurrenceLimit)
+	if key == "" {
+		return true
+	}
+	return event.Recurrence != nil && event.selectOccurrence(key)
+}
+
+// verifyRSVPController - handles GET /events/{id}/verify, the link emailed
+// to attendees. It confirms their RSVP and shows their confirmation code.
+func (s *server) verifyRSVPController(w http.ResponseWriter, r *http.Request) {
+	id, err := s.eventIDParam(r)
+	if err == errEventNotFound {
+		s.renderError(w, http.StatusNotFound, "Event not found")
+		return
+	} else if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+
+	if _, exists, err := s.store.GetEvent(id); err != nil {
+		s.serverError(w, r, err)
+		return
+	} else if !exists {
+		s.renderError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	rsvp, found, err := s.store.VerifyRSVP(id, r.URL.Query().Get("token"))
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+
+	// Reload so the attendee list includes the confirmed RSVP
+	contextEvent, _, err := s.store.GetEvent(id)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	showOccurrence(&contextEvent, rsvp.Occurrence)
+	if !found {
+		contextEvent.RSVPMessage = "This confirmation link is invalid or has expired. Please RSVP again."
+		contextEvent.RSVPClass = "error"
+	} else if rsvp.WaitlistPosition > 0 {
+		contextEvent.ConfirmationCode = rsvp.ConfirmationCode
+		contextEvent.RSVPMessage = "Your email is confirmed. You are #" + strconv.Itoa(rsvp.WaitlistPosition) + " on the waitlist."
+	} else {
+		contextEvent.ConfirmationCode = rsvp.ConfirmationCode
+		contextEvent.RSVPMessage = "Thank You for your RSVP!"
+	}
+
+	s.templates["access"].Execute(w, contextEvent)
+}
+
+// cancelRSVPController - shows the form where an attendee can cancel their
+// RSVP and, on POST, cancels it if the email and confirmation code match.
+// The outcome is shown in the RSVP banner of the event page.
+func (s *server) cancelRSVPController(w http.ResponseWriter, r *http.Request) {
+	id, err := s.eventIDParam(r)
+	if err == errEventNotFound {
+		s.renderError(w, http.StatusNotFound, "Event not found")
+		return
+	} else if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+
+	contextEvent, exists, err := s.store.GetEvent(id)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	if !exists {
+		s.renderError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		showOccurrence(&contextEvent, "")
+		s.templates["cancel"].Execute(w, contextEvent)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, http.StatusBadRequest, "Invalid form submission")
+		return
+	}
+	email := r.FormValue("email")
+	code := r.FormValue("code")
+	occurrence := r.FormValue("occurrence")
+
+	valid, err := s.verifyConfirmationCode(contextEvent.ID, email, code)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	if !valid {
+		contextEvent.RSVPMessage = "That email and confirmation code do not match any RSVP."
+		contextEvent.RSVPClass = "error"
+		s.templates["access"].Execute(w, contextEvent)
+		return
+	}
+
+	removed, err := s.store.CancelRSVP(contextEvent.ID, email, occurrence)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+
+	// Reload so the attendee list no longer shows the cancelled RSVP
+	contextEvent, _, err = s.store.GetEvent(id)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	showOccurrence(&contextEvent, occurrence)
+	if removed {
+		contextEvent.RSVPMessage = "Your RSVP has been cancelled."
+	} else {
+		contextEvent.RSVPMessage = "You have no RSVP for that date."
+		contextEvent.RSVPClass = "error"
+	}
+	s.templates["access"].Execute(w, contextEvent)
+}
+
 // func rsvpController(w http.ResponseWriter, r *http.Request) {
 // 	idStr := strings.TrimPrefix(r.URL.Path, "/events/")
 // 	if strings.HasSuffix(idStr, "/rsvp") {
@@ -244,28 +858,28 @@ func accessEventController(w http.ResponseWriter, r *http.Request) {
 // 	}
 // 	id, err := strconv.Atoi(idStr)
 // 	if err != nil {
-// 		http.Error(w, "Invalid event ID", http.StatusBadRequest)
+// 		renderError(w, http.StatusBadRequest, "Invalid event ID")
 // 		return
 // 	}
 
 // 	// Get the email from the form data
 // 	email := r.FormValue("email")
 // 	if email == "" {
-// 		http.Error(w, "Email is required", http.StatusBadRequest)
+// 		renderError(w, http.StatusBadRequest, "Email is required")
 // 		return
 // 	}
 
 // 	// Add the attendee to the event
 // 	err = addAttendee(id, email)
 // 	if err != nil {
-// 		http.Error(w, "Event not found", http.StatusNotFound)
+// 		renderError(w, http.StatusNotFound, "Event not found")
 // 		return
 // 	}
 
 // 	// Retrieve the updated event data to show the latest attendee list
 // 	contextEvent, exists := getEventByID(id)
 // 	if !exists {
-// 		http.Error(w, "Event not found", http.StatusNotFound)
+// 		renderError(w, http.StatusNotFound, "Event not found")
 // 		return
 // 	}
 
@@ -273,52 +887,101 @@ func accessEventController(w http.ResponseWriter, r *http.Request) {
 // 	tmpl["access"].Execute(w, contextEvent)
 // }
 
-func aboutController(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		tmpl["about"].Execute(w, nil)
+// eventICSController - handles GET /events/{id}.ics, the event as an
+// iCalendar file that can be added to a calendar.
+func (s *server) eventICSController(w http.ResponseWriter, r *http.Request) {
+	id, err := s.eventIDParam(r)
+	if err == errEventNotFound {
+		s.renderError(w, http.StatusNotFound, "Event not found")
+		return
+	} else if err != nil {
+		s.serverError(w, r, err)
+		return
 	}
-}
 
-func donateController(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		tmpl["donate"].Execute(w, nil)
+	event, found, err := s.store.GetEvent(id)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	if !found {
+		s.renderError(w, http.StatusNotFound, "Event not found")
+		return
 	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+event.Slug+`.ics"`)
+	s.writeICalendar(w, event.Title, []Event{event})
 }
 
-func apiController(w http.ResponseWriter, r *http.Request) {
-	// Capture the event ID from the URL path
-	idStr := strings.TrimPrefix(r.URL.Path, "/api/events")
-	if idStr != "" {
-		// Convert the event ID to an integer
-		eventID, err := strconv.Atoi(strings.TrimPrefix(idStr, "/"))
-		if err != nil {
-			http.Error(w, "Invalid event ID", http.StatusBadRequest)
-			return
-		}
+// calendarController - handles GET /calendar.ics, a feed of every upcoming
+// event that calendar apps can subscribe to.
+func (s *server) calendarController(w http.ResponseWriter, r *http.Request) {
+	// Keep events that have not finished yet, and series with occurrences
+	// still to come
+	now := time.Now()
+	events, _, err := s.store.ListEvents(eventQuery{From: now})
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
 
-		// Fetch the specific event
-		event, found := getEventByID(eventID)
-		if !found {
-			http.Error(w, "Event not found", http.StatusNotFound)
-			return
+	// The store goes by when a series ends, which its exceptions may leave
+	// without any occurrence after all
+	var upcoming []Event
+	for _, event := range events {
+		if len(event.occurrenceStarts(now, now.AddDate(100, 0, 0), 1)) > 0 {
+			upcoming = append(upcoming, event)
 		}
+	}
+	sort.Slice(upcoming, func(i, j int) bool { return upcoming[i].Date.Before(upcoming[j].Date) })
 
-		// Respond with JSON for the specific event
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(event)
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	s.writeICalendar(w, "Upcoming events", upcoming)
+}
+
+func (s *server) aboutController(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.templates["about"].Execute(w, nil)
+	}
+}
+
+func (s *server) donateController(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.templates["donate"].Execute(w, nil)
+	}
+}
+
+// apiController - handles GET /api/events/{id}. The list of events is
+// served by apiListEventsController. Only the event's organizer gets the
+// attendees' emails, and the attendees at all if the event's attendee
+// visibility withholds them.
+func (s *server) apiController(w http.ResponseWriter, r *http.Request) {
+	eventID, err := s.eventIDParam(r)
+	if err == errEventNotFound {
+		writeJSONError(w, http.StatusNotFound, "Event not found")
+		return
+	} else if err != nil {
+		apiServerError(w, r, "Error retrieving event", err)
 		return
 	}
 
-	// If no event ID is provided, return all events
-	events, err := getAllEvents()
+	// Fetch the specific event
+	event, found, err := s.store.GetEvent(eventID)
 	if err != nil {
-		http.Error(w, "Error retrieving events: "+err.Error(), http.StatusInternalServerError)
+		apiServerError(w, r, "Error retrieving event", err)
 		return
 	}
+	if !found {
+		writeJSONError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	now := time.Now()
+	event.listOccurrences(now, now.Add(occurrenceWindow), 0)
+	if !s.canManageEvent(r, event) {
+		event.restrictAttendees()
+	}
 
-	// Respond with JSON for all events
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"events": events,
-	})
+	// Respond with JSON for the specific event
+	writeJSON(w, http.StatusOK, event)
 }
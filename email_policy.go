@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// Email policies decide which addresses may RSVP to an event, based on
+// the domain of the address and the event's EmailDomains.
+const (
+	emailPolicyOpen      = "open"      // anyone may RSVP
+	emailPolicyAllowlist = "allowlist" // only the listed domains may RSVP
+	emailPolicyBlocklist = "blocklist" // everyone but the listed domains may RSVP
+)
+
+// isValidEmailPolicy - reports whether `policy` is one of the known
+// email policies.
+func isValidEmailPolicy(policy string) bool {
+	switch policy {
+	case emailPolicyOpen, emailPolicyAllowlist, emailPolicyBlocklist:
+		return true
+	}
+	return false
+}
+
+// parseEmailDomains - splits a comma- or space-separated list of domains,
+// normalizing each to lower case without a leading "@".
+func parseEmailDomains(list string) []string {
+	var domains []string
+	for _, domain := range strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t'
+	}) {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "@"))
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// isValidEmailDomain - a loose sanity check that `domain` looks like a
+// domain name.
+func isValidEmailDomain(domain string) bool {
+	return strings.Contains(domain, ".") && !strings.ContainsAny(domain, "@/:") &&
+		!strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".")
+}
+
+// emailDomainMatches - reports whether `domain` is `pattern` or one of its
+// subdomains, ignoring case. So "cs.yale.edu" matches "yale.edu" but
+// "notyale.edu" does not.
+func emailDomainMatches(domain string, pattern string) bool {
+	domain = strings.ToLower(domain)
+	pattern = strings.ToLower(pattern)
+	return domain == pattern || strings.HasSuffix(domain, "."+pattern)
+}
+
+// emailPolicy - returns the policy and domains in effect for `event`,
+// which are those of `defaults` unless it sets its own.
+func (event Event) emailPolicy(defaults eventDefaults) (string, []string) {
+	if event.EmailPolicy == "" {
+		return defaults.EmailPolicy, defaults.EmailDomains
+	}
+	return event.EmailPolicy, event.EmailDomains
+}
+
+// checkEmailPolicy - reports whether `addr` may RSVP to `event` and, if
+// not, a message explaining why.
+func (event Event) checkEmailPolicy(addr *mail.Address, defaults eventDefaults) (bool, string) {
+	policy, domains := event.emailPolicy(defaults)
+	if policy == emailPolicyOpen {
+		return true, ""
+	}
+
+	domain := addr.Address[strings.LastIndex(addr.Address, "@")+1:]
+	listed := false
+	for _, pattern := range domains {
+		if emailDomainMatches(domain, pattern) {
+			listed = true
+			break
+		}
+	}
+
+	switch {
+	case policy == emailPolicyBlocklist && listed:
+		return false, "Sorry, emails from " + domain + " cannot RSVP to this event."
+	case policy == emailPolicyAllowlist && !listed:
+		return false, "Sorry, only emails from " + strings.Join(domains, ", ") + " can RSVP to this event."
+	}
+	return true, ""
+}
+
+// whoCanRSVP - describes the email policy of the event for its page.
+func (event Event) whoCanRSVP(defaults eventDefaults) string {
+	policy, domains := event.emailPolicy(defaults)
+	switch {
+	case policy == emailPolicyAllowlist:
+		return "Only emails from " + strings.Join(domains, ", ")
+	case policy == emailPolicyBlocklist && len(domains) > 0:
+		return "Anyone except emails from " + strings.Join(domains, ", ")
+	}
+	return "Anyone"
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/mail"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEmailDomains(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{list: "", want: nil},
+		{list: "yale.edu", want: []string{"yale.edu"}},
+		{list: "@Yale.edu, harvard.edu", want: []string{"yale.edu", "harvard.edu"}},
+		{list: "yale.edu\r\nmit.edu\t ,, ", want: []string{"yale.edu", "mit.edu"}},
+	}
+	for _, test := range tests {
+		if got := parseEmailDomains(test.list); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseEmailDomains(%q) = %q, want %q", test.list, got, test.want)
+		}
+	}
+}
+
+func TestEmailDomainMatches(t *testing.T) {
+	tests := []struct {
+		domain  string
+		pattern string
+		want    bool
+	}{
+		{domain: "yale.edu", pattern: "yale.edu", want: true},
+		{domain: "YALE.edu", pattern: "yale.EDU", want: true},
+		{domain: "cs.yale.edu", pattern: "yale.edu", want: true},
+		{domain: "notyale.edu", pattern: "yale.edu"},
+		{domain: "yale.edu.evil.com", pattern: "yale.edu"},
+		{domain: "edu", pattern: "yale.edu"},
+	}
+	for _, test := range tests {
+		if got := emailDomainMatches(test.domain, test.pattern); got != test.want {
+			t.Errorf("emailDomainMatches(%q, %q) = %v, want %v", test.domain, test.pattern, got, test.want)
+		}
+	}
+}
+
+func TestCheckEmailPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		domains []string
+		email   string
+		want    bool
+	}{
+		{name: "default allows Yale", email: "a@yale.edu", want: true},
+		{name: "default blocks others", email: "a@gmail.com"},
+		{name: "open", policy: emailPolicyOpen, email: "a@gmail.com", want: true},
+		{name: "allowlisted", policy: emailPolicyAllowlist, domains: []string{"mit.edu", "harvard.edu"}, email: "a@Harvard.edu", want: true},
+		{name: "allowlisted subdomain", policy: emailPolicyAllowlist, domains: []string{"mit.edu"}, email: "a@csail.mit.edu", want: true},
+		{name: "not allowlisted", policy: emailPolicyAllowlist, domains: []string{"mit.edu"}, email: "a@yale.edu"},
+		{name: "empty allowlist", policy: emailPolicyAllowlist, email: "a@yale.edu"},
+		{name: "blocklisted", policy: emailPolicyBlocklist, domains: []string{"gmail.com"}, email: "a@gmail.com"},
+		{name: "not blocklisted", policy: emailPolicyBlocklist, domains: []string{"gmail.com"}, email: "a@yale.edu", want: true},
+		{name: "domain in the local part", policy: emailPolicyAllowlist, domains: []string{"yale.edu"}, email: `"a@yale.edu"@gmail.com`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr, err := mail.ParseAddress(test.email)
+			if err != nil {
+				t.Fatalf("ParseAddress(%q): %v", test.email, err)
+			}
+			event := Event{EmailPolicy: test.policy, EmailDomains: test.domains}
+			allowed, message := event.checkEmailPolicy(addr, testDefaults)
+			if allowed != test.want {
+				t.Errorf("checkEmailPolicy(%q) = %v, want %v", test.email, allowed, test.want)
+			}
+			if !allowed && message == "" {
+				t.Error("no message says why the email was refused")
+			}
+		})
+	}
+}
+
+func TestWhoCanRSVP(t *testing.T) {
+	open := eventDefaults{EmailPolicy: emailPolicyOpen}
+	tests := []struct {
+		name     string
+		event    Event
+		defaults eventDefaults
+		want     string
+	}{
+		{name: "default", defaults: testDefaults, want: "Only emails from yale.edu"},
+		{name: "other default", defaults: open, want: "Anyone"},
+		{name: "own policy", event: Event{EmailPolicy: emailPolicyBlocklist, EmailDomains: []string{"gmail.com"}}, defaults: testDefaults, want: "Anyone except emails from gmail.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.event.whoCanRSVP(test.defaults); got != test.want {
+				t.Errorf("whoCanRSVP = %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	// The event page describes the server's default policy
+	id := mustAddEvent(t, Event{Title: "Policy party", Location: "Evans Hall", Date: time.Now().AddDate(0, 1, 0)})
+	w := serve(t, http.MethodGet, eventPath(t, id), "")
+	expectStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), "Only emails from yale.edu") {
+		t.Error("the event page does not say who can RSVP")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// errEventNotFound is returned when an event that is being changed does
+// not exist.
+var errEventNotFound = errors.New("event not found")
+
+// renderError - responds with `status` and the error page showing
+// `message`.
+func (s *server) renderError(w http.ResponseWriter, status int, message string) {
+	type errorContextData struct {
+		Status     int
+		StatusText string
+		Message    string
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	s.templates["error"].Execute(w, errorContextData{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Message:    message,
+	})
+}
+
+// serverError - logs `err`, which the visitor should not see, and responds
+// with a 500 error page.
+func (s *server) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+	s.renderError(w, http.StatusInternalServerError, "Something went wrong on our end. Please try again in a moment.")
+}
+
+// apiServerError - logs `err`, which API clients should not see, and
+// responds with a 500 JSON error saying only `message`, e.g. "Error
+// retrieving event".
+func apiServerError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+	writeJSONError(w, http.StatusInternalServerError, message)
+}
+
+// recoverer - middleware that turns a panic in a handler into a logged
+// stack trace and a 500 response, instead of a dropped connection. API
+// requests get a JSON error, everything else the error page.
+func (s *server) recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				// Deliberately aborted; let net/http deal with it
+				panic(rec)
+			}
+			log.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			s.renderError(w, http.StatusInternalServerError, "Something went wrong on our end. Please try again in a moment.")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
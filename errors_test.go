@@ -0,0 +1,96 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRenderError(t *testing.T) {
+	w := httptest.NewRecorder()
+	testServer.renderError(w, http.StatusNotFound, "Event not found")
+	expectStatus(t, w, http.StatusNotFound)
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+		t.Errorf("Content-Type = %q, want text/html", got)
+	}
+	for _, want := range []string{"Event not found", "Not Found"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("error page does not say %q: %s", want, w.Body.String())
+		}
+	}
+}
+
+func TestRecoverer(t *testing.T) {
+	handler := testServer.recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/abort" {
+			panic(http.ErrAbortHandler)
+		}
+		panic("secret details")
+	}))
+	tests := []struct {
+		path            string
+		wantContentType string
+	}{
+		{path: "/events/1", wantContentType: "text/html"},
+		{path: "/api/events/1", wantContentType: "application/json"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+		expectStatus(t, w, http.StatusInternalServerError)
+		if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, test.wantContentType) {
+			t.Errorf("%s: Content-Type = %q, want %s", test.path, got, test.wantContentType)
+		}
+		if strings.Contains(w.Body.String(), "secret details") {
+			t.Errorf("%s: the panic is shown to the client: %s", test.path, w.Body.String())
+		}
+	}
+
+	defer func() {
+		if rec := recover(); rec != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler to be passed on", rec)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abort", nil))
+}
+
+func TestDatabaseErrors(t *testing.T) {
+	publicID := createAPIEvent(t).PublicID
+	broken, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	broken.Close()
+	saved := testServer.store
+	testServer.store = &sqliteStore{db: broken}
+	defer func() { testServer.store = saved }()
+
+	tests := []struct {
+		path string
+		json bool
+	}{
+		{path: "/"},
+		{path: "/events/" + publicID},
+		{path: "/calendar.ics"},
+		{path: "/api/events", json: true},
+		{path: "/api/events/" + publicID, json: true},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			w := serve(t, http.MethodGet, test.path, "")
+			expectStatus(t, w, http.StatusInternalServerError)
+			if test.json {
+				var apiErr apiError
+				if err := json.Unmarshal(w.Body.Bytes(), &apiErr); err != nil {
+					t.Errorf("the error is not JSON: %v", err)
+				}
+			}
+			if strings.Contains(w.Body.String(), "sql:") {
+				t.Errorf("the database error is shown to the client: %s", w.Body.String())
+			}
+		})
+	}
+}
@@ -2,65 +2,309 @@ package main
 
 import (
 	"database/sql"
-	"errors"
+	"net/url"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
 )
 
-var db *sql.DB // Declare the global `db` variable
+// sqliteStore - the Store backed by the SQLite database opened by initDB.
+type sqliteStore struct {
+	db *sql.DB
+}
 
 // Event - encapsulates information about an event
 type Event struct {
-	ID          int       `json:"id"`
-	Title       string    `json:"title"`
-	Location    string    `json:"location"`
-	Image       string    `json:"image"`
-	Date        time.Time `json:"date"`
-	Attending   []string  `json:"attending"`
-	RSVPMessage string    `json:"-"`
-	RSVPClass   string    `json:"-"`
-	SHA256Hash  string    `json:"-"`
+	// ID is what the event is stored under. It is sequential, so URLs and
+	// the API use the random PublicID instead. LegacyID is the number the
+	// event had in URLs before public IDs, or 0 for newer events.
+	ID               int        `json:"-"`
+	PublicID         string     `json:"id"`
+	LegacyID         int        `json:"-"`
+	Slug             string     `json:"slug"`
+	Title            string     `json:"title"`
+	Location         string     `json:"location"`
+	Image            string     `json:"image"`
+	Date             time.Time  `json:"date"`
+	EndDate          *time.Time `json:"end_date,omitempty"`
+	TimeZone         string     `json:"time_zone"`
+	Attending        []Attendee `json:"attending"`
+	RSVPMessage      string     `json:"-"`
+	RSVPClass        string     `json:"-"`
+	ConfirmationCode string     `json:"-"`
+
+	// OrganizerTokenHash is the SHA-256 hash of the secret token that lets
+	// whoever created the event edit or delete it.
+	OrganizerTokenHash string `json:"-"`
+
+	// OwnerID is the ID of the user who created the event, or 0 if it was
+	// created without logging in.
+	OwnerID int  `json:"-"`
+	CanEdit bool `json:"-"`
+	// OrganizerToken is the organizer token the event's page was opened
+	// with, carried on to its organizer links. Empty for a logged-in owner.
+	OrganizerToken string `json:"-"`
+
+	// EmailPolicy is one of the emailPolicy* constants and decides, along
+	// with EmailDomains, who may RSVP. Empty means the site default.
+	EmailPolicy  string   `json:"email_policy"`
+	EmailDomains []string `json:"email_domains"`
+
+	// Capacity is the most attendees the event takes, or 0 for no limit.
+	// Once it is reached, further RSVPs join Waitlist in order.
+	Capacity int      `json:"capacity"`
+	Waitlist []string `json:"-"`
+
+	// AttendeeVisibility is one of the attendeeVisibility* constants and
+	// decides what people other than the organizer see of Attending.
+	// Empty means public. AttendeeCount is only filled in by
+	// restrictAttendees, when the names are withheld but the count is not.
+	AttendeeVisibility string `json:"attendee_visibility"`
+	AttendeeCount      *int   `json:"attendee_count,omitempty"`
+
+	// Pending lists RSVPs whose email address has not been verified yet.
+	// They are not shown as attending and do not take a spot until they
+	// are verified.
+	Pending []string `json:"-"`
+
+	// Sequence counts the edits made to the event and UpdatedAt is when the
+	// last one happened. Calendar clients use them to tell which copy of an
+	// event is newest.
+	Sequence  int       `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+
+	// Recurrence is the schedule of a recurring event, or nil if it
+	// happens once. People can RSVP to the whole series, which is what
+	// Attending, Pending and Waitlist hold, or to one occurrence, kept in
+	// OccurrenceRSVPs by occurrence key. Occurrences is only filled in by
+	// listOccurrences.
+	Recurrence      *Recurrence                 `json:"recurrence,omitempty"`
+	Occurrences     []Occurrence                `json:"occurrences,omitempty"`
+	OccurrenceRSVPs map[string]*occurrenceRSVPs `json:"-"`
+
+	// Occurrence is the key of the occurrence picked by selectOccurrence,
+	// or empty for the whole event.
+	Occurrence string `json:"-"`
 }
-type EventError struct {
-	ErrorMessage string `json:"-"`
+
+// Attendee - someone who RSVP-ed to an event. Their DisplayName and
+// Affiliation may be shown to anyone; their Email only to the organizer.
+// Those who RSVP-ed before names were asked for have no DisplayName.
+type Attendee struct {
+	Email       string `json:"email,omitempty"`
+	DisplayName string `json:"name,omitempty"`
+	Affiliation string `json:"affiliation,omitempty"`
+}
+
+// maxAttendeeFieldLength is the longest display name or affiliation an
+// attendee can give.
+const maxAttendeeFieldLength = 100
+
+// hideAttendeeEmails - blanks the emails of the event's attendees, for
+// everyone but its organizer.
+func (event *Event) hideAttendeeEmails() {
+	hide := func(attendees []Attendee) []Attendee {
+		hidden := make([]Attendee, len(attendees))
+		for i, attendee := range attendees {
+			attendee.Email = ""
+			hidden[i] = attendee
+		}
+		return hidden
+	}
+	event.Attending = hide(event.Attending)
+	for i := range event.Occurrences {
+		event.Occurrences[i].Attending = hide(event.Occurrences[i].Attending)
+	}
 }
 
-// getEventByID - returns the event in `allEvents` that has
+// RSVP - the outcome of adding an attendee to an event
+type RSVP struct {
+	ConfirmationCode string
+	// WaitlistPosition is the attendee's place on the waitlist, starting
+	// at 1, or 0 if they got a spot.
+	WaitlistPosition int
+	// Confirmed is false until the attendee follows the link emailed to
+	// them. VerifyToken is that link's token and is only known right after
+	// the RSVP is made.
+	Confirmed   bool
+	VerifyToken string
+	// Occurrence is the key of the occurrence of a recurring event the
+	// RSVP is for, or empty for the whole event.
+	Occurrence string
+}
+
+// pendingRSVPLifetime is how long an RSVP may stay unverified before it
+// is dropped and its spot freed.
+const pendingRSVPLifetime = 48 * time.Hour
+
+// defaultEventDuration is how long events without an EndDate are taken to
+// last, e.g. in calendars.
+const defaultEventDuration = 2 * time.Hour
+
+// End - returns when the event finishes: its EndDate if one was given, or
+// defaultEventDuration after it starts.
+func (event Event) End() time.Time {
+	if event.EndDate != nil {
+		return *event.EndDate
+	}
+	return event.Date.Add(defaultEventDuration)
+}
+
+// IsMultiDay - reports whether the event ends on a later day than it
+// starts.
+func (event Event) IsMultiDay() bool {
+	if event.EndDate == nil {
+		return false
+	}
+	y1, m1, d1 := event.Date.Date()
+	y2, m2, d2 := event.EndDate.In(event.Date.Location()).Date()
+	return y1 != y2 || m1 != m2 || d1 != d2
+}
+
+// SpotsLeft - returns how many more people can RSVP to the event before
+// new RSVPs go to the waitlist. Only meaningful if Capacity is set. For
+// the whole of a recurring series, its busiest occurrence decides.
+func (event Event) SpotsLeft() int {
+	taken := len(event.Attending)
+	if event.Occurrence == "" {
+		busiest := 0
+		for _, rsvps := range event.OccurrenceRSVPs {
+			if n := len(rsvps.Attending); n > busiest {
+				busiest = n
+			}
+		}
+		taken += busiest
+	}
+	if left := event.Capacity - taken; left > 0 {
+		return left
+	}
+	return 0
+}
+
+// occurrenceRSVPsFor - returns the RSVPs to the occurrence with `key`,
+// creating an empty set if there are none yet.
+func (event *Event) occurrenceRSVPsFor(key string) *occurrenceRSVPs {
+	if event.OccurrenceRSVPs == nil {
+		event.OccurrenceRSVPs = map[string]*occurrenceRSVPs{}
+	}
+	if event.OccurrenceRSVPs[key] == nil {
+		event.OccurrenceRSVPs[key] = &occurrenceRSVPs{}
+	}
+	return event.OccurrenceRSVPs[key]
+}
+
+// waitlistPosition - returns the place of `email` on the waitlist of the
+// event, starting at 1, or 0 if they are not on it.
+func (event Event) waitlistPosition(email string) int {
+	for i, waiting := range event.Waitlist {
+		if strings.EqualFold(waiting, email) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// isPending - reports whether `email` has RSVP-ed to the event but not yet
+// verified their address.
+func (event Event) isPending(email string) bool {
+	for _, pending := range event.Pending {
+		if strings.EqualFold(pending, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetEvent - returns the event that has
 // the specified id and a boolean indicating whether or not
 // it was found. If it is not found, returns an empty event
 // and false.
-func getEventByID(id int) (Event, bool) {
+func (s *sqliteStore) GetEvent(id int) (Event, bool, error) {
 	var event Event
-	row := db.QueryRow("SELECT ID, Title, Location, Image, Date, RSVPMessage FROM Event WHERE ID = ?", id)
-	err := row.Scan(&event.ID, &event.Title, &event.Location, &event.Image, &event.Date, &event.RSVPMessage)
+	var emailDomains string
+	var endDate, updatedAt sql.NullTime
+	var recurFreq, recurUntil, recurExceptions string
+	var recurInterval, recurCount int
+	row := s.db.QueryRow("SELECT ID, PublicID, COALESCE(LegacyID, 0), COALESCE(Slug, ''), Title, Location, Image, Date, EndDate, COALESCE(TimeZone, ''), RSVPMessage, COALESCE(OrganizerTokenHash, ''), COALESCE(OwnerID, 0), COALESCE(EmailPolicy, ''), COALESCE(EmailDomains, ''), COALESCE(Capacity, 0), COALESCE(AttendeeVisibility, ''), Sequence, UpdatedAt, COALESCE(RecurFreq, ''), COALESCE(RecurInterval, 1), COALESCE(RecurUntil, ''), COALESCE(RecurCount, 0), COALESCE(RecurExceptions, '') FROM Event WHERE ID = ?", id)
+	err := row.Scan(&event.ID, &event.PublicID, &event.LegacyID, &event.Slug, &event.Title, &event.Location, &event.Image, &event.Date, &endDate, &event.TimeZone, &event.RSVPMessage, &event.OrganizerTokenHash, &event.OwnerID, &event.EmailPolicy, &emailDomains, &event.Capacity, &event.AttendeeVisibility, &event.Sequence, &updatedAt, &recurFreq, &recurInterval, &recurUntil, &recurCount, &recurExceptions)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return Event{}, false
+			return Event{}, false, nil
 		}
-		panic(err)
+		return Event{}, false, err
 	}
+	event.EmailDomains = parseEmailDomains(emailDomains)
+	if endDate.Valid {
+		event.EndDate = &endDate.Time
+	}
+	event.localizeTimes()
+	event.UpdatedAt = updatedAt.Time
+	event.Recurrence = newRecurrence(recurFreq, recurInterval, recurUntil, recurCount, recurExceptions)
 
 	// Fetch attendees for this event
-	attendeeRows, err := db.Query("SELECT Name FROM Attendee INNER JOIN Event_Attendee ON Attendee.ID = Event_Attendee.AttendeeID WHERE Event_Attendee.EventID = ?", id)
+	attendeeRows, err := s.db.Query("SELECT Email, DisplayName, Affiliation, Confirmed, Occurrence FROM Attendee INNER JOIN Event_Attendee ON Attendee.ID = Event_Attendee.AttendeeID WHERE Event_Attendee.EventID = ?", id)
 	if err != nil {
-		panic(err)
+		return Event{}, false, err
 	}
 	defer attendeeRows.Close()
 	for attendeeRows.Next() {
-		var attendee string
-		attendeeRows.Scan(&attendee)
-		event.Attending = append(event.Attending, attendee)
+		var attendee Attendee
+		var occurrence string
+		var confirmed bool
+		if err := attendeeRows.Scan(&attendee.Email, &attendee.DisplayName, &attendee.Affiliation, &confirmed, &occurrence); err != nil {
+			return Event{}, false, err
+		}
+		switch {
+		case occurrence != "" && confirmed:
+			rsvps := event.occurrenceRSVPsFor(occurrence)
+			rsvps.Attending = append(rsvps.Attending, attendee)
+		case occurrence != "":
+			rsvps := event.occurrenceRSVPsFor(occurrence)
+			rsvps.Pending = append(rsvps.Pending, attendee.Email)
+		case confirmed:
+			event.Attending = append(event.Attending, attendee)
+		default:
+			event.Pending = append(event.Pending, attendee.Email)
+		}
+	}
+	if err := attendeeRows.Err(); err != nil {
+		return Event{}, false, err
 	}
 
-	return event, true
+	// Fetch the waitlist, first in line first
+	waitlistRows, err := s.db.Query("SELECT Email, Occurrence FROM Attendee INNER JOIN Waitlist ON Attendee.ID = Waitlist.AttendeeID WHERE Waitlist.EventID = ? ORDER BY Waitlist.ID", id)
+	if err != nil {
+		return Event{}, false, err
+	}
+	defer waitlistRows.Close()
+	for waitlistRows.Next() {
+		var attendee, occurrence string
+		if err := waitlistRows.Scan(&attendee, &occurrence); err != nil {
+			return Event{}, false, err
+		}
+		if occurrence != "" {
+			rsvps := event.occurrenceRSVPsFor(occurrence)
+			rsvps.Waitlist = append(rsvps.Waitlist, attendee)
+		} else {
+			event.Waitlist = append(event.Waitlist, attendee)
+		}
+	}
+	if err := waitlistRows.Err(); err != nil {
+		return Event{}, false, err
+	}
+
+	return event, true, nil
 }
 
-// getAllEvents - returns slice of all events and an error status. Here it is
-// just returns `nil` always for the error. In mgt660, we're using similar
-// code that might actually return an error, but here it's always `nil`.
-func getAllEvents() ([]Event, error) {
-	rows, err := db.Query("SELECT ID, Title, Location, Image, Date, RSVPMessage FROM Event")
+// eventListColumns are the columns of Event that queryEvents expects, in
+// order.
+const eventListColumns = "ID, PublicID, COALESCE(LegacyID, 0), COALESCE(Slug, ''), Title, Location, Image, Date, EndDate, COALESCE(TimeZone, ''), RSVPMessage, COALESCE(AttendeeVisibility, ''), Sequence, UpdatedAt, COALESCE(RecurFreq, ''), COALESCE(RecurInterval, 1), COALESCE(RecurUntil, ''), COALESCE(RecurCount, 0), COALESCE(RecurExceptions, '')"
+
+// queryEvents - runs `query`, which must select eventListColumns, and
+// returns the resulting events without their attendees.
+func (s *sqliteStore) queryEvents(query string, args ...interface{}) ([]Event, error) {
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -69,190 +313,808 @@ func getAllEvents() ([]Event, error) {
 	var events []Event
 	for rows.Next() {
 		var event Event
-		if err := rows.Scan(&event.ID, &event.Title, &event.Location, &event.Image, &event.Date, &event.RSVPMessage); err != nil {
+		var endDate, updatedAt sql.NullTime
+		var recurFreq, recurUntil, recurExceptions string
+		var recurInterval, recurCount int
+		if err := rows.Scan(&event.ID, &event.PublicID, &event.LegacyID, &event.Slug, &event.Title, &event.Location, &event.Image, &event.Date, &endDate, &event.TimeZone, &event.RSVPMessage, &event.AttendeeVisibility, &event.Sequence, &updatedAt, &recurFreq, &recurInterval, &recurUntil, &recurCount, &recurExceptions); err != nil {
 			return nil, err
 		}
+		event.Recurrence = newRecurrence(recurFreq, recurInterval, recurUntil, recurCount, recurExceptions)
+		if endDate.Valid {
+			event.EndDate = &endDate.Time
+		}
+		event.localizeTimes()
+		event.UpdatedAt = updatedAt.Time
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// attendeeBatchSize is how many events loadAttendees looks up per query,
+// keeping well under SQLite's limit on the number of bound parameters.
+const attendeeBatchSize = 500
+
+// loadAttendees - fills in the confirmed attendees of `events`, with one
+// query per attendeeBatchSize events rather than one per event.
+func (s *sqliteStore) loadAttendees(events []Event) error {
+	byID := make(map[int]*Event, len(events))
+	for i := range events {
+		byID[events[i].ID] = &events[i]
+	}
 
-		// Fetch attendees for each event
-		attendeeRows, err := db.Query("SELECT Name FROM Attendee INNER JOIN Event_Attendee ON Attendee.ID = Event_Attendee.AttendeeID WHERE Event_Attendee.EventID = ?", event.ID)
+	for start := 0; start < len(events); start += attendeeBatchSize {
+		end := start + attendeeBatchSize
+		if end > len(events) {
+			end = len(events)
+		}
+		batch := events[start:end]
+		placeholders := make([]string, len(batch))
+		args := make([]interface{}, len(batch))
+		for i, event := range batch {
+			placeholders[i] = "?"
+			args[i] = event.ID
+		}
+
+		rows, err := s.db.Query("SELECT Event_Attendee.EventID, Email, DisplayName, Affiliation, Occurrence FROM Attendee INNER JOIN Event_Attendee ON Attendee.ID = Event_Attendee.AttendeeID WHERE Event_Attendee.EventID IN ("+strings.Join(placeholders, ", ")+") AND Event_Attendee.Confirmed = 1 ORDER BY Event_Attendee.EventID, Event_Attendee.rowid", args...)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		defer attendeeRows.Close()
-		for attendeeRows.Next() {
-			var attendee string
-			attendeeRows.Scan(&attendee)
-			event.Attending = append(event.Attending, attendee)
+		for rows.Next() {
+			var eventID int
+			var attendee Attendee
+			var occurrence string
+			if err := rows.Scan(&eventID, &attendee.Email, &attendee.DisplayName, &attendee.Affiliation, &occurrence); err != nil {
+				rows.Close()
+				return err
+			}
+			event := byID[eventID]
+			if occurrence != "" {
+				rsvps := event.occurrenceRSVPsFor(occurrence)
+				rsvps.Attending = append(rsvps.Attending, attendee)
+			} else {
+				event.Attending = append(event.Attending, attendee)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Orders that listEvents can sort events in.
+const (
+	eventSortDate     = "date"  // soonest first
+	eventSortDateDesc = "-date" // latest first
+	eventSortTitle    = "title" // alphabetically, ignoring case
+)
+
+// eventQuery - which events listEvents returns. Zero values leave a
+// filter out.
+type eventQuery struct {
+	// From and To keep events that are still going on at From and start
+	// before To. A recurring series counts until its last occurrence ends.
+	From time.Time
+	To   time.Time
+	// Search keeps events whose title or location contains it, ignoring
+	// case.
+	Search string
+	Sort   string
+	Limit  int
+	// Attendees loads the confirmed attendees of the events.
+	Attendees bool
+	// After continues a previous listing from the event it ended with.
+	After *eventCursor
+}
+
+// eventCursor - marks the last event of a page of listEvents, so the next
+// page can carry on after it even if events are added in the meantime.
+type eventCursor struct {
+	Sort  string    `json:"s"`
+	Date  time.Time `json:"d,omitempty"`
+	Title string    `json:"t,omitempty"`
+	ID    int       `json:"id"`
+}
+
+// isValidEventSort - reports whether `sort` is one of the eventSort*
+// orders.
+func isValidEventSort(sort string) bool {
+	switch sort {
+	case eventSortDate, eventSortDateDesc, eventSortTitle:
+		return true
+	}
+	return false
+}
+
+// ListEvents - returns a page of the events matching `q` in the order it
+// asks for, and a cursor for the next page, or nil if this is the last.
+func (s *sqliteStore) ListEvents(q eventQuery) ([]Event, *eventCursor, error) {
+	var where []string
+	var args []interface{}
+	if !q.From.IsZero() {
+		where = append(where, "(SeriesEnd IS NULL OR SeriesEnd > ?)")
+		args = append(args, q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		where = append(where, "Date < ?")
+		args = append(args, q.To.UTC())
+	}
+	if q.Search != "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(q.Search) + "%"
+		where = append(where, `(Title LIKE ? ESCAPE '\' OR Location LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern)
+	}
+
+	// Keyset pagination: carry on strictly after the last event of the
+	// previous page, using the ID to break ties
+	var order string
+	switch q.Sort {
+	case eventSortDateDesc:
+		order = "Date DESC, ID DESC"
+		if q.After != nil {
+			where = append(where, "(Date < ? OR (Date = ? AND ID < ?))")
+			args = append(args, q.After.Date.UTC(), q.After.Date.UTC(), q.After.ID)
+		}
+	case eventSortTitle:
+		order = "Title COLLATE NOCASE, ID"
+		if q.After != nil {
+			where = append(where, "(Title > ? COLLATE NOCASE OR (Title = ? COLLATE NOCASE AND ID > ?))")
+			args = append(args, q.After.Title, q.After.Title, q.After.ID)
 		}
+	default:
+		order = "Date, ID"
+		if q.After != nil {
+			where = append(where, "(Date > ? OR (Date = ? AND ID > ?))")
+			args = append(args, q.After.Date.UTC(), q.After.Date.UTC(), q.After.ID)
+		}
+	}
 
+	query := "SELECT " + eventListColumns + " FROM Event"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY " + order
+	if q.Limit > 0 {
+		// Fetch one extra event to tell whether there is another page
+		query += " LIMIT ?"
+		args = append(args, q.Limit+1)
+	}
+
+	events, err := s.queryEvents(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	events, next := pageEvents(q, events)
+	if q.Attendees {
+		if err := s.loadAttendees(events); err != nil {
+			return nil, nil, err
+		}
+	}
+	return events, next, nil
+}
+
+// EventsByOwner - returns the events created by the user with the
+// specified id, soonest first. Attendees are not loaded.
+func (s *sqliteStore) EventsByOwner(userID int) ([]Event, error) {
+	return s.queryEventSummaries("SELECT ID, PublicID, COALESCE(Slug, ''), Title, Location, Image, Date, COALESCE(TimeZone, '') FROM Event WHERE OwnerID = ? ORDER BY Date", userID)
+}
+
+// EventsAttendedBy - returns the events that `email` has RSVP-ed to,
+// soonest first. Attendees are not loaded.
+func (s *sqliteStore) EventsAttendedBy(email string) ([]Event, error) {
+	return s.queryEventSummaries("SELECT DISTINCT Event.ID, Event.PublicID, COALESCE(Event.Slug, ''), Event.Title, Event.Location, Event.Image, Event.Date, COALESCE(Event.TimeZone, '') FROM Event INNER JOIN Event_Attendee ON Event.ID = Event_Attendee.EventID INNER JOIN Attendee ON Attendee.ID = Event_Attendee.AttendeeID WHERE Attendee.Email = ? COLLATE NOCASE AND Event_Attendee.Confirmed = 1 ORDER BY Event.Date", email)
+}
+
+// queryEventSummaries - runs `query`, which must select the ID, PublicID,
+// Slug, Title, Location, Image, Date and TimeZone columns, and returns the
+// resulting events.
+func (s *sqliteStore) queryEventSummaries(query string, args ...interface{}) ([]Event, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var event Event
+		if err := rows.Scan(&event.ID, &event.PublicID, &event.Slug, &event.Title, &event.Location, &event.Image, &event.Date, &event.TimeZone); err != nil {
+			return nil, err
+		}
+		event.localizeTimes()
 		events = append(events, event)
 	}
-	return events, nil
+	return events, rows.Err()
 }
 
-// getMaxEventID returns the maximum of all
-// the ids of the events in allEvents
-func getMaxEventID() int {
-	var maxID int
-	err := db.QueryRow("SELECT MAX(ID) FROM Event").Scan(&maxID)
+// EventIDByPublicID - returns the ID of the event whose public ID is
+// `publicID`, and a boolean indicating whether there is one.
+func (s *sqliteStore) EventIDByPublicID(publicID string) (int, bool, error) {
+	var id int
+	err := s.db.QueryRow("SELECT ID FROM Event WHERE PublicID = ?", publicID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return id, err == nil, err
+}
+
+// EventIDBySlug - returns the ID of the event whose slug is `slug`, and a
+// boolean indicating whether there is one.
+func (s *sqliteStore) EventIDBySlug(slug string) (int, bool, error) {
+	var id int
+	err := s.db.QueryRow("SELECT ID FROM Event WHERE Slug = ?", slug).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return id, err == nil, err
+}
+
+// LegacyEventPathID - returns the Event.PathID of the event that had
+// number `id` in its URLs before events had public IDs, and a boolean
+// indicating whether there is one.
+func (s *sqliteStore) LegacyEventPathID(id int) (string, bool, error) {
+	var event Event
+	err := s.db.QueryRow("SELECT PublicID, COALESCE(Slug, '') FROM Event WHERE LegacyID = ?", id).Scan(&event.PublicID, &event.Slug)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return event.PathID(), err == nil, err
+}
+
+// AddRSVP - adds an attendee to an event, or to its waitlist if the event
+// is full, issuing them the confirmation code `code`.
+// The attendee's display name and affiliation are kept with the RSVP, so
+// nobody can change how someone else appears on other events.
+// For a recurring event, `occurrence` is the key of the occurrence the RSVP
+// is for, or empty for the whole series. The RSVP stays pending, and does
+// not take a spot, until VerifyRSVP is called with its VerifyToken. If the
+// attendee had already RSVP-ed, their existing RSVP is returned.
+func (s *sqliteStore) AddRSVP(eventID int, attendee Attendee, occurrence string, code string) (RSVP, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return RSVP{}, err
+	}
+	defer tx.Rollback()
+
+	rsvp, err := addRSVP(tx, eventID, attendee, occurrence, code)
 	if err != nil {
-		return -1 // Return -1 if there's an error or no rows in the table
+		return RSVP{}, err
 	}
-	return maxID
+	return rsvp, tx.Commit()
 }
 
-// Adds an attendee to an event
-func addAttendee(eventID int, email string) error {
+// addRSVP - does the work of AddRSVP within `tx`, so that checking for
+// a spot and taking it cannot be interleaved with another RSVP.
+func addRSVP(tx *sql.Tx, eventID int, attendee Attendee, occurrence string, code string) (RSVP, error) {
 	// Check if the event exists
-	if _, exists := getEventByID(eventID); !exists {
-		return errors.New("no such event")
+	var capacity int
+	err := tx.QueryRow("SELECT COALESCE(Capacity, 0) FROM Event WHERE ID = ?", eventID).Scan(&capacity)
+	if err == sql.ErrNoRows {
+		return RSVP{}, errEventNotFound
+	} else if err != nil {
+		return RSVP{}, err
 	}
 
 	// Insert or find the attendee
 	var attendeeID int
-	err := db.QueryRow("SELECT ID FROM Attendee WHERE Name = ?", email).Scan(&attendeeID)
+	err = tx.QueryRow("SELECT ID FROM Attendee WHERE Email = ? COLLATE NOCASE", attendee.Email).Scan(&attendeeID)
 	if err == sql.ErrNoRows {
-		res, err := db.Exec("INSERT INTO Attendee (Name) VALUES (?)", email)
+		res, err := tx.Exec("INSERT INTO Attendee (Email) VALUES (?)", attendee.Email)
 		if err != nil {
-			return err
+			return RSVP{}, err
 		}
 		id, err := res.LastInsertId()
 		if err != nil {
-			return err
+			return RSVP{}, err
 		}
 		attendeeID = int(id)
 	} else if err != nil {
+		return RSVP{}, err
+	}
+
+	// Drop the RSVPs that were never verified
+	if err := purgeExpiredRSVPs(tx, eventID); err != nil {
+		return RSVP{}, err
+	}
+
+	// Return the existing RSVP if the attendee already has one
+	if rsvp, found, err := getRSVP(tx, eventID, attendeeID, occurrence); err != nil || found {
+		return rsvp, err
+	}
+
+	// Link the attendee to the event, or put them in line if it is full
+	attending, err := countRSVPs(tx, eventID, occurrence)
+	if err != nil {
+		return RSVP{}, err
+	}
+	verifyToken := newVerifyToken()
+	now := time.Now().UTC()
+	table := "Event_Attendee"
+	if capacity > 0 && attending >= capacity {
+		table = "Waitlist"
+	}
+	_, err = tx.Exec("INSERT INTO "+table+" (EventID, AttendeeID, Occurrence, DisplayName, Affiliation, ConfirmationCode, Confirmed, VerifyToken, CreatedAt) VALUES (?, ?, ?, ?, ?, ?, 0, ?, ?)", eventID, attendeeID, occurrence, attendee.DisplayName, attendee.Affiliation, code, hashVerifyToken(verifyToken), now)
+	if err != nil {
+		return RSVP{}, err
+	}
+
+	rsvp, _, err := getRSVP(tx, eventID, attendeeID, occurrence)
+	if err != nil {
+		return RSVP{}, err
+	}
+	rsvp.VerifyToken = verifyToken
+	return rsvp, nil
+}
+
+// VerifyRSVP - marks the pending RSVP to the event with the specified id
+// whose verification token is `token` as confirmed and returns it. If the
+// spots were all taken by RSVPs verified in the meantime, it joins the end
+// of the waitlist instead. The boolean is false if no pending RSVP has
+// that token, for example because it expired.
+func (s *sqliteStore) VerifyRSVP(eventID int, token string) (RSVP, bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return RSVP{}, false, err
+	}
+	defer tx.Rollback()
+
+	rsvp, found, err := verifyRSVP(tx, eventID, token)
+	if err != nil || !found {
+		return RSVP{}, false, err
+	}
+	return rsvp, true, tx.Commit()
+}
+
+// verifyRSVP - does the work of VerifyRSVP within `tx`.
+func verifyRSVP(tx *sql.Tx, eventID int, token string) (RSVP, bool, error) {
+	if err := purgeExpiredRSVPs(tx, eventID); err != nil {
+		return RSVP{}, false, err
+	}
+
+	var attendeeID int
+	var occurrence string
+	var waiting bool
+	err := tx.QueryRow(`
+        SELECT AttendeeID, Occurrence, 0 FROM Event_Attendee WHERE EventID = ? AND VerifyToken = ? AND Confirmed = 0
+        UNION ALL
+        SELECT AttendeeID, Occurrence, 1 FROM Waitlist WHERE EventID = ? AND VerifyToken = ? AND Confirmed = 0`,
+		eventID, hashVerifyToken(token), eventID, hashVerifyToken(token)).Scan(&attendeeID, &occurrence, &waiting)
+	if err == sql.ErrNoRows {
+		return RSVP{}, false, nil
+	} else if err != nil {
+		return RSVP{}, false, err
+	}
+
+	// Spots go to RSVPs in the order they are verified, so one that was
+	// given a spot when it was made may find the event full by now
+	if !waiting {
+		var capacity int
+		if err := tx.QueryRow("SELECT COALESCE(Capacity, 0) FROM Event WHERE ID = ?", eventID).Scan(&capacity); err != nil {
+			return RSVP{}, false, err
+		}
+		attending, err := countRSVPs(tx, eventID, occurrence)
+		if err != nil {
+			return RSVP{}, false, err
+		}
+		if capacity > 0 && attending >= capacity {
+			if _, err := tx.Exec("INSERT INTO Waitlist (EventID, AttendeeID, Occurrence, DisplayName, Affiliation, ConfirmationCode, Confirmed, VerifyToken, CreatedAt) SELECT EventID, AttendeeID, Occurrence, DisplayName, Affiliation, ConfirmationCode, Confirmed, VerifyToken, CreatedAt FROM Event_Attendee WHERE EventID = ? AND AttendeeID = ? AND Occurrence = ?", eventID, attendeeID, occurrence); err != nil {
+				return RSVP{}, false, err
+			}
+			if _, err := tx.Exec("DELETE FROM Event_Attendee WHERE EventID = ? AND AttendeeID = ? AND Occurrence = ?", eventID, attendeeID, occurrence); err != nil {
+				return RSVP{}, false, err
+			}
+		}
+	}
+
+	for _, table := range []string{"Event_Attendee", "Waitlist"} {
+		if _, err := tx.Exec("UPDATE "+table+" SET Confirmed = 1, VerifyToken = NULL WHERE EventID = ? AND AttendeeID = ? AND Occurrence = ?", eventID, attendeeID, occurrence); err != nil {
+			return RSVP{}, false, err
+		}
+	}
+
+	// A verified person on the waitlist may take a spot that is free
+	if waiting {
+		if err := promoteFromWaitlist(tx, eventID); err != nil {
+			return RSVP{}, false, err
+		}
+	}
+
+	return getRSVP(tx, eventID, attendeeID, occurrence)
+}
+
+// purgeExpiredRSVPs - drops the RSVPs to the event with the specified id
+// that were not verified within pendingRSVPLifetime. They never took a
+// spot, so none is freed.
+func purgeExpiredRSVPs(tx *sql.Tx, eventID int) error {
+	cutoff := time.Now().UTC().Add(-pendingRSVPLifetime)
+	for _, table := range []string{"Event_Attendee", "Waitlist"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE EventID = ? AND Confirmed = 0 AND CreatedAt < ?", eventID, cutoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getRSVP - looks up the RSVP of the attendee with the specified id to the
+// event with the specified id, or to its occurrence with key `occurrence`,
+// whether they are attending or waitlisted.
+func getRSVP(tx *sql.Tx, eventID int, attendeeID int, occurrence string) (RSVP, bool, error) {
+	rsvp := RSVP{Occurrence: occurrence}
+	var code sql.NullString
+	err := tx.QueryRow("SELECT ConfirmationCode, Confirmed FROM Event_Attendee WHERE EventID = ? AND AttendeeID = ? AND Occurrence = ?", eventID, attendeeID, occurrence).Scan(&code, &rsvp.Confirmed)
+	if err == nil {
+		rsvp.ConfirmationCode = code.String
+		return rsvp, true, nil
+	} else if err != sql.ErrNoRows {
+		return RSVP{}, false, err
+	}
+
+	err = tx.QueryRow("SELECT ConfirmationCode, Confirmed, (SELECT COUNT(*) FROM Waitlist AS Ahead WHERE Ahead.EventID = Waitlist.EventID AND Ahead.Occurrence = Waitlist.Occurrence AND Ahead.ID <= Waitlist.ID) FROM Waitlist WHERE EventID = ? AND AttendeeID = ? AND Occurrence = ?", eventID, attendeeID, occurrence).
+		Scan(&code, &rsvp.Confirmed, &rsvp.WaitlistPosition)
+	if err == sql.ErrNoRows {
+		return RSVP{}, false, nil
+	} else if err != nil {
+		return RSVP{}, false, err
+	}
+	rsvp.ConfirmationCode = code.String
+	return rsvp, true, nil
+}
+
+// promoteFromWaitlist - moves verified people from the waitlist of the
+// event with the specified id onto its attendee list, in the order they
+// joined, as long as the occurrence they are waiting for has spots left.
+// Pending people stay in line until they are verified. Must run in the
+// same transaction that freed the spots.
+func promoteFromWaitlist(tx *sql.Tx, eventID int) error {
+	var capacity int
+	err := tx.QueryRow("SELECT COALESCE(Capacity, 0) FROM Event WHERE ID = ?", eventID).Scan(&capacity)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	type waiting struct {
+		id         int
+		occurrence string
+	}
+	rows, err := tx.Query("SELECT ID, Occurrence FROM Waitlist WHERE EventID = ? AND Confirmed = 1 ORDER BY ID", eventID)
+	if err != nil {
+		return err
+	}
+	var waitlist []waiting
+	for rows.Next() {
+		var w waiting
+		if err := rows.Scan(&w.id, &w.occurrence); err != nil {
+			rows.Close()
+			return err
+		}
+		waitlist = append(waitlist, w)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, w := range waitlist {
+		if capacity > 0 {
+			attending, err := countRSVPs(tx, eventID, w.occurrence)
+			if err != nil {
+				return err
+			}
+			if attending >= capacity {
+				continue
+			}
+		}
+
+		if _, err := tx.Exec("INSERT INTO Event_Attendee (EventID, AttendeeID, Occurrence, DisplayName, Affiliation, ConfirmationCode, Confirmed, VerifyToken, CreatedAt) SELECT EventID, AttendeeID, Occurrence, DisplayName, Affiliation, ConfirmationCode, Confirmed, VerifyToken, CreatedAt FROM Waitlist WHERE ID = ?", w.id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM Waitlist WHERE ID = ?", w.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countRSVPs - returns how many spots are taken at the occurrence of the
+// event with key `occurrence`, or at the whole event if it is empty. Only
+// verified RSVPs take a spot. RSVPs to the whole of a recurring series
+// take a spot at every occurrence, so for the series the busiest
+// occurrence counts.
+func countRSVPs(tx *sql.Tx, eventID int, occurrence string) (int, error) {
+	var series, single int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM Event_Attendee WHERE EventID = ? AND Occurrence = '' AND Confirmed = 1", eventID).Scan(&series); err != nil {
+		return 0, err
+	}
+	var err error
+	if occurrence != "" {
+		err = tx.QueryRow("SELECT COUNT(*) FROM Event_Attendee WHERE EventID = ? AND Occurrence = ? AND Confirmed = 1", eventID, occurrence).Scan(&single)
+	} else {
+		err = tx.QueryRow("SELECT COALESCE(MAX(Taken), 0) FROM (SELECT COUNT(*) AS Taken FROM Event_Attendee WHERE EventID = ? AND Occurrence <> '' AND Confirmed = 1 GROUP BY Occurrence)", eventID).Scan(&single)
+	}
+	return series + single, err
+}
+
+// ConfirmationCode - returns the confirmation code stored for the RSVP
+// of `email` to the event with the specified id, whether they are
+// attending or waitlisted. The boolean is false if there is no such RSVP
+// or it predates confirmation codes.
+func (s *sqliteStore) ConfirmationCode(eventID int, email string) (string, bool, error) {
+	var code sql.NullString
+	err := s.db.QueryRow(`
+        SELECT ConfirmationCode FROM Event_Attendee INNER JOIN Attendee ON Attendee.ID = Event_Attendee.AttendeeID
+        WHERE Event_Attendee.EventID = ? AND Attendee.Email = ? COLLATE NOCASE
+        UNION ALL
+        SELECT ConfirmationCode FROM Waitlist INNER JOIN Attendee ON Attendee.ID = Waitlist.AttendeeID
+        WHERE Waitlist.EventID = ? AND Attendee.Email = ? COLLATE NOCASE
+        LIMIT 1`, eventID, email, eventID, email).Scan(&code)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return code.String, code.Valid && code.String != "", nil
+}
+
+// EachAttendeeRecord - calls `fn` with each RSVP to the event with the
+// specified id that is attending or still waiting to be verified, in the
+// order they were made, and then with each person on its waitlist, first in
+// line first, while reading them from the database. Pending RSVPs that have
+// expired are skipped.
+func (s *sqliteStore) EachAttendeeRecord(eventID int, fn func(AttendeeRecord) error) error {
+	cutoff := time.Now().UTC().Add(-pendingRSVPLifetime)
+	rows, err := s.db.Query("SELECT Event_Attendee.DisplayName, Event_Attendee.Affiliation, Attendee.Email, Event_Attendee.CreatedAt, Event_Attendee.Confirmed, Event_Attendee.Occurrence, COALESCE(Event_Attendee.ConfirmationCode, ''), 0 FROM Event_Attendee INNER JOIN Attendee ON Attendee.ID = Event_Attendee.AttendeeID WHERE Event_Attendee.EventID = ? AND (Event_Attendee.Confirmed = 1 OR Event_Attendee.CreatedAt >= ?) ORDER BY Event_Attendee.CreatedAt, Event_Attendee.rowid", eventID, cutoff)
+	if err != nil {
+		return err
+	}
+	if err := eachAttendeeRow(rows, fn); err != nil {
+		return err
+	}
+
+	rows, err = s.db.Query("SELECT Waitlist.DisplayName, Waitlist.Affiliation, Attendee.Email, Waitlist.CreatedAt, Waitlist.Confirmed, Waitlist.Occurrence, COALESCE(Waitlist.ConfirmationCode, ''), (SELECT COUNT(*) FROM Waitlist AS Ahead WHERE Ahead.EventID = Waitlist.EventID AND Ahead.Occurrence = Waitlist.Occurrence AND Ahead.ID <= Waitlist.ID AND (Ahead.Confirmed = 1 OR Ahead.CreatedAt >= ?)) FROM Waitlist INNER JOIN Attendee ON Attendee.ID = Waitlist.AttendeeID WHERE Waitlist.EventID = ? AND (Waitlist.Confirmed = 1 OR Waitlist.CreatedAt >= ?) ORDER BY Waitlist.Occurrence, Waitlist.ID", cutoff, eventID, cutoff)
+	if err != nil {
 		return err
 	}
+	return eachAttendeeRow(rows, fn)
+}
+
+// eachAttendeeRow - calls `fn` with the AttendeeRecord in each of `rows`,
+// which hold the name, affiliation, email, RSVP time, whether it was
+// verified, occurrence, confirmation code and waitlist position, the last
+// being 0 for people who are not on the waitlist. Closes `rows`.
+func eachAttendeeRow(rows *sql.Rows, fn func(AttendeeRecord) error) error {
+	defer rows.Close()
 
-	// Link the attendee to the event
-	_, err = db.Exec("INSERT OR IGNORE INTO Event_Attendee (EventID, AttendeeID) VALUES (?, ?)", eventID, attendeeID)
-	return err
+	for rows.Next() {
+		var record AttendeeRecord
+		var createdAt sql.NullTime
+		var confirmed bool
+		if err := rows.Scan(&record.Name, &record.Affiliation, &record.Email, &createdAt, &confirmed, &record.Occurrence, &record.ConfirmationCode, &record.WaitlistPosition); err != nil {
+			return err
+		}
+		if createdAt.Valid {
+			record.RSVPAt = &createdAt.Time
+		}
+		switch {
+		case record.WaitlistPosition > 0:
+			record.Status = rsvpStatusWaitlisted
+		case confirmed:
+			record.Status = rsvpStatusAttending
+		default:
+			record.Status = rsvpStatusPending
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
 
-// Add an event to the list of events.
-func addEvent(event Event) {
+// CancelRSVP - cancels the RSVP of `email` to the event with the
+// specified id, or to its occurrence with key `occurrence`, or takes them
+// off the waitlist. If that frees a spot, the first person on the waitlist
+// gets it. Returns false if that email had not RSVP-ed.
+func (s *sqliteStore) CancelRSVP(eventID int, email string, occurrence string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM Event_Attendee WHERE EventID = ? AND Occurrence = ? AND AttendeeID IN (SELECT ID FROM Attendee WHERE Email = ? COLLATE NOCASE)", eventID, occurrence, email)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if n > 0 {
+		err = promoteFromWaitlist(tx, eventID)
+	} else {
+		res, err = tx.Exec("DELETE FROM Waitlist WHERE EventID = ? AND Occurrence = ? AND AttendeeID IN (SELECT ID FROM Attendee WHERE Email = ? COLLATE NOCASE)", eventID, occurrence, email)
+		if err == nil {
+			n, err = res.RowsAffected()
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	return n > 0, tx.Commit()
+}
+
+// CreateEvent - adds an event to the list of events and returns its ID.
+// Unless `event` already has them, SQLite picks the ID, a new public ID is
+// generated and the slug is made from the title.
+func (s *sqliteStore) CreateEvent(event Event) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	// Insert the event into the database
-	if event.ID == 0 {
-		event.ID = getMaxEventID() + 1
+	var id interface{}
+	if event.ID != 0 {
+		id = event.ID
 	}
-	res, err := db.Exec("INSERT INTO Event (ID, Title, Location, Image, Date, RSVPMessage) VALUES (?, ?, ?, ?, ?, ?)", event.ID, event.Title, event.Location, event.Image, event.Date, event.RSVPMessage)
+	if event.PublicID == "" {
+		if event.PublicID, err = unusedPublicID(tx); err != nil {
+			return 0, err
+		}
+	}
+	if event.Slug == "" {
+		if event.Slug, err = unusedSlug(tx, event.Title); err != nil {
+			return 0, err
+		}
+	}
+	var ownerID interface{}
+	if event.OwnerID != 0 {
+		ownerID = event.OwnerID
+	}
+	recurFreq, recurInterval, recurUntil, recurCount, recurExceptions := event.recurrenceColumns()
+	res, err := tx.Exec("INSERT INTO Event (ID, PublicID, Slug, Title, Location, Image, Date, EndDate, TimeZone, RSVPMessage, OrganizerTokenHash, OwnerID, EmailPolicy, EmailDomains, Capacity, AttendeeVisibility, UpdatedAt, RecurFreq, RecurInterval, RecurUntil, RecurCount, RecurExceptions, SeriesEnd) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", id, event.PublicID, event.Slug, event.Title, event.Location, event.Image, event.Date.UTC(), utcTime(event.EndDate), event.TimeZone, event.RSVPMessage, event.OrganizerTokenHash, ownerID, event.EmailPolicy, strings.Join(event.EmailDomains, ","), event.Capacity, event.AttendeeVisibility, time.Now().UTC(), recurFreq, recurInterval, recurUntil, recurCount, recurExceptions, utcTime(event.seriesEnd()))
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
 
 	// Retrieve the new event ID
-	id, err := res.LastInsertId()
+	newID, err := res.LastInsertId()
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
-	event.ID = int(id)
+	event.ID = int(newID)
 
-	// Insert attendees if any are provided
+	// Insert attendees if any are provided. They are taken as already
+	// verified, and were never issued a confirmation code.
 	for _, attendee := range event.Attending {
-		addAttendee(event.ID, attendee)
+		rsvp, err := addRSVP(tx, event.ID, attendee, "", "")
+		if err != nil {
+			return 0, err
+		}
+		if _, _, err := verifyRSVP(tx, event.ID, rsvp.VerifyToken); err != nil {
+			return 0, err
+		}
 	}
+	return event.ID, tx.Commit()
 }
 
-func initDB() (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", "./events.db")
+// unusedPublicID - returns a new public ID that no event has yet.
+func unusedPublicID(tx *sql.Tx) (string, error) {
+	for {
+		publicID := newPublicID()
+		var taken bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM Event WHERE PublicID = ?)", publicID).Scan(&taken); err != nil {
+			return "", err
+		}
+		if !taken {
+			return publicID, nil
+		}
+	}
+}
+
+// UpdateEvent - overwrites the stored title, location, image, date, email
+// policy and capacity of the event with the same ID as `event`. If the
+// capacity went up, people on the waitlist are given the new spots.
+// Returns errEventNotFound if there is no such event.
+func (s *sqliteStore) UpdateEvent(event Event) error {
+	tx, err := s.db.Begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	// Create tables if they don't exist
-	_, err = db.Exec(`
-        CREATE TABLE IF NOT EXISTS Event (
-            ID INTEGER PRIMARY KEY,
-            Title TEXT NOT NULL,
-            Location TEXT,
-            Image TEXT,
-            Date DATETIME,
-            RSVPMessage TEXT
-        );
-        
-        CREATE TABLE IF NOT EXISTS Attendee (
-            ID INTEGER PRIMARY KEY AUTOINCREMENT,
-            Name TEXT NOT NULL
-        );
-        
-        CREATE TABLE IF NOT EXISTS Event_Attendee (
-            EventID INTEGER,
-            AttendeeID INTEGER,
-            PRIMARY KEY (EventID, AttendeeID),
-            FOREIGN KEY (EventID) REFERENCES Event(ID) ON DELETE CASCADE,
-            FOREIGN KEY (AttendeeID) REFERENCES Attendee(ID) ON DELETE CASCADE
-        );
-    `)
+	defer tx.Rollback()
+
+	recurFreq, recurInterval, recurUntil, recurCount, recurExceptions := event.recurrenceColumns()
+	res, err := tx.Exec("UPDATE Event SET Title = ?, Location = ?, Image = ?, Date = ?, EndDate = ?, TimeZone = ?, EmailPolicy = ?, EmailDomains = ?, Capacity = ?, AttendeeVisibility = ?, Sequence = Sequence + 1, UpdatedAt = ?, RecurFreq = ?, RecurInterval = ?, RecurUntil = ?, RecurCount = ?, RecurExceptions = ?, SeriesEnd = ? WHERE ID = ?", event.Title, event.Location, event.Image, event.Date.UTC(), utcTime(event.EndDate), event.TimeZone, event.EmailPolicy, strings.Join(event.EmailDomains, ","), event.Capacity, event.AttendeeVisibility, time.Now().UTC(), recurFreq, recurInterval, recurUntil, recurCount, recurExceptions, utcTime(event.seriesEnd()), event.ID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return db, nil
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errEventNotFound
+	}
+
+	if err := promoteFromWaitlist(tx, event.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// init is run once when this file is first loaded. See
-// https://golang.org/doc/effective_go.html#init
-// https://medium.com/golangspec/init-functions-in-go-eac191b3860a
-func init() {
-	var err error
-	db, err = initDB()
+// DeleteEvent - removes the event with the specified id. Its RSVPs and
+// waitlist go with it through the ON DELETE CASCADE of their foreign keys,
+// which openDB turns on. Returns errEventNotFound if there is no such
+// event.
+func (s *sqliteStore) DeleteEvent(id int) error {
+	res, err := s.db.Exec("DELETE FROM Event WHERE ID = ?", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if n == 0 {
+		return errEventNotFound
+	}
+	return nil
+}
+
+// sqliteOptions are set on every connection to the database: foreign
+// keys are enforced, so deleting an event deletes its RSVPs; the journal
+// is a write-ahead log, so readers do not block the writer; a connection
+// waits up to five seconds for a lock instead of failing straight away;
+// and transactions take the write lock when they begin, so two of them
+// cannot both read, e.g. that an event has a spot left, and then write.
+var sqliteOptions = url.Values{
+	"_foreign_keys": {"1"},
+	"_journal_mode": {"WAL"},
+	"_busy_timeout": {"5000"},
+	"_txlock":       {"immediate"},
+}
+
+// sqliteDSN - returns the data source name that opens the database at
+// `path` with sqliteOptions.
+func sqliteDSN(path string) string {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
 	}
+	return path + separator + sqliteOptions.Encode()
+}
 
-	newYorkTimeZone, err := time.LoadLocation("America/New_York")
+// openDB - opens the SQLite database at `path` without changing its
+// schema. A path of ":memory:" gives a database that only lives as long as
+// the returned *sql.DB.
+func openDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", sqliteDSN(path))
 	if err != nil {
-		panic("Could not load timezone database on your system!")
+		return nil, err
+	}
+	if strings.Contains(path, ":memory:") {
+		// Every connection would get its own empty in-memory database
+		db.SetMaxOpenConns(1)
 	}
+	return db, nil
+}
 
-	// Check if the Event table is empty before inserting default events
-	var count int
-	err = db.QueryRow("SELECT COUNT(*) FROM event").Scan(&count)
+// initDB - opens the SQLite database at `path` and brings its schema up
+// to date by applying any pending migrations. Events from before time
+// zones were stored are given `timeZone`.
+func initDB(path string, timeZone string) (*sql.DB, error) {
+	db, err := openDB(path)
 	if err != nil {
-		panic(err)
-	}
-
-	if count == 0 {
-		defaultEvents := []Event{
-			{
-				ID:        1,
-				Title:     "SOM House Party",
-				Date:      time.Date(2025, 10, 17, 16, 30, 0, 0, newYorkTimeZone),
-				Image:     "http://i.imgur.com/pXjrQ.gif",
-				Location:  "Kyle's house",
-				Attending: []string{"kyle.jensen@yale.edu", "kim.kardashian@yale.edu"},
-			},
-			{
-				ID:        2,
-				Title:     "BBQ party for hackers and nerds",
-				Date:      time.Date(2025, 10, 19, 19, 0, 0, 0, newYorkTimeZone),
-				Image:     "http://i.imgur.com/7pe2k.gif",
-				Location:  "Judy Chevalier's house",
-				Attending: []string{"kyle.jensen@yale.edu", "kim.kardashian@yale.edu"},
-			},
-			{
-				ID:        3,
-				Title:     "BBQ for managers",
-				Date:      time.Date(2025, 12, 2, 18, 0, 0, 0, newYorkTimeZone),
-				Image:     "http://i.imgur.com/CJLrRqh.gif",
-				Location:  "Barry Nalebuff's house",
-				Attending: []string{"kim.kardashian@yale.edu"},
-			},
-			// Here I didn't include an even #4 just to show that
-			// events in a real system might be deleted and so you
-			// would need to handle such cases. Eg. if somebody
-			// tries to get event #4, you would typically return
-			// a 404 error which means "not found".
-			{
-				ID:        5,
-				Title:     "Cooking lessons for the busy business student",
-				Date:      time.Date(2025, 12, 21, 19, 0, 0, 0, newYorkTimeZone),
-				Image:     "http://i.imgur.com/02KT9.gif",
-				Location:  "Yale Farm",
-				Attending: []string{"homer.simpson@yale.edu"},
-			},
-		}
-		for _, event := range defaultEvents {
-			addEvent(event)
-		}
+		return nil, err
+	}
+	if err := migrateUp(db, schemaMigrations(timeZone)); err != nil {
+		db.Close()
+		return nil, err
 	}
+	return db, nil
 }
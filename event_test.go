@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventEnd(t *testing.T) {
+	start := time.Date(2030, 1, 7, 18, 0, 0, 0, time.UTC)
+	sameDay := start.Add(5 * time.Hour)
+	nextDay := start.Add(7 * time.Hour)
+	tests := []struct {
+		name         string
+		end          *time.Time
+		wantEnd      time.Time
+		wantMultiDay bool
+	}{
+		{name: "no end", wantEnd: start.Add(defaultEventDuration)},
+		{name: "same day", end: &sameDay, wantEnd: sameDay},
+		{name: "past midnight", end: &nextDay, wantEnd: nextDay, wantMultiDay: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event := Event{Date: start, EndDate: test.end}
+			if got := event.End(); !got.Equal(test.wantEnd) {
+				t.Errorf("End = %v, want %v", got, test.wantEnd)
+			}
+			if got := event.IsMultiDay(); got != test.wantMultiDay {
+				t.Errorf("IsMultiDay = %v, want %v", got, test.wantMultiDay)
+			}
+		})
+	}
+}
+
+func TestApplyEndDate(t *testing.T) {
+	start := time.Date(2030, 1, 7, 18, 0, 0, 0, time.UTC)
+	end := time.Date(2030, 1, 8, 2, 0, 0, 0, time.UTC)
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name      string
+		current   *time.Time
+		endDate   *string
+		wantEnd   *time.Time
+		wantError bool
+	}{
+		{name: "not submitted", current: &end, wantEnd: &end},
+		{name: "set", endDate: str("2030-01-08T02:00"), wantEnd: &end},
+		{name: "removed", current: &end, endDate: str("")},
+		{name: "malformed", endDate: str("tomorrow"), wantError: true},
+		{name: "before the start", endDate: str("2030-01-07T17:00"), wantError: true},
+		{name: "at the start", endDate: str("2030-01-07T18:00"), wantError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event := Event{Date: start, EndDate: test.current, TimeZone: "UTC"}
+			errs := eventFields{EndDate: test.endDate}.apply(&event, true)
+			if gotError := len(errs) > 0; gotError != test.wantError {
+				t.Fatalf("apply errors = %v, want errors: %v", errs, test.wantError)
+			}
+			if test.wantError {
+				if errs[0].Field != "end_date" {
+					t.Errorf("rejected field = %q, want end_date", errs[0].Field)
+				}
+				return
+			}
+			if (event.EndDate == nil) != (test.wantEnd == nil) || event.EndDate != nil && !event.EndDate.Equal(*test.wantEnd) {
+				t.Errorf("end date = %v, want %v", event.EndDate, test.wantEnd)
+			}
+		})
+	}
+}
+
+func TestApplyTitleAndLocation(t *testing.T) {
+	tests := []struct {
+		value     string
+		wantError bool
+	}{
+		{value: "Holiday party"},
+		{value: "Party", wantError: true},
+		{value: "Holiday\r\nparty", wantError: true},
+		{value: "Holiday\tparty", wantError: true},
+		{value: "Holiday\x00party", wantError: true},
+	}
+	for _, test := range tests {
+		value := test.value
+
+		event := Event{TimeZone: "UTC"}
+		errs := eventFields{Title: &value}.apply(&event, true)
+		if gotError := len(errs) > 0; gotError != test.wantError {
+			t.Errorf("apply(title %q) errors = %v, want errors: %v", test.value, errs, test.wantError)
+		} else if !test.wantError && event.Title != test.value {
+			t.Errorf("apply(title %q) set the title to %q", test.value, event.Title)
+		}
+
+		event = Event{TimeZone: "UTC"}
+		errs = eventFields{Location: &value}.apply(&event, true)
+		if gotError := len(errs) > 0; gotError != test.wantError {
+			t.Errorf("apply(location %q) errors = %v, want errors: %v", test.value, errs, test.wantError)
+		} else if !test.wantError && event.Location != test.value {
+			t.Errorf("apply(location %q) set the location to %q", test.value, event.Location)
+		}
+	}
+}
+
+func TestApplyPastDate(t *testing.T) {
+	started := time.Now().AddDate(0, 0, -7).UTC().Truncate(time.Minute)
+	tests := []struct {
+		name      string
+		date      string
+		wantError bool
+	}{
+		{name: "unchanged", date: started.Format("2006-01-02T15:04")},
+		{name: "moved into the past", date: started.Add(time.Hour).Format("2006-01-02T15:04"), wantError: true},
+		{name: "moved into the future", date: futureDate()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event := Event{Date: started, TimeZone: "UTC"}
+			date := test.date
+			errs := eventFields{Date: &date}.apply(&event, true)
+			if gotError := len(errs) > 0; gotError != test.wantError {
+				t.Errorf("apply errors = %v, want errors: %v", errs, test.wantError)
+			}
+		})
+	}
+}
+
+func TestIndexListsUpcomingEvents(t *testing.T) {
+	mustAddEvent(t, Event{Title: "Finished party", Date: time.Now().AddDate(0, 0, -1)})
+	mustAddEvent(t, Event{Title: "Coming party", Date: time.Now().AddDate(0, 0, 1)})
+
+	w := serve(t, http.MethodGet, "/", "")
+	expectStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), "Coming party") {
+		t.Error("the index does not list an upcoming event")
+	}
+	if strings.Contains(w.Body.String(), "Finished party") {
+		t.Error("the index lists a past event")
+	}
+}
@@ -2,7 +2,10 @@ module classproject
 
 go 1.18
 
-require github.com/go-chi/chi/v5 v5.1.0
+require (
+	github.com/go-chi/chi/v5 v5.1.0
+	golang.org/x/crypto v0.24.0
+)
 
 require (
 	github.com/cespare/reflex v0.3.1 // indirect
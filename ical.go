@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// icalDomainFromURL - returns the host of `rawURL`, which events' UIDs end
+// in unless ICAL_DOMAIN says otherwise, or "localhost" if it has none.
+func icalDomainFromURL(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "localhost"
+}
+
+// eventUID - returns the iCalendar UID of the event. It only depends on
+// the event's public ID, so it stays the same when the event is edited and
+// is never shared with another event, unlike the ID the event is stored
+// under, which SQLite may reuse once the event is deleted. Events from
+// before public IDs keep the UID made from their old number, so calendars
+// that subscribed back then do not see them as new events.
+func (s *server) eventUID(event Event) string {
+	if event.LegacyID != 0 {
+		return "event-" + strconv.Itoa(event.LegacyID) + "@" + s.icalDomain
+	}
+	return "event-" + event.PublicID + "@" + s.icalDomain
+}
+
+// icalTime - formats `t` as an iCalendar UTC date-time.
+func icalTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// icalZonedTime - returns the property `name` with a TZID parameter naming
+// `loc` and `t` as a local date-time there, so that a recurrence keeps the
+// same wall-clock time across daylight saving changes, as
+// occurrenceStarts does. Times in UTC are written as UTC date-times.
+func icalZonedTime(name string, t time.Time, loc *time.Location) (string, string) {
+	if loc == time.UTC {
+		return name, icalTime(t)
+	}
+	return name + ";TZID=" + loc.String(), t.In(loc).Format("20060102T150405")
+}
+
+// icalEscape - escapes the characters that are special in iCalendar TEXT
+// values (RFC 5545, section 3.3.11).
+var icalEscape = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+	"\r", `\n`,
+).Replace
+
+// icalWriter - writes iCalendar content lines, folding them at 75 octets
+// as RFC 5545, section 3.1 requires.
+type icalWriter struct {
+	w *bufio.Writer
+}
+
+func (iw icalWriter) line(name string, value string) {
+	line := name + ":" + value
+	// Continuation lines start with a space, which counts towards their
+	// 75 octets
+	limit := 75
+	for len(line) > limit {
+		// Never split a multi-byte character across lines
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		iw.w.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	iw.w.WriteString(line + "\r\n")
+}
+
+// icalRRule - returns the RRULE value of a recurring event.
+func icalRRule(event Event) string {
+	r := event.Recurrence
+	rule := "FREQ=" + strings.ToUpper(r.Freq)
+	if r.Interval > 1 {
+		rule += ";INTERVAL=" + strconv.Itoa(r.Interval)
+	}
+	if r.Count > 0 {
+		rule += ";COUNT=" + strconv.Itoa(r.Count)
+	}
+	if r.Until != "" {
+		// UNTIL must be in UTC even though DTSTART has a TZID, so use the
+		// last moment of that day in the event's time zone
+		if day, err := time.ParseInLocation(occurrenceKeyLayout, r.Until, event.location()); err == nil {
+			rule += ";UNTIL=" + icalTime(day.AddDate(0, 0, 1).Add(-time.Second))
+		}
+	}
+	return rule
+}
+
+// icalExDates - returns the EXDATE value listing the start times of the
+// skipped occurrences of a recurring event, or "" if there are none. Like
+// DTSTART, they are local times in the event's time zone.
+func icalExDates(event Event) string {
+	loc := event.location()
+	start := event.Date.In(loc)
+	var exdates []string
+	for _, exception := range event.Recurrence.Exceptions {
+		day, err := time.ParseInLocation(occurrenceKeyLayout, exception, loc)
+		if err != nil {
+			continue
+		}
+		skipped := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), start.Second(), 0, loc)
+		_, value := icalZonedTime("EXDATE", skipped, loc)
+		exdates = append(exdates, value)
+	}
+	return strings.Join(exdates, ",")
+}
+
+// icalTimeZoneYears is how many years past its start the VTIMEZONE of a
+// series that goes on forever covers.
+const icalTimeZoneYears = 10
+
+// icalZoneSpan - a time zone that recurring events in a calendar are
+// anchored in, and the times it must be described for.
+type icalZoneSpan struct {
+	loc      *time.Location
+	from, to time.Time
+}
+
+// icalOffset - formats a UTC offset in seconds as an iCalendar UTC-OFFSET,
+// e.g. -0500.
+func icalOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	offset := sign + fmt.Sprintf("%02d%02d", seconds/3600, seconds/60%60)
+	if seconds%60 != 0 {
+		offset += fmt.Sprintf("%02d", seconds%60)
+	}
+	return offset
+}
+
+// observance - writes a STANDARD or DAYLIGHT component saying that from
+// `onset` the UTC offset of `loc` changes from `offsetFrom` seconds to
+// whatever it is at `onset`.
+func (iw icalWriter) observance(loc *time.Location, onset time.Time, offsetFrom int) {
+	local := onset.In(loc)
+	name, offsetTo := local.Zone()
+	kind := "STANDARD"
+	if local.IsDST() {
+		kind = "DAYLIGHT"
+	}
+	iw.line("BEGIN", kind)
+	// The onset is given in the local time in effect before it
+	iw.line("DTSTART", onset.UTC().Add(time.Duration(offsetFrom)*time.Second).Format("20060102T150405"))
+	iw.line("TZOFFSETFROM", icalOffset(offsetFrom))
+	iw.line("TZOFFSETTO", icalOffset(offsetTo))
+	iw.line("TZNAME", icalEscape(name))
+	iw.line("END", kind)
+}
+
+// icalTransition - a change of the UTC offset of a time zone: when it
+// takes effect and the offset in seconds before it.
+type icalTransition struct {
+	at         time.Time
+	offsetFrom int
+}
+
+// icalTransitions caches zoneTransitions, keyed by zone name and year.
+var icalTransitions = struct {
+	mu    sync.Mutex
+	years map[string][]icalTransition
+}{years: map[string][]icalTransition{}}
+
+// zoneTransitions - returns the changes of UTC offset of `loc` during the
+// UTC year `year`, straight from the time zone database. They are worked
+// out once per zone and year, and cached after that.
+func zoneTransitions(loc *time.Location, year int) []icalTransition {
+	key := loc.String() + " " + strconv.Itoa(year)
+	icalTransitions.mu.Lock()
+	transitions, found := icalTransitions.years[key]
+	icalTransitions.mu.Unlock()
+	if found {
+		return transitions
+	}
+
+	start := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(1, 0, 0)
+	_, offset := start.In(loc).Zone()
+	for day := start; day.Before(end); day = day.Add(24 * time.Hour) {
+		next := day.Add(24 * time.Hour)
+		if _, nextOffset := next.In(loc).Zone(); nextOffset == offset {
+			continue
+		}
+		// Narrow down to the second the offset changed
+		before, after := day, next
+		for after.Sub(before) > time.Second {
+			middle := before.Add(after.Sub(before) / 2)
+			if _, o := middle.In(loc).Zone(); o == offset {
+				before = middle
+			} else {
+				after = middle
+			}
+		}
+		// Offsets change on whole seconds
+		after = after.Truncate(time.Second)
+		transitions = append(transitions, icalTransition{at: after, offsetFrom: offset})
+		_, offset = after.In(loc).Zone()
+	}
+
+	icalTransitions.mu.Lock()
+	icalTransitions.years[key] = transitions
+	icalTransitions.mu.Unlock()
+	return transitions
+}
+
+// timeZone - writes the VTIMEZONE that TZID parameters naming `zone.loc`
+// refer to. Rather than as rules, each change of UTC offset between
+// `zone.from` and `zone.to` is written as its own observance.
+func (iw icalWriter) timeZone(zone icalZoneSpan) {
+	iw.line("BEGIN", "VTIMEZONE")
+	iw.line("TZID", zone.loc.String())
+	_, offset := zone.from.In(zone.loc).Zone()
+	iw.observance(zone.loc, zone.from, offset)
+	for year := zone.from.UTC().Year(); year <= zone.to.UTC().Year(); year++ {
+		for _, transition := range zoneTransitions(zone.loc, year) {
+			if transition.at.After(zone.from) && transition.at.Before(zone.to) {
+				iw.observance(zone.loc, transition.at, transition.offsetFrom)
+			}
+		}
+	}
+	iw.line("END", "VTIMEZONE")
+}
+
+// icalZoneSpans - returns the time zones that the recurring events among
+// `events` are anchored in, sorted by name, each spanning their series.
+func icalZoneSpans(events []Event) []icalZoneSpan {
+	spans := map[string]*icalZoneSpan{}
+	for _, event := range events {
+		loc := event.location()
+		if event.Recurrence == nil || loc == time.UTC {
+			continue
+		}
+		from := event.Date.Add(-24 * time.Hour)
+		to := event.Date.AddDate(icalTimeZoneYears, 0, 0)
+		if end := event.seriesEnd(); end != nil {
+			to = *end
+		}
+		span, found := spans[loc.String()]
+		if !found {
+			spans[loc.String()] = &icalZoneSpan{loc: loc, from: from, to: to}
+			continue
+		}
+		if from.Before(span.from) {
+			span.from = from
+		}
+		if to.After(span.to) {
+			span.to = to
+		}
+	}
+
+	var zones []icalZoneSpan
+	for _, span := range spans {
+		zones = append(zones, *span)
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].loc.String() < zones[j].loc.String() })
+	return zones
+}
+
+// writeICalendar - writes `events` to `w` as an iCalendar (RFC 5545)
+// VCALENDAR named `name`, with one VEVENT per event and a VTIMEZONE for
+// each time zone that recurring events are anchored in.
+func (s *server) writeICalendar(w io.Writer, name string, events []Event) error {
+	iw := icalWriter{w: bufio.NewWriter(w)}
+	now := time.Now()
+
+	iw.line("BEGIN", "VCALENDAR")
+	iw.line("VERSION", "2.0")
+	iw.line("PRODID", "-//EventRight//Events//EN")
+	iw.line("CALSCALE", "GREGORIAN")
+	iw.line("METHOD", "PUBLISH")
+	iw.line("X-WR-CALNAME", icalEscape(name))
+	for _, zone := range icalZoneSpans(events) {
+		iw.timeZone(zone)
+	}
+	for _, event := range events {
+		stamp := event.UpdatedAt
+		if stamp.IsZero() {
+			stamp = now
+		}
+
+		iw.line("BEGIN", "VEVENT")
+		iw.line("UID", s.eventUID(event))
+		iw.line("DTSTAMP", icalTime(stamp))
+		iw.line("SEQUENCE", strconv.Itoa(event.Sequence))
+		if event.Recurrence != nil {
+			// Occurrences repeat at the same local time, so the series is
+			// anchored in the event's time zone rather than in UTC
+			loc := event.location()
+			iw.line(icalZonedTime("DTSTART", event.Date, loc))
+			iw.line(icalZonedTime("DTEND", event.End(), loc))
+			iw.line("RRULE", icalRRule(event))
+			if exdates := icalExDates(event); exdates != "" {
+				name, _ := icalZonedTime("EXDATE", event.Date, loc)
+				iw.line(name, exdates)
+			}
+		} else {
+			iw.line("DTSTART", icalTime(event.Date))
+			iw.line("DTEND", icalTime(event.End()))
+		}
+		iw.line("SUMMARY", icalEscape(event.Title))
+		iw.line("LOCATION", icalEscape(event.Location))
+		iw.line("URL", s.baseURL+"/events/"+event.PathID())
+		iw.line("END", "VEVENT")
+	}
+	iw.line("END", "VCALENDAR")
+
+	return iw.w.Flush()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// unfoldICalendar - splits iCalendar content into its unfolded content
+// lines.
+func unfoldICalendar(content string) []string {
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(content, "\r\n ", ""), "\r\n"), "\r\n")
+}
+
+// icalProperty - returns the value of the first content line named `name`
+// in `lines`, and whether there was one.
+func icalProperty(lines []string, name string) (string, bool) {
+	for _, line := range lines {
+		if strings.HasPrefix(line, name+":") {
+			return strings.TrimPrefix(line, name+":"), true
+		}
+	}
+	return "", false
+}
+
+func TestICalEscape(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "Party", want: "Party"},
+		{text: `Evans Hall; Room 1, floor 2 \ back`, want: `Evans Hall\; Room 1\, floor 2 \\ back`},
+		{text: "line one\r\nline two\nline three\rend", want: `line one\nline two\nline three\nend`},
+	}
+	for _, test := range tests {
+		if got := icalEscape(test.text); got != test.want {
+			t.Errorf("icalEscape(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestICalFolding(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "short", value: "Party"},
+		{name: "long", value: strings.Repeat("Party ", 40)},
+		{name: "multi-byte", value: strings.Repeat("ü", 100)},
+		{name: "emoji", value: strings.Repeat("x🎉", 60)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			testServer.writeICalendar(&buf, test.value, nil)
+			for _, line := range strings.Split(buf.String(), "\r\n") {
+				if !utf8.ValidString(line) {
+					t.Errorf("folding split a character: %q", line)
+				}
+				if len(line) > 75 {
+					t.Errorf("line is %d octets, more than 75: %q", len(line), line)
+				}
+			}
+			got, _ := icalProperty(unfoldICalendar(buf.String()), "X-WR-CALNAME")
+			if got != test.value {
+				t.Errorf("unfolded name = %q, want %q", got, test.value)
+			}
+		})
+	}
+}
+
+func TestICalOffset(t *testing.T) {
+	tests := []struct {
+		seconds int
+		want    string
+	}{
+		{seconds: 0, want: "+0000"},
+		{seconds: -5 * 3600, want: "-0500"},
+		{seconds: 5*3600 + 30*60, want: "+0530"},
+		{seconds: -(17*60 + 32), want: "-001732"},
+	}
+	for _, test := range tests {
+		if got := icalOffset(test.seconds); got != test.want {
+			t.Errorf("icalOffset(%d) = %q, want %q", test.seconds, got, test.want)
+		}
+	}
+}
+
+func TestICalTimeZone(t *testing.T) {
+	newYork, _ := loadTimeZone("America/New_York")
+	start := time.Date(2030, 1, 7, 18, 0, 0, 0, newYork)
+	event := Event{Date: start, TimeZone: "America/New_York", Recurrence: &Recurrence{Freq: recurWeekly, Interval: 1, Until: "2030-12-31"}}
+	var buf bytes.Buffer
+	testServer.writeICalendar(&buf, "Series", []Event{event, event})
+	lines := unfoldICalendar(buf.String())
+
+	// One VTIMEZONE for both events, describing 2030: standard time, then
+	// daylight saving time from March 10 and standard time again from
+	// November 3
+	var onsets []string
+	zones := 0
+	for i, line := range lines {
+		switch {
+		case line == "BEGIN:VTIMEZONE":
+			zones++
+		case line == "BEGIN:STANDARD" || line == "BEGIN:DAYLIGHT":
+			onsets = append(onsets, strings.TrimPrefix(line, "BEGIN:")+" "+strings.TrimPrefix(lines[i+1], "DTSTART:"))
+		}
+	}
+	if zones != 1 {
+		t.Errorf("calendar has %d VTIMEZONEs, want 1", zones)
+	}
+	want := []string{"STANDARD 20300106T180000", "DAYLIGHT 20300310T020000", "STANDARD 20301103T020000"}
+	if strings.Join(onsets, ", ") != strings.Join(want, ", ") {
+		t.Errorf("observances = %q, want %q", onsets, want)
+	}
+
+	// Events that do not repeat stay in UTC
+	buf.Reset()
+	testServer.writeICalendar(&buf, "One-off", []Event{{Date: start, TimeZone: "America/New_York"}})
+	if strings.Contains(buf.String(), "VTIMEZONE") || strings.Contains(buf.String(), "TZID") {
+		t.Errorf("calendar of a one-off event has a time zone: %q", buf.String())
+	}
+}
+
+func TestZoneTransitions(t *testing.T) {
+	newYork, _ := loadTimeZone("America/New_York")
+	kolkata, _ := loadTimeZone("Asia/Kolkata")
+	tests := []struct {
+		loc  *time.Location
+		want []icalTransition
+	}{
+		{loc: newYork, want: []icalTransition{
+			{at: time.Date(2030, 3, 10, 7, 0, 0, 0, time.UTC), offsetFrom: -5 * 3600},
+			{at: time.Date(2030, 11, 3, 6, 0, 0, 0, time.UTC), offsetFrom: -4 * 3600},
+		}},
+		{loc: kolkata},
+	}
+	for _, test := range tests {
+		// The second call is answered from the cache
+		for i := 0; i < 2; i++ {
+			if got := zoneTransitions(test.loc, 2030); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("zoneTransitions(%s, 2030) = %v, want %v", test.loc, got, test.want)
+			}
+		}
+	}
+
+	// A series that goes on forever is described for ten years
+	event := Event{Date: time.Date(2030, 1, 7, 18, 0, 0, 0, newYork), TimeZone: "America/New_York", Recurrence: &Recurrence{Freq: recurWeekly, Interval: 1}}
+	var buf bytes.Buffer
+	testServer.writeICalendar(&buf, "Series", []Event{event})
+	if got := strings.Count(buf.String(), "BEGIN:DAYLIGHT"); got != icalTimeZoneYears {
+		t.Errorf("calendar has %d DAYLIGHT observances, want %d", got, icalTimeZoneYears)
+	}
+}
+
+func TestWriteICalendar(t *testing.T) {
+	updated := time.Date(2029, 12, 1, 9, 30, 0, 0, time.UTC)
+	event := Event{
+		ID:        42,
+		PublicID:  "abcdefgh",
+		Title:     "Party, with cake",
+		Location:  "Evans Hall; Room 1",
+		Date:      time.Date(2030, 1, 7, 18, 0, 0, 0, time.UTC),
+		UpdatedAt: updated,
+		Sequence:  3,
+	}
+	var buf bytes.Buffer
+	if err := testServer.writeICalendar(&buf, "Upcoming events", []Event{event}); err != nil {
+		t.Fatalf("writeICalendar: %v", err)
+	}
+	if !strings.HasSuffix(buf.String(), "END:VCALENDAR\r\n") {
+		t.Errorf("calendar does not end with a CRLF-terminated END:VCALENDAR: %q", buf.String())
+	}
+
+	lines := unfoldICalendar(buf.String())
+	want := map[string]string{
+		"BEGIN":    "VCALENDAR",
+		"VERSION":  "2.0",
+		"UID":      "event-abcdefgh@" + testServer.icalDomain,
+		"DTSTAMP":  "20291201T093000Z",
+		"SEQUENCE": "3",
+		"DTSTART":  "20300107T180000Z",
+		"DTEND":    "20300107T200000Z",
+		"SUMMARY":  `Party\, with cake`,
+		"LOCATION": `Evans Hall\; Room 1`,
+		"URL":      testServer.baseURL + "/events/abcdefgh",
+	}
+	for name, value := range want {
+		if got, found := icalProperty(lines, name); got != value {
+			t.Errorf("%s = %q (found %v), want %q", name, got, found, value)
+		}
+	}
+}
+
+func TestEventUID(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{name: "new", event: Event{ID: 7, PublicID: "abcdefgh"}, want: "event-abcdefgh@" + testServer.icalDomain},
+		// Calendars that subscribed before public IDs know it by its number
+		{name: "legacy", event: Event{ID: 7, PublicID: "abcdefgh", LegacyID: 3}, want: "event-3@" + testServer.icalDomain},
+	}
+	for _, test := range tests {
+		if got := testServer.eventUID(test.event); got != test.want {
+			t.Errorf("%s: eventUID = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestICalendarHandlers(t *testing.T) {
+	past := mustAddEvent(t, Event{Title: "Past party", Date: time.Now().AddDate(-1, 0, 0)})
+	upcoming := mustAddEvent(t, Event{Title: "Upcoming party", Date: time.Now().AddDate(1, 0, 0)})
+
+	w := serve(t, http.MethodGet, eventPath(t, upcoming)+".ics", "")
+	expectStatus(t, w, http.StatusOK)
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/calendar") {
+		t.Errorf("Content-Type = %q, want text/calendar", got)
+	}
+	if !strings.Contains(w.Body.String(), "SUMMARY:Upcoming party") {
+		t.Errorf("event file does not describe the event: %s", w.Body.String())
+	}
+	expectStatus(t, serve(t, http.MethodGet, "/events/0.ics", ""), http.StatusNotFound)
+
+	// Calendars only pick up changes to events whose SEQUENCE went up
+	event := mustGetEvent(t, upcoming)
+	event.Title = "Renamed party"
+	if err := testServer.store.UpdateEvent(event); err != nil {
+		t.Fatalf("UpdateEvent: %v", err)
+	}
+	w = serve(t, http.MethodGet, eventPath(t, upcoming)+".ics", "")
+	if sequence, _ := icalProperty(unfoldICalendar(w.Body.String()), "SEQUENCE"); sequence != strconv.Itoa(event.Sequence+1) {
+		t.Errorf("SEQUENCE = %s after an edit, want %d", sequence, event.Sequence+1)
+	}
+
+	w = serve(t, http.MethodGet, "/calendar.ics", "")
+	expectStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), "UID:event-"+event.PublicID+"@") {
+		t.Error("the feed does not include an upcoming event")
+	}
+	if strings.Contains(w.Body.String(), "UID:event-"+mustGetEvent(t, past).PublicID+"@") {
+		t.Error("the feed includes a past event")
+	}
+}
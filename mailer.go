@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mailer - sends plain-text email. The server uses an SMTPMailer when
+// SMTP_ADDR is set and a LogMailer otherwise.
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// SMTPMailer - delivers mail through an SMTP server.
+type SMTPMailer struct {
+	Addr string // host:port of the SMTP server
+	From string
+	Auth smtp.Auth // nil to send without authenticating
+}
+
+// Send - delivers the message to `to` through the SMTP server.
+func (m SMTPMailer) Send(to string, subject string, body string) error {
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{to}, formatMessage(m.From, to, subject, body))
+}
+
+// LogMailer - writes each message to W instead of sending it, so that
+// development servers and tests can read the links that would have been
+// emailed.
+type LogMailer struct {
+	mu sync.Mutex
+	W  io.Writer
+}
+
+// Send - appends the message to the mailer's writer.
+func (m *LogMailer) Send(to string, subject string, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := fmt.Fprintf(m.W, "----- %s -----\n%s\n", time.Now().Format(time.RFC3339), formatMessage("", to, subject, body))
+	return err
+}
+
+// formatMessage - renders the headers and body of a plain-text email.
+func formatMessage(from string, to string, subject string, body string) []byte {
+	var b strings.Builder
+	if from != "" {
+		b.WriteString("From: " + headerValue(from) + "\r\n")
+	}
+	b.WriteString("To: " + headerValue(to) + "\r\n")
+	b.WriteString("Subject: " + headerValue(subject) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// headerValue - replaces line breaks in `value` with spaces, so that text
+// such as an event title cannot end its header and start another.
+func headerValue(value string) string {
+	return strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(value)
+}
+
+// newMailerFromEnv - returns where the server's emails go. It is
+// configured with SMTP_ADDR, SMTP_USERNAME, SMTP_PASSWORD and MAIL_FROM,
+// or, without an SMTP server, MAIL_LOG names a file to write messages to
+// (standard error if unset).
+func newMailerFromEnv() (Mailer, error) {
+	if addr := getEnv("SMTP_ADDR", ""); addr != "" {
+		m := SMTPMailer{
+			Addr: addr,
+			From: getEnv("MAIL_FROM", "eventright@localhost"),
+		}
+		if username := getEnv("SMTP_USERNAME", ""); username != "" {
+			host, _, _ := net.SplitHostPort(addr)
+			m.Auth = smtp.PlainAuth("", username, getEnv("SMTP_PASSWORD", ""), host)
+		}
+		return m, nil
+	}
+
+	if path := getEnv("MAIL_LOG", ""); path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("could not open MAIL_LOG %q: %w", path, err)
+		}
+		return &LogMailer{W: f}, nil
+	}
+	return &LogMailer{W: os.Stderr}, nil
+}
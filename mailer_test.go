@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatMessage(t *testing.T) {
+	message := string(formatMessage("events@yale.edu", "a@yale.edu", "Confirm your RSVP", "Hi,\n\nVisit the link.\n"))
+	want := "From: events@yale.edu\r\nTo: a@yale.edu\r\nSubject: Confirm your RSVP\r\n" +
+		"MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nHi,\r\n\r\nVisit the link.\r\n"
+	if message != want {
+		t.Errorf("formatMessage = %q, want %q", message, want)
+	}
+	if message := string(formatMessage("", "a@yale.edu", "Hi", "")); strings.Contains(message, "From:") {
+		t.Errorf("message without a sender has a From header: %q", message)
+	}
+
+	// A title with line breaks must not add headers of its own
+	message = string(formatMessage("", "a@yale.edu", "RSVP to Party\r\nBcc: all@yale.edu\nX: y", ""))
+	if want := "Subject: RSVP to Party Bcc: all@yale.edu X: y\r\n"; !strings.Contains(message, want) {
+		t.Errorf("formatMessage = %q, want it to contain %q", message, want)
+	}
+}
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := &LogMailer{W: &buf}
+	if err := m.Send("a@yale.edu", "Confirm your RSVP", "Visit http://localhost/verify?token=abc\n"); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	for _, want := range []string{"To: a@yale.edu\r\n", "Subject: Confirm your RSVP\r\n", "http://localhost/verify?token=abc"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log does not contain %q: %q", want, buf.String())
+		}
+	}
+}
+
+func TestRSVPVerification(t *testing.T) {
+	id := mustAddEvent(t, Event{Title: "Verified party", Date: time.Now().AddDate(1, 0, 0), Capacity: 1})
+	path := eventPath(t, id)
+	rsvp := func(email string) {
+		t.Helper()
+		form := url.Values{"eventID": {strconv.Itoa(id)}, "email": {email}, "name": {"Ann"}}
+		expectStatus(t, serve(t, http.MethodPost, path, form.Encode()), http.StatusOK)
+	}
+	verify := func(token string) string {
+		t.Helper()
+		w := serve(t, http.MethodGet, path+"/verify?token="+url.QueryEscape(token), "")
+		expectStatus(t, w, http.StatusOK)
+		return w.Body.String()
+	}
+
+	rsvp("a@yale.edu")
+	event := mustGetEvent(t, id)
+	if !event.isPending("a@yale.edu") || isAttending(event, "a@yale.edu") {
+		t.Fatal("an unverified RSVP counts as attending")
+	}
+	token := mailedToken(t, "a@yale.edu", "/verify")
+
+	if body := verify("not a token"); !strings.Contains(body, "invalid or has expired") {
+		t.Errorf("a wrong token was accepted: %s", body)
+	}
+	if body := verify(token); !strings.Contains(body, testConfirmationCode(t, testServer.store, id, "a@yale.edu")) {
+		t.Errorf("the confirmation code is not shown once the RSVP is verified: %s", body)
+	}
+	event = mustGetEvent(t, id)
+	if event.isPending("a@yale.edu") || !isAttending(event, "a@yale.edu") {
+		t.Error("a verified RSVP does not count as attending")
+	}
+	if body := verify(token); !strings.Contains(body, "invalid or has expired") {
+		t.Errorf("a link was used twice: %s", body)
+	}
+
+	rsvp("b@yale.edu")
+	if body := verify(mailedToken(t, "b@yale.edu", "/verify")); !strings.Contains(body, "#1 on the waitlist") {
+		t.Errorf("verifying a waitlisted RSVP does not give its place: %s", body)
+	}
+}
+
+func TestExpiredRSVPs(t *testing.T) {
+	id := mustAddEvent(t, Event{Title: "Expiring party", Date: time.Now().AddDate(1, 0, 0), Capacity: 1})
+	pending, err := testServer.store.AddRSVP(id, Attendee{Email: "a@yale.edu"}, "", testConfirmationCode(t, testServer.store, id, "a@yale.edu"))
+	if err != nil {
+		t.Fatalf("AddRSVP: %v", err)
+	}
+
+	expired := time.Now().UTC().Add(-pendingRSVPLifetime - time.Hour)
+	if _, err := testDB.Exec("UPDATE Event_Attendee SET CreatedAt = ? WHERE EventID = ?", expired, id); err != nil {
+		t.Fatalf("expiring the RSVP: %v", err)
+	}
+	if _, found, err := testServer.store.VerifyRSVP(id, pending.VerifyToken); err != nil || found {
+		t.Errorf("VerifyRSVP of an expired RSVP = %v, %v", found, err)
+	}
+
+	// The next RSVP drops it
+	if _, err := testServer.store.AddRSVP(id, Attendee{Email: "b@yale.edu"}, "", testConfirmationCode(t, testServer.store, id, "b@yale.edu")); err != nil {
+		t.Fatalf("AddRSVP: %v", err)
+	}
+	if event := mustGetEvent(t, id); event.isPending("a@yale.edu") {
+		t.Error("the expired RSVP is still pending")
+	}
+}
+
+// failingMailer - a Mailer that cannot send anything.
+type failingMailer struct{}
+
+// Send - fails.
+func (failingMailer) Send(to string, subject string, body string) error {
+	return errors.New("mail server unavailable")
+}
+
+// staleStore - a Store whose events never list any RSVPs, as if each was
+// loaded just before another request RSVP-ed.
+type staleStore struct {
+	Store
+}
+
+// GetEvent - returns the event without its RSVPs.
+func (s staleStore) GetEvent(id int) (Event, bool, error) {
+	event, found, err := s.Store.GetEvent(id)
+	event.Attending, event.Pending, event.Waitlist = nil, nil, nil
+	return event, found, err
+}
+
+func TestRSVPMailFailure(t *testing.T) {
+	s := *testServer
+	s.store = staleStore{testServer.store}
+	s.mailer = failingMailer{}
+
+	tests := []struct {
+		name      string
+		rsvp      func(event Event, email string) *httptest.ResponseRecorder
+		wantTaken int
+	}{
+		{
+			name: "form",
+			rsvp: func(event Event, email string) *httptest.ResponseRecorder {
+				form := url.Values{"email": {email}, "name": {"Ann"}}
+				r := httptest.NewRequest(http.MethodPost, "/events/"+event.PathID(), strings.NewReader(form.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				w := httptest.NewRecorder()
+				createRoutes(&s).ServeHTTP(w, r)
+				return w
+			},
+			wantTaken: http.StatusOK,
+		},
+		{
+			name: "API",
+			rsvp: func(event Event, email string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(http.MethodPost, "/api/events/"+event.PublicID+"/rsvp", strings.NewReader(`{"email":"`+email+`","name":"Ann"}`))
+				r.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				createRoutes(&s).ServeHTTP(w, r)
+				return w
+			},
+			wantTaken: http.StatusConflict,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id := mustAddEvent(t, Event{Title: "Unmailable party", Date: time.Now().AddDate(1, 0, 0)})
+			event := mustGetEvent(t, id)
+
+			// The RSVP whose email could not be sent is taken back
+			expectStatus(t, test.rsvp(event, "a@yale.edu"), http.StatusInternalServerError)
+			if mustGetEvent(t, id).isPending("a@yale.edu") {
+				t.Error("the RSVP was kept although its email was not sent")
+			}
+
+			// One made by another request is left alone, and not mailed again
+			if _, err := testServer.store.AddRSVP(id, Attendee{Email: "b@yale.edu"}, "", testConfirmationCode(t, testServer.store, id, "b@yale.edu")); err != nil {
+				t.Fatalf("AddRSVP: %v", err)
+			}
+			w := test.rsvp(event, "b@yale.edu")
+			expectStatus(t, w, test.wantTaken)
+			if !strings.Contains(w.Body.String(), "already RSVP-ed") {
+				t.Errorf("body does not say the email is taken: %s", w.Body.String())
+			}
+			if !mustGetEvent(t, id).isPending("b@yale.edu") {
+				t.Error("another request's RSVP was cancelled")
+			}
+		})
+	}
+}
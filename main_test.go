@@ -0,0 +1,184 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer is the server the tests send their requests to. Its store
+// is a fresh database in a temporary directory, so the tests do not change
+// ./events.db.
+var testServer *server
+
+// testDB is the database behind testServer.
+var testDB *sql.DB
+
+// testDefaults are testServer's defaults for events that do not set their
+// own time zone or email policy.
+var testDefaults = eventDefaults{
+	TimeZone:     "America/New_York",
+	EmailPolicy:  emailPolicyAllowlist,
+	EmailDomains: []string{"yale.edu"},
+}
+
+// TestMain - sets up testServer and keeps the emails the tests send.
+func TestMain(m *testing.M) {
+	templates, err := loadTemplates("templates", testDefaults)
+	if err != nil {
+		panic(err)
+	}
+
+	dir, err := os.MkdirTemp("", "events-test")
+	if err != nil {
+		panic(err)
+	}
+	testDB, err = initDB(filepath.Join(dir, "events.db"), testDefaults.TimeZone)
+	if err != nil {
+		panic(err)
+	}
+	testServer = &server{
+		store:           &sqliteStore{db: testDB},
+		mailer:          sentMail,
+		templates:       templates,
+		baseURL:         "http://localhost:8080",
+		icalDomain:      "localhost",
+		confirmationKey: []byte("test secret"),
+		defaults:        testDefaults,
+	}
+
+	code := m.Run()
+	testDB.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// serve - sends a request with `body` through the routes and returns the
+// recorded response.
+func serve(t *testing.T, method string, path string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serveOrganizer(t, method, path, body, "")
+}
+
+// serveOrganizer - like serve, but sends `token` as the organizer token.
+func serveOrganizer(t *testing.T, method string, path string, body string, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("X-Organizer-Token", token)
+	}
+	if strings.HasPrefix(path, "/api/") {
+		r.Header.Set("Content-Type", "application/json")
+	} else if body != "" {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	w := httptest.NewRecorder()
+	createRoutes(testServer).ServeHTTP(w, r)
+	return w
+}
+
+// futureDate - returns a date a year from now as the forms and the API
+// take it.
+func futureDate() string {
+	return time.Now().AddDate(1, 0, 0).Format("2006-01-02T15:04")
+}
+
+// expectStatus - fails the test unless `w` has the status `want`.
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("status = %d %s, want %d; body: %s", w.Code, http.StatusText(w.Code), want, w.Body.String())
+	}
+}
+
+// sentMail keeps the emails sent during the tests.
+var sentMail = &testMailer{}
+
+// testMailer - a Mailer that keeps each message instead of sending it.
+type testMailer struct {
+	mu       sync.Mutex
+	messages []testMessage
+}
+
+type testMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Send - keeps the message.
+func (m *testMailer) Send(to string, subject string, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, testMessage{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// mailedToken - returns the token of the last link containing `path` that
+// was emailed to `to`.
+func mailedToken(t *testing.T, to string, path string) string {
+	t.Helper()
+	sentMail.mu.Lock()
+	defer sentMail.mu.Unlock()
+	for i := len(sentMail.messages) - 1; i >= 0; i-- {
+		message := sentMail.messages[i]
+		if message.To != to {
+			continue
+		}
+		for _, word := range strings.Fields(message.Body) {
+			link, err := url.Parse(word)
+			if err == nil && strings.HasSuffix(link.Path, path) {
+				return link.Query().Get("token")
+			}
+		}
+	}
+	t.Fatalf("no link to %s was emailed to %s", path, to)
+	return ""
+}
+
+// mustAddEvent - adds `event` and returns its ID, failing the test if it
+// cannot be saved.
+func mustAddEvent(t *testing.T, event Event) int {
+	t.Helper()
+	id, err := testServer.store.CreateEvent(event)
+	if err != nil {
+		t.Fatalf("CreateEvent: %v", err)
+	}
+	return id
+}
+
+// eventPath - returns the path of the page of the event with the
+// specified id.
+func eventPath(t *testing.T, id int) string {
+	t.Helper()
+	return "/events/" + mustGetEvent(t, id).PublicID
+}
+
+// mustGetEvent - returns the event with the specified id, failing the test
+// if it cannot be loaded or does not exist.
+func mustGetEvent(t *testing.T, id int) Event {
+	t.Helper()
+	event, found, err := testServer.store.GetEvent(id)
+	if err != nil || !found {
+		t.Fatalf("testServer.store.GetEvent(%d) = %v, %v", id, found, err)
+	}
+	return event
+}
+
+// testConfirmationCode - returns the confirmation code the test server
+// issues to `email` for the event with the specified id in `store`.
+func testConfirmationCode(t *testing.T, store Store, id int, email string) string {
+	t.Helper()
+	event, found, err := store.GetEvent(id)
+	if err != nil || !found {
+		t.Fatalf("GetEvent(%d) = %v, %v", id, found, err)
+	}
+	return testServer.confirmationCode(event.PublicID, email)
+}
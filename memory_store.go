@@ -0,0 +1,778 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStore - a Store that keeps everything in memory, so it is lost
+// when the server stops. It behaves like sqliteStore, which makes it handy
+// for tests and demos that should not touch ./events.db.
+type memoryStore struct {
+	mu        sync.Mutex
+	events    map[int]*memoryEvent
+	publicIDs map[string]int // event IDs by public ID
+	slugs     map[string]int // event IDs by slug
+	users     map[int]User
+	sessions  map[string]memorySession // by token hash
+	// emailVerifyTokens maps the hash of each user's email verification
+	// token to their ID.
+	emailVerifyTokens map[string]int
+	nextUserID        int
+}
+
+// memoryEvent - a stored event and its RSVPs. `event` holds only the
+// fields sqliteStore keeps in the Event table.
+type memoryEvent struct {
+	event     Event
+	attending []*memoryRSVP // in the order they got a spot
+	waitlist  []*memoryRSVP // first in line first
+}
+
+type memoryRSVP struct {
+	email            string
+	displayName      string
+	affiliation      string
+	occurrence       string
+	confirmationCode string
+	confirmed        bool
+	verifyTokenHash  string
+	createdAt        time.Time
+}
+
+// attendee - returns who made the RSVP.
+func (rsvp *memoryRSVP) attendee() Attendee {
+	return Attendee{Email: rsvp.email, DisplayName: rsvp.displayName, Affiliation: rsvp.affiliation}
+}
+
+type memorySession struct {
+	userID  int
+	expires time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		events:    map[int]*memoryEvent{},
+		publicIDs: map[string]int{},
+		slugs:     map[string]int{},
+		users:     map[int]User{},
+		sessions:  map[string]memorySession{},
+
+		emailVerifyTokens: map[string]int{},
+	}
+}
+
+// storedEvent - returns a copy of the fields of `event` that are stored,
+// sharing no memory with it, and with its times in UTC.
+func storedEvent(event Event) Event {
+	stored := Event{
+		ID:                 event.ID,
+		PublicID:           event.PublicID,
+		LegacyID:           event.LegacyID,
+		Slug:               event.Slug,
+		Title:              event.Title,
+		Location:           event.Location,
+		Image:              event.Image,
+		Date:               event.Date.UTC(),
+		EndDate:            utcTime(event.EndDate),
+		TimeZone:           event.TimeZone,
+		RSVPMessage:        event.RSVPMessage,
+		OrganizerTokenHash: event.OrganizerTokenHash,
+		OwnerID:            event.OwnerID,
+		EmailPolicy:        event.EmailPolicy,
+		EmailDomains:       append([]string(nil), event.EmailDomains...),
+		Capacity:           event.Capacity,
+		AttendeeVisibility: event.AttendeeVisibility,
+		Sequence:           event.Sequence,
+		UpdatedAt:          event.UpdatedAt.UTC(),
+	}
+	if event.Recurrence != nil {
+		recurrence := *event.Recurrence
+		recurrence.Exceptions = append([]string(nil), recurrence.Exceptions...)
+		stored.Recurrence = &recurrence
+	}
+	return stored
+}
+
+// EventIDByPublicID - returns the ID of the event whose public ID is
+// `publicID`.
+func (m *memoryStore) EventIDByPublicID(publicID string) (int, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id, found := m.publicIDs[publicID]
+	return id, found, nil
+}
+
+// EventIDBySlug - returns the ID of the event whose slug is `slug`.
+func (m *memoryStore) EventIDBySlug(slug string) (int, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id, found := m.slugs[slug]
+	return id, found, nil
+}
+
+// LegacyEventPathID - always returns false, since every event in memory
+// was created with a public ID.
+func (m *memoryStore) LegacyEventPathID(id int) (string, bool, error) {
+	return "", false, nil
+}
+
+// GetEvent - returns the event with the specified id, with its RSVPs.
+func (m *memoryStore) GetEvent(id int) (Event, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	me := m.events[id]
+	if me == nil {
+		return Event{}, false, nil
+	}
+	event := storedEvent(me.event)
+	event.localizeTimes()
+	for _, rsvp := range me.attending {
+		switch {
+		case rsvp.occurrence != "" && rsvp.confirmed:
+			rsvps := event.occurrenceRSVPsFor(rsvp.occurrence)
+			rsvps.Attending = append(rsvps.Attending, rsvp.attendee())
+		case rsvp.occurrence != "":
+			rsvps := event.occurrenceRSVPsFor(rsvp.occurrence)
+			rsvps.Pending = append(rsvps.Pending, rsvp.email)
+		case rsvp.confirmed:
+			event.Attending = append(event.Attending, rsvp.attendee())
+		default:
+			event.Pending = append(event.Pending, rsvp.email)
+		}
+	}
+	for _, rsvp := range me.waitlist {
+		if rsvp.occurrence != "" {
+			rsvps := event.occurrenceRSVPsFor(rsvp.occurrence)
+			rsvps.Waitlist = append(rsvps.Waitlist, rsvp.email)
+		} else {
+			event.Waitlist = append(event.Waitlist, rsvp.email)
+		}
+	}
+	return event, true, nil
+}
+
+// ListEvents - returns a page of the events matching `q`, in the same
+// order as sqliteStore.
+func (m *memoryStore) ListEvents(q eventQuery) ([]Event, *eventCursor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	search := strings.ToLower(q.Search)
+	var events []Event
+	for _, me := range m.events {
+		stored := me.event
+		if !q.From.IsZero() {
+			// Occurrences are stepped in the event's time zone, as when
+			// sqliteStore saves SeriesEnd
+			local := stored
+			local.localizeTimes()
+			if end := local.seriesEnd(); end != nil && !end.After(q.From) {
+				continue
+			}
+		}
+		if !q.To.IsZero() && !stored.Date.Before(q.To) {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(stored.Title), search) && !strings.Contains(strings.ToLower(stored.Location), search) {
+			continue
+		}
+		if q.After != nil && !eventAfter(q.Sort, stored, *q.After) {
+			continue
+		}
+
+		// Only the columns sqliteStore lists events with
+		event := Event{
+			ID:                 stored.ID,
+			PublicID:           stored.PublicID,
+			LegacyID:           stored.LegacyID,
+			Slug:               stored.Slug,
+			Title:              stored.Title,
+			Location:           stored.Location,
+			Image:              stored.Image,
+			Date:               stored.Date,
+			EndDate:            utcTime(stored.EndDate),
+			TimeZone:           stored.TimeZone,
+			RSVPMessage:        stored.RSVPMessage,
+			AttendeeVisibility: stored.AttendeeVisibility,
+			Sequence:           stored.Sequence,
+			UpdatedAt:          stored.UpdatedAt,
+			Recurrence:         storedEvent(stored).Recurrence,
+		}
+		if q.Attendees {
+			for _, rsvp := range me.attending {
+				if !rsvp.confirmed {
+					continue
+				}
+				if rsvp.occurrence != "" {
+					rsvps := event.occurrenceRSVPsFor(rsvp.occurrence)
+					rsvps.Attending = append(rsvps.Attending, rsvp.attendee())
+				} else {
+					event.Attending = append(event.Attending, rsvp.attendee())
+				}
+			}
+		}
+		events = append(events, event)
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return eventAfter(q.Sort, events[j], eventCursor{Date: events[i].Date, Title: events[i].Title, ID: events[i].ID})
+	})
+	if q.Limit > 0 && len(events) > q.Limit+1 {
+		events = events[:q.Limit+1]
+	}
+	for i := range events {
+		events[i].localizeTimes()
+	}
+	events, next := pageEvents(q, events)
+	return events, next, nil
+}
+
+// eventAfter - reports whether `event` comes after the event marked by
+// `cursor` when sorted by `sort`.
+func eventAfter(sort string, event Event, cursor eventCursor) bool {
+	switch sort {
+	case eventSortDateDesc:
+		if !event.Date.Equal(cursor.Date) {
+			return event.Date.Before(cursor.Date)
+		}
+		return event.ID < cursor.ID
+	case eventSortTitle:
+		title, after := strings.ToLower(event.Title), strings.ToLower(cursor.Title)
+		if title != after {
+			return title > after
+		}
+		return event.ID > cursor.ID
+	default:
+		if !event.Date.Equal(cursor.Date) {
+			return event.Date.After(cursor.Date)
+		}
+		return event.ID > cursor.ID
+	}
+}
+
+// EventsByOwner - returns the events created by the user with the
+// specified id, soonest first.
+func (m *memoryStore) EventsByOwner(userID int) ([]Event, error) {
+	return m.eventSummaries(func(me *memoryEvent) bool {
+		return me.event.OwnerID == userID
+	}), nil
+}
+
+// EventsAttendedBy - returns the events that `email` has a confirmed RSVP
+// to, soonest first.
+func (m *memoryStore) EventsAttendedBy(email string) ([]Event, error) {
+	return m.eventSummaries(func(me *memoryEvent) bool {
+		for _, rsvp := range me.attending {
+			if rsvp.confirmed && strings.EqualFold(rsvp.email, email) {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// eventSummaries - returns the ID, title, location, image and date of the
+// events `keep` is true for, soonest first.
+func (m *memoryStore) eventSummaries(keep func(me *memoryEvent) bool) []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []Event
+	for _, me := range m.events {
+		if !keep(me) {
+			continue
+		}
+		event := Event{
+			ID:       me.event.ID,
+			PublicID: me.event.PublicID,
+			Slug:     me.event.Slug,
+			Title:    me.event.Title,
+			Location: me.event.Location,
+			Image:    me.event.Image,
+			Date:     me.event.Date,
+			TimeZone: me.event.TimeZone,
+		}
+		event.localizeTimes()
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Date.Before(events[j].Date) })
+	return events
+}
+
+// CreateEvent - adds `event` and returns its ID. Its Attending list is
+// added as verified RSVPs.
+func (m *memoryStore) CreateEvent(event Event) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if event.ID == 0 {
+		for id := range m.events {
+			if id > event.ID {
+				event.ID = id
+			}
+		}
+		event.ID++
+	}
+	if m.events[event.ID] != nil {
+		return 0, fmt.Errorf("event %d already exists", event.ID)
+	}
+	if _, taken := m.publicIDs[event.PublicID]; taken {
+		return 0, fmt.Errorf("public ID %q already exists", event.PublicID)
+	}
+	if _, taken := m.slugs[event.Slug]; taken {
+		return 0, fmt.Errorf("slug %q already exists", event.Slug)
+	}
+	for event.PublicID == "" {
+		if publicID := newPublicID(); m.publicIDs[publicID] == 0 {
+			event.PublicID = publicID
+		}
+	}
+	if event.Slug == "" {
+		event.Slug, _ = uniqueSlug(event.Title, func(slug string) (bool, error) {
+			_, taken := m.slugs[slug]
+			_, isPublicID := m.publicIDs[slug]
+			return taken || isPublicID, nil
+		})
+	}
+	event.Sequence = 0
+	event.UpdatedAt = time.Now()
+	me := &memoryEvent{event: storedEvent(event)}
+	m.events[event.ID] = me
+	m.publicIDs[event.PublicID] = event.ID
+	m.slugs[event.Slug] = event.ID
+
+	for _, attendee := range event.Attending {
+		rsvp := m.addRSVP(me, attendee, "", "")
+		m.verifyRSVP(me, rsvp.VerifyToken)
+	}
+	return event.ID, nil
+}
+
+// UpdateEvent - overwrites the editable fields of the event with the same
+// ID as `event` and gives any new spots to the waitlist.
+func (m *memoryStore) UpdateEvent(event Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	me := m.events[event.ID]
+	if me == nil {
+		return errEventNotFound
+	}
+	updated := storedEvent(event)
+	stored := &me.event
+	stored.Title = updated.Title
+	stored.Location = updated.Location
+	stored.Image = updated.Image
+	stored.Date = updated.Date
+	stored.EndDate = updated.EndDate
+	stored.TimeZone = updated.TimeZone
+	stored.EmailPolicy = updated.EmailPolicy
+	stored.EmailDomains = updated.EmailDomains
+	stored.Capacity = updated.Capacity
+	stored.AttendeeVisibility = updated.AttendeeVisibility
+	stored.Recurrence = updated.Recurrence
+	stored.Sequence++
+	stored.UpdatedAt = time.Now().UTC()
+
+	me.promoteFromWaitlist()
+	return nil
+}
+
+// DeleteEvent - removes the event with the specified id and its RSVPs.
+func (m *memoryStore) DeleteEvent(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	me := m.events[id]
+	if me == nil {
+		return errEventNotFound
+	}
+	delete(m.publicIDs, me.event.PublicID)
+	delete(m.slugs, me.event.Slug)
+	delete(m.events, id)
+	return nil
+}
+
+// AddRSVP - adds a pending RSVP of `attendee` to the event or its
+// occurrence, or to the waitlist if it is full, issuing them the
+// confirmation code `code`.
+func (m *memoryStore) AddRSVP(eventID int, attendee Attendee, occurrence string, code string) (RSVP, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	me := m.events[eventID]
+	if me == nil {
+		return RSVP{}, errEventNotFound
+	}
+	return m.addRSVP(me, attendee, occurrence, code), nil
+}
+
+func (m *memoryStore) addRSVP(me *memoryEvent, attendee Attendee, occurrence string, code string) RSVP {
+	me.purgeExpiredRSVPs()
+	if rsvp, found := me.rsvp(attendee.Email, occurrence); found {
+		return rsvp
+	}
+
+	verifyToken := newVerifyToken()
+	added := &memoryRSVP{
+		email:            attendee.Email,
+		displayName:      attendee.DisplayName,
+		affiliation:      attendee.Affiliation,
+		occurrence:       occurrence,
+		confirmationCode: code,
+		verifyTokenHash:  hashVerifyToken(verifyToken),
+		createdAt:        time.Now(),
+	}
+	if me.event.Capacity > 0 && me.countRSVPs(occurrence) >= me.event.Capacity {
+		me.waitlist = append(me.waitlist, added)
+	} else {
+		me.attending = append(me.attending, added)
+	}
+
+	rsvp, _ := me.rsvp(attendee.Email, occurrence)
+	rsvp.VerifyToken = verifyToken
+	return rsvp
+}
+
+// VerifyRSVP - confirms the pending RSVP whose verification token is
+// `token`.
+func (m *memoryStore) VerifyRSVP(eventID int, token string) (RSVP, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	me := m.events[eventID]
+	if me == nil {
+		return RSVP{}, false, nil
+	}
+	rsvp, found := m.verifyRSVP(me, token)
+	return rsvp, found, nil
+}
+
+func (m *memoryStore) verifyRSVP(me *memoryEvent, token string) (RSVP, bool) {
+	me.purgeExpiredRSVPs()
+	hash := hashVerifyToken(token)
+	for i, rsvp := range me.attending {
+		if rsvp.confirmed || rsvp.verifyTokenHash != hash {
+			continue
+		}
+		// Spots go to RSVPs in the order they are verified, so one that
+		// was given a spot when it was made may find the event full by now
+		if me.event.Capacity > 0 && me.countRSVPs(rsvp.occurrence) >= me.event.Capacity {
+			me.attending = append(me.attending[:i:i], me.attending[i+1:]...)
+			me.waitlist = append(me.waitlist, rsvp)
+		}
+		rsvp.confirmed = true
+		rsvp.verifyTokenHash = ""
+		return me.rsvp(rsvp.email, rsvp.occurrence)
+	}
+	for _, rsvp := range me.waitlist {
+		if !rsvp.confirmed && rsvp.verifyTokenHash == hash {
+			rsvp.confirmed = true
+			rsvp.verifyTokenHash = ""
+			me.promoteFromWaitlist()
+			return me.rsvp(rsvp.email, rsvp.occurrence)
+		}
+	}
+	return RSVP{}, false
+}
+
+// CancelRSVP - removes the RSVP of `email` to the event or occurrence, or
+// takes them off the waitlist.
+func (m *memoryStore) CancelRSVP(eventID int, email string, occurrence string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	me := m.events[eventID]
+	if me == nil {
+		return false, nil
+	}
+	matches := func(rsvp *memoryRSVP) bool {
+		return rsvp.occurrence == occurrence && strings.EqualFold(rsvp.email, email)
+	}
+	var removed bool
+	if me.attending, removed = removeRSVPs(me.attending, matches); removed {
+		me.promoteFromWaitlist()
+		return true, nil
+	}
+	me.waitlist, removed = removeRSVPs(me.waitlist, matches)
+	return removed, nil
+}
+
+// ConfirmationCode - returns the confirmation code of the RSVP of `email`
+// to the event.
+func (m *memoryStore) ConfirmationCode(eventID int, email string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	me := m.events[eventID]
+	if me == nil {
+		return "", false, nil
+	}
+	for _, list := range [][]*memoryRSVP{me.attending, me.waitlist} {
+		for _, rsvp := range list {
+			if strings.EqualFold(rsvp.email, email) {
+				return rsvp.confirmationCode, rsvp.confirmationCode != "", nil
+			}
+		}
+	}
+	return "", false, nil
+}
+
+// EachAttendeeRecord - calls `fn` with each RSVP to the event that is
+// attending or still waiting to be verified, in the order they were made,
+// and then with each person on its waitlist, first in line first. Pending
+// RSVPs that have expired are skipped.
+func (m *memoryStore) EachAttendeeRecord(eventID int, fn func(AttendeeRecord) error) error {
+	m.mu.Lock()
+	me := m.events[eventID]
+	var records, waiting []AttendeeRecord
+	if me != nil {
+		cutoff := time.Now().Add(-pendingRSVPLifetime)
+		for _, rsvp := range me.attending {
+			if rsvp.confirmed || !rsvp.createdAt.Before(cutoff) {
+				records = append(records, rsvp.record())
+			}
+		}
+		positions := map[string]int{}
+		for _, rsvp := range me.waitlist {
+			if rsvp.confirmed || !rsvp.createdAt.Before(cutoff) {
+				positions[rsvp.occurrence]++
+				record := rsvp.record()
+				record.Status = rsvpStatusWaitlisted
+				record.WaitlistPosition = positions[rsvp.occurrence]
+				waiting = append(waiting, record)
+			}
+		}
+	}
+	// `fn` may be slow, so it is called without holding the lock
+	m.mu.Unlock()
+
+	// People promoted from the waitlist come after those who got a spot
+	// straight away, so sort them back into the order they RSVP-ed in
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := records[i].RSVPAt, records[j].RSVPAt
+		return b != nil && (a == nil || a.Before(*b))
+	})
+
+	for _, record := range append(records, waiting...) {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record - returns the RSVP as exported to the organizer, as attending or
+// pending depending on whether it was verified.
+func (rsvp *memoryRSVP) record() AttendeeRecord {
+	record := AttendeeRecord{
+		Name:             rsvp.displayName,
+		Affiliation:      rsvp.affiliation,
+		Email:            rsvp.email,
+		Status:           rsvpStatusPending,
+		Occurrence:       rsvp.occurrence,
+		ConfirmationCode: rsvp.confirmationCode,
+	}
+	if !rsvp.createdAt.IsZero() {
+		createdAt := rsvp.createdAt
+		record.RSVPAt = &createdAt
+	}
+	if rsvp.confirmed {
+		record.Status = rsvpStatusAttending
+	}
+	return record
+}
+
+// rsvp - looks up the RSVP of `email` to the event or its occurrence,
+// whether they are attending or waitlisted.
+func (me *memoryEvent) rsvp(email string, occurrence string) (RSVP, bool) {
+	for _, rsvp := range me.attending {
+		if rsvp.occurrence == occurrence && strings.EqualFold(rsvp.email, email) {
+			return RSVP{ConfirmationCode: rsvp.confirmationCode, Confirmed: rsvp.confirmed, Occurrence: occurrence}, true
+		}
+	}
+	position := 0
+	for _, rsvp := range me.waitlist {
+		if rsvp.occurrence != occurrence {
+			continue
+		}
+		position++
+		if strings.EqualFold(rsvp.email, email) {
+			return RSVP{ConfirmationCode: rsvp.confirmationCode, Confirmed: rsvp.confirmed, Occurrence: occurrence, WaitlistPosition: position}, true
+		}
+	}
+	return RSVP{}, false
+}
+
+// purgeExpiredRSVPs - drops the RSVPs that were not verified within
+// pendingRSVPLifetime. They never took a spot, so none is freed.
+func (me *memoryEvent) purgeExpiredRSVPs() {
+	cutoff := time.Now().Add(-pendingRSVPLifetime)
+	expired := func(rsvp *memoryRSVP) bool {
+		return !rsvp.confirmed && rsvp.createdAt.Before(cutoff)
+	}
+	me.attending, _ = removeRSVPs(me.attending, expired)
+	me.waitlist, _ = removeRSVPs(me.waitlist, expired)
+}
+
+// promoteFromWaitlist - moves verified people from the waitlist onto the
+// attendee list, in the order they joined, as long as the occurrence they
+// are waiting for has spots left. Pending people stay in line until they
+// are verified.
+func (me *memoryEvent) promoteFromWaitlist() {
+	var waiting []*memoryRSVP
+	for _, rsvp := range me.waitlist {
+		if !rsvp.confirmed || me.event.Capacity > 0 && me.countRSVPs(rsvp.occurrence) >= me.event.Capacity {
+			waiting = append(waiting, rsvp)
+			continue
+		}
+		me.attending = append(me.attending, rsvp)
+	}
+	me.waitlist = waiting
+}
+
+// countRSVPs - returns how many spots are taken at the occurrence with key
+// `occurrence`, or, if it is empty, at the busiest occurrence. Only
+// verified RSVPs take a spot.
+func (me *memoryEvent) countRSVPs(occurrence string) int {
+	series := 0
+	taken := map[string]int{}
+	for _, rsvp := range me.attending {
+		if !rsvp.confirmed {
+			continue
+		}
+		if rsvp.occurrence == "" {
+			series++
+		} else {
+			taken[rsvp.occurrence]++
+		}
+	}
+	if occurrence != "" {
+		return series + taken[occurrence]
+	}
+	busiest := 0
+	for _, n := range taken {
+		if n > busiest {
+			busiest = n
+		}
+	}
+	return series + busiest
+}
+
+// removeRSVPs - returns `rsvps` without those `remove` is true for, and
+// whether there were any.
+func removeRSVPs(rsvps []*memoryRSVP, remove func(rsvp *memoryRSVP) bool) ([]*memoryRSVP, bool) {
+	var kept []*memoryRSVP
+	for _, rsvp := range rsvps {
+		if !remove(rsvp) {
+			kept = append(kept, rsvp)
+		}
+	}
+	return kept, len(kept) < len(rsvps)
+}
+
+// CreateUser - adds an account and returns it. Returns errEmailTaken if
+// the email is already registered.
+func (m *memoryStore) CreateUser(email string, passwordHash string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	email = strings.ToLower(email)
+	for _, user := range m.users {
+		if user.Email == email {
+			return User{}, errEmailTaken
+		}
+	}
+	m.nextUserID++
+	user := User{ID: m.nextUserID, Email: email, PasswordHash: passwordHash, CreatedAt: time.Now()}
+	m.users[user.ID] = user
+	return user, nil
+}
+
+// UserByEmail - returns the account registered with `email`.
+func (m *memoryStore) UserByEmail(email string) (User, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.Email == strings.ToLower(email) {
+			return user, true, nil
+		}
+	}
+	return User{}, false, nil
+}
+
+// CreateSession - starts a session for the user with the specified id.
+func (m *memoryStore) CreateSession(tokenHash string, userID int, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[tokenHash] = memorySession{userID: userID, expires: expires}
+	return nil
+}
+
+// SessionUser - returns the user whose unexpired session has the token
+// hash `tokenHash`.
+func (m *memoryStore) SessionUser(tokenHash string) (User, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, found := m.sessions[tokenHash]
+	if !found {
+		return User{}, false, nil
+	}
+	if time.Now().After(session.expires) {
+		delete(m.sessions, tokenHash)
+		return User{}, false, nil
+	}
+	user, found := m.users[session.userID]
+	return user, found, nil
+}
+
+// DeleteSession - ends the session with the token hash `tokenHash`.
+func (m *memoryStore) DeleteSession(tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, tokenHash)
+	return nil
+}
+
+// SetEmailVerifyToken - replaces the email verification token of the user
+// with the specified id.
+func (m *memoryStore) SetEmailVerifyToken(userID int, tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, id := range m.emailVerifyTokens {
+		if id == userID {
+			delete(m.emailVerifyTokens, hash)
+		}
+	}
+	m.emailVerifyTokens[tokenHash] = userID
+	return nil
+}
+
+// VerifyUserEmail - marks the email of the user whose verification token
+// has the hash `tokenHash` as verified.
+func (m *memoryStore) VerifyUserEmail(tokenHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userID, found := m.emailVerifyTokens[tokenHash]
+	if !found {
+		return false, nil
+	}
+	delete(m.emailVerifyTokens, tokenHash)
+	user := m.users[userID]
+	user.EmailVerified = true
+	m.users[userID] = user
+	return true, nil
+}
@@ -0,0 +1,551 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migration - one versioned change to the database schema. Up applies it
+// and Down undoes it; each runs in its own transaction together with the
+// update to schema_migrations.
+type migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+	Down    func(tx *sql.Tx) error
+}
+
+// schemaMigrations - returns the migrations, which are applied in order.
+// Never change one that has been released; add a new one instead.
+// `timeZone` is the default time zone, which events from before time zones
+// were stored are given.
+//
+// Versions 1 to 12 recreate the schema that older versions of the server
+// built with CREATE TABLE IF NOT EXISTS and added columns to on startup.
+// They check what is already there, so databases from before migrations
+// were tracked are brought up to date by running them all.
+func schemaMigrations(timeZone string) []migration {
+	return []migration{
+		{
+			Version: 1,
+			Name:    "create events and attendees",
+			Up: execSQL(`
+            CREATE TABLE IF NOT EXISTS Event (
+                ID INTEGER PRIMARY KEY,
+                Title TEXT NOT NULL,
+                Location TEXT,
+                Image TEXT,
+                Date DATETIME,
+                RSVPMessage TEXT
+            );
+
+            CREATE TABLE IF NOT EXISTS Attendee (
+                ID INTEGER PRIMARY KEY AUTOINCREMENT,
+                Name TEXT NOT NULL
+            );
+
+            CREATE TABLE IF NOT EXISTS Event_Attendee (
+                EventID INTEGER,
+                AttendeeID INTEGER,
+                PRIMARY KEY (EventID, AttendeeID),
+                FOREIGN KEY (EventID) REFERENCES Event(ID) ON DELETE CASCADE,
+                FOREIGN KEY (AttendeeID) REFERENCES Attendee(ID) ON DELETE CASCADE
+            );`),
+			Down: execSQL(`
+            DROP TABLE Event_Attendee;
+            DROP TABLE Attendee;
+            DROP TABLE Event;`),
+		},
+		{
+			Version: 2,
+			Name:    "add RSVP confirmation codes",
+			Up:      addColumns("Event_Attendee", "ConfirmationCode TEXT"),
+			Down:    dropColumns("Event_Attendee", "ConfirmationCode"),
+		},
+		{
+			Version: 3,
+			Name:    "add organizer tokens",
+			Up:      addColumns("Event", "OrganizerTokenHash TEXT"),
+			Down:    dropColumns("Event", "OrganizerTokenHash"),
+		},
+		{
+			Version: 4,
+			Name:    "add user accounts",
+			Up: inOrder(
+				execSQL(`
+                CREATE TABLE IF NOT EXISTS User (
+                    ID INTEGER PRIMARY KEY AUTOINCREMENT,
+                    Email TEXT NOT NULL UNIQUE,
+                    PasswordHash TEXT NOT NULL,
+                    CreatedAt DATETIME,
+                    EmailVerified INTEGER NOT NULL DEFAULT 0,
+                    VerifyTokenHash TEXT
+                );
+
+                CREATE TABLE IF NOT EXISTS Session (
+                    TokenHash TEXT PRIMARY KEY,
+                    UserID INTEGER NOT NULL,
+                    ExpiresAt DATETIME NOT NULL,
+                    FOREIGN KEY (UserID) REFERENCES User(ID) ON DELETE CASCADE
+                );`),
+				addColumns("Event", "OwnerID INTEGER REFERENCES User(ID) ON DELETE SET NULL"),
+			),
+			Down: inOrder(
+				dropColumns("Event", "OwnerID"),
+				execSQL(`
+                DROP TABLE Session;
+                DROP TABLE User;`),
+			),
+		},
+		{
+			Version: 5,
+			Name:    "add email policies",
+			Up:      addColumns("Event", "EmailPolicy TEXT", "EmailDomains TEXT"),
+			Down:    dropColumns("Event", "EmailPolicy", "EmailDomains"),
+		},
+		{
+			Version: 6,
+			Name:    "add capacity and waitlist",
+			Up: inOrder(
+				addColumns("Event", "Capacity INTEGER"),
+				execSQL(`
+                CREATE TABLE IF NOT EXISTS Waitlist (
+                    ID INTEGER PRIMARY KEY AUTOINCREMENT,
+                    EventID INTEGER NOT NULL,
+                    AttendeeID INTEGER NOT NULL,
+                    ConfirmationCode TEXT,
+                    UNIQUE (EventID, AttendeeID),
+                    FOREIGN KEY (EventID) REFERENCES Event(ID) ON DELETE CASCADE,
+                    FOREIGN KEY (AttendeeID) REFERENCES Attendee(ID) ON DELETE CASCADE
+                );`),
+			),
+			Down: inOrder(
+				execSQL("DROP TABLE Waitlist"),
+				dropColumns("Event", "Capacity"),
+			),
+		},
+		{
+			Version: 7,
+			Name:    "add RSVP email verification",
+			// RSVPs made before verification existed count as verified
+			Up: inOrder(
+				addColumns("Event_Attendee", "Confirmed INTEGER NOT NULL DEFAULT 1", "VerifyToken TEXT", "CreatedAt DATETIME"),
+				addColumns("Waitlist", "Confirmed INTEGER NOT NULL DEFAULT 1", "VerifyToken TEXT", "CreatedAt DATETIME"),
+			),
+			Down: inOrder(
+				execSQL(`
+                DELETE FROM Event_Attendee WHERE Confirmed = 0;
+                DELETE FROM Waitlist WHERE Confirmed = 0;`),
+				dropColumns("Event_Attendee", "Confirmed", "VerifyToken", "CreatedAt"),
+				dropColumns("Waitlist", "Confirmed", "VerifyToken", "CreatedAt"),
+			),
+		},
+		{
+			Version: 8,
+			Name:    "add event end dates",
+			Up:      addColumns("Event", "EndDate DATETIME"),
+			Down:    dropColumns("Event", "EndDate"),
+		},
+		{
+			Version: 9,
+			Name:    "add event time zones",
+			// Event times stay in UTC when this is undone
+			Up: inOrder(
+				addColumns("Event", "TimeZone TEXT"),
+				normalizeEventTimes(timeZone),
+			),
+			Down: dropColumns("Event", "TimeZone"),
+		},
+		{
+			Version: 10,
+			Name:    "add event sequence numbers",
+			Up:      addColumns("Event", "Sequence INTEGER NOT NULL DEFAULT 0", "UpdatedAt DATETIME"),
+			Down:    dropColumns("Event", "Sequence", "UpdatedAt"),
+		},
+		{
+			Version: 11,
+			Name:    "add recurring events",
+			Up: inOrder(
+				addColumns("Event", "RecurFreq TEXT", "RecurInterval INTEGER", "RecurUntil TEXT", "RecurCount INTEGER", "RecurExceptions TEXT"),
+				addOccurrenceToRSVPTables,
+			),
+			// RSVPs to single occurrences are dropped when this is undone
+			Down: inOrder(
+				removeOccurrenceFromRSVPTables,
+				dropColumns("Event", "RecurFreq", "RecurInterval", "RecurUntil", "RecurCount", "RecurExceptions"),
+			),
+		},
+		{
+			Version: 12,
+			Name:    "add series end dates",
+			Up: inOrder(
+				addColumns("Event", "SeriesEnd DATETIME"),
+				fillSeriesEnds,
+			),
+			Down: dropColumns("Event", "SeriesEnd"),
+		},
+		{
+			Version: 13,
+			Name:    "add event public IDs",
+			Up: inOrder(
+				addColumns("Event", "PublicID TEXT", "LegacyID INTEGER"),
+				fillPublicIDs,
+				execSQL(`
+                CREATE UNIQUE INDEX IF NOT EXISTS Event_PublicID ON Event (PublicID);
+                CREATE UNIQUE INDEX IF NOT EXISTS Event_LegacyID ON Event (LegacyID);`),
+			),
+			Down: inOrder(
+				execSQL(`
+                DROP INDEX Event_LegacyID;
+                DROP INDEX Event_PublicID;`),
+				dropColumns("Event", "PublicID", "LegacyID"),
+			),
+		},
+		{
+			Version: 14,
+			Name:    "add event slugs",
+			Up: inOrder(
+				addColumns("Event", "Slug TEXT"),
+				fillSlugs,
+				execSQL("CREATE UNIQUE INDEX IF NOT EXISTS Event_Slug ON Event (Slug)"),
+			),
+			Down: inOrder(
+				execSQL("DROP INDEX Event_Slug"),
+				dropColumns("Event", "Slug"),
+			),
+		},
+		{
+			Version: 15,
+			Name:    "add attendee names and affiliations",
+			Up: inOrder(
+				renameColumn("Attendee", "Name", "Email"),
+				addColumns("Event_Attendee", "DisplayName TEXT NOT NULL DEFAULT ''", "Affiliation TEXT NOT NULL DEFAULT ''"),
+				addColumns("Waitlist", "DisplayName TEXT NOT NULL DEFAULT ''", "Affiliation TEXT NOT NULL DEFAULT ''"),
+			),
+			Down: inOrder(
+				dropColumns("Waitlist", "DisplayName", "Affiliation"),
+				dropColumns("Event_Attendee", "DisplayName", "Affiliation"),
+				renameColumn("Attendee", "Email", "Name"),
+			),
+		},
+		{
+			Version: 16,
+			Name:    "add attendee visibility",
+			Up:      addColumns("Event", "AttendeeVisibility TEXT"),
+			Down:    dropColumns("Event", "AttendeeVisibility"),
+		},
+	}
+}
+
+// execSQL - returns a migration step that runs `statements`.
+func execSQL(statements string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(statements)
+		return err
+	}
+}
+
+// inOrder - returns a migration step that runs `steps` one after another.
+func inOrder(steps ...func(tx *sql.Tx) error) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, step := range steps {
+			if err := step(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addColumns - returns a migration step that adds columns to `table`, each
+// given as "Name TYPE ...", skipping any it already has.
+func addColumns(table string, definitions ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, definition := range definitions {
+			column := strings.Fields(definition)[0]
+			if found, err := hasColumn(tx, table, column); err != nil {
+				return err
+			} else if found {
+				continue
+			}
+			if _, err := tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + definition); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// dropColumns - returns a migration step that removes `columns` from
+// `table`.
+func dropColumns(table string, columns ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, column := range columns {
+			if _, err := tx.Exec("ALTER TABLE " + table + " DROP COLUMN " + column); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// renameColumn - returns a migration step that renames a column of
+// `table` from `from` to `to`, unless it has been renamed already.
+func renameColumn(table string, from string, to string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		if found, err := hasColumn(tx, table, from); err != nil || !found {
+			return err
+		}
+		_, err := tx.Exec("ALTER TABLE " + table + " RENAME COLUMN " + from + " TO " + to)
+		return err
+	}
+}
+
+// hasColumn - reports whether `table` has a column named `column`.
+func hasColumn(tx *sql.Tx, table string, column string) (bool, error) {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if strings.EqualFold(name, column) {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// addOccurrenceToRSVPTables - adds the Occurrence column to Event_Attendee
+// and Waitlist. The column is part of each table's key, which SQLite
+// cannot change in place, so the tables are rebuilt.
+func addOccurrenceToRSVPTables(tx *sql.Tx) error {
+	if found, err := hasColumn(tx, "Event_Attendee", "Occurrence"); err != nil || found {
+		return err
+	}
+
+	_, err := tx.Exec(`
+        CREATE TABLE Event_Attendee_New (
+            EventID INTEGER,
+            AttendeeID INTEGER,
+            Occurrence TEXT NOT NULL DEFAULT '',
+            ConfirmationCode TEXT,
+            Confirmed INTEGER NOT NULL DEFAULT 1,
+            VerifyToken TEXT,
+            CreatedAt DATETIME,
+            PRIMARY KEY (EventID, AttendeeID, Occurrence),
+            FOREIGN KEY (EventID) REFERENCES Event(ID) ON DELETE CASCADE,
+            FOREIGN KEY (AttendeeID) REFERENCES Attendee(ID) ON DELETE CASCADE
+        );
+        INSERT INTO Event_Attendee_New (EventID, AttendeeID, ConfirmationCode, Confirmed, VerifyToken, CreatedAt)
+            SELECT EventID, AttendeeID, ConfirmationCode, Confirmed, VerifyToken, CreatedAt FROM Event_Attendee;
+        DROP TABLE Event_Attendee;
+        ALTER TABLE Event_Attendee_New RENAME TO Event_Attendee;
+
+        CREATE TABLE Waitlist_New (
+            ID INTEGER PRIMARY KEY AUTOINCREMENT,
+            EventID INTEGER NOT NULL,
+            AttendeeID INTEGER NOT NULL,
+            Occurrence TEXT NOT NULL DEFAULT '',
+            ConfirmationCode TEXT,
+            Confirmed INTEGER NOT NULL DEFAULT 1,
+            VerifyToken TEXT,
+            CreatedAt DATETIME,
+            UNIQUE (EventID, AttendeeID, Occurrence),
+            FOREIGN KEY (EventID) REFERENCES Event(ID) ON DELETE CASCADE,
+            FOREIGN KEY (AttendeeID) REFERENCES Attendee(ID) ON DELETE CASCADE
+        );
+        INSERT INTO Waitlist_New (ID, EventID, AttendeeID, ConfirmationCode, Confirmed, VerifyToken, CreatedAt)
+            SELECT ID, EventID, AttendeeID, ConfirmationCode, Confirmed, VerifyToken, CreatedAt FROM Waitlist;
+        DROP TABLE Waitlist;
+        ALTER TABLE Waitlist_New RENAME TO Waitlist;
+    `)
+	return err
+}
+
+// removeOccurrenceFromRSVPTables - undoes addOccurrenceToRSVPTables,
+// keeping only the RSVPs to whole events.
+func removeOccurrenceFromRSVPTables(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE Event_Attendee_Old (
+            EventID INTEGER,
+            AttendeeID INTEGER,
+            ConfirmationCode TEXT,
+            Confirmed INTEGER NOT NULL DEFAULT 1,
+            VerifyToken TEXT,
+            CreatedAt DATETIME,
+            PRIMARY KEY (EventID, AttendeeID),
+            FOREIGN KEY (EventID) REFERENCES Event(ID) ON DELETE CASCADE,
+            FOREIGN KEY (AttendeeID) REFERENCES Attendee(ID) ON DELETE CASCADE
+        );
+        INSERT INTO Event_Attendee_Old (EventID, AttendeeID, ConfirmationCode, Confirmed, VerifyToken, CreatedAt)
+            SELECT EventID, AttendeeID, ConfirmationCode, Confirmed, VerifyToken, CreatedAt FROM Event_Attendee WHERE Occurrence = '';
+        DROP TABLE Event_Attendee;
+        ALTER TABLE Event_Attendee_Old RENAME TO Event_Attendee;
+
+        CREATE TABLE Waitlist_Old (
+            ID INTEGER PRIMARY KEY AUTOINCREMENT,
+            EventID INTEGER NOT NULL,
+            AttendeeID INTEGER NOT NULL,
+            ConfirmationCode TEXT,
+            Confirmed INTEGER NOT NULL DEFAULT 1,
+            VerifyToken TEXT,
+            CreatedAt DATETIME,
+            UNIQUE (EventID, AttendeeID),
+            FOREIGN KEY (EventID) REFERENCES Event(ID) ON DELETE CASCADE,
+            FOREIGN KEY (AttendeeID) REFERENCES Attendee(ID) ON DELETE CASCADE
+        );
+        INSERT INTO Waitlist_Old (ID, EventID, AttendeeID, ConfirmationCode, Confirmed, VerifyToken, CreatedAt)
+            SELECT ID, EventID, AttendeeID, ConfirmationCode, Confirmed, VerifyToken, CreatedAt FROM Waitlist WHERE Occurrence = '';
+        DROP TABLE Waitlist;
+        ALTER TABLE Waitlist_Old RENAME TO Waitlist;
+    `)
+	return err
+}
+
+// appliedMigrations - returns when each applied migration was applied, by
+// version, creating the schema_migrations table if needed.
+func appliedMigrations(db *sql.DB) (map[int]time.Time, error) {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            name TEXT NOT NULL,
+            applied_at DATETIME NOT NULL
+        )`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// runMigration - applies migration `m`, or undoes it if `up` is false, and
+// records that in schema_migrations, in one transaction.
+func runMigration(db *sql.DB, m migration, up bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if up {
+		if err := m.Up(tx); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", m.Version, m.Name, time.Now().UTC())
+	} else {
+		if err := m.Down(tx); err != nil {
+			return fmt.Errorf("undoing migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateUp - applies every one of `migrations` that has not been applied
+// yet, in order.
+func migrateUp(db *sql.DB, migrations []migration) error {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, done := applied[m.Version]; done {
+			continue
+		}
+		if err := runMigration(db, m, true); err != nil {
+			return err
+		}
+		log.Printf("applied migration %d (%s)", m.Version, m.Name)
+	}
+	return nil
+}
+
+// migrateDown - undoes the `steps` most recently applied of `migrations`.
+func migrateDown(db *sql.DB, migrations []migration, steps int) error {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if _, done := applied[m.Version]; !done {
+			continue
+		}
+		if err := runMigration(db, m, false); err != nil {
+			return err
+		}
+		log.Printf("undid migration %d (%s)", m.Version, m.Name)
+		steps--
+	}
+	return nil
+}
+
+// printMigrationStatus - writes each of `migrations` and whether it has
+// been applied to `w`.
+func printMigrationStatus(db *sql.DB, migrations []migration, w io.Writer) error {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		status := "pending"
+		if appliedAt, done := applied[m.Version]; done {
+			status = "applied " + appliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%4d  %-27s  %s\n", m.Version, status, m.Name)
+	}
+	return nil
+}
+
+// runMigrateCommand - handles `migrate up`, `migrate down [steps]` and
+// `migrate status` on the command line.
+func runMigrateCommand(db *sql.DB, migrations []migration, args []string, w io.Writer) error {
+	usage := fmt.Errorf("usage: migrate up | down [steps] | status")
+	if len(args) == 0 {
+		return usage
+	}
+	switch args[0] {
+	case "up":
+		return migrateUp(db, migrations)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return usage
+			}
+			steps = n
+		}
+		return migrateDown(db, migrations, steps)
+	case "status":
+		return printMigrationStatus(db, migrations, w)
+	}
+	return usage
+}
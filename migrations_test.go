@@ -0,0 +1,89 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// appliedVersions - returns how many migrations are recorded as applied.
+func appliedVersions(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		t.Fatalf("appliedMigrations: %v", err)
+	}
+	return len(applied)
+}
+
+func TestMigrationVersions(t *testing.T) {
+	for i, m := range schemaMigrations(testDefaults.TimeZone) {
+		if m.Version != i+1 {
+			t.Errorf("migration %q has version %d, want %d", m.Name, m.Version, i+1)
+		}
+		if m.Up == nil || m.Down == nil {
+			t.Errorf("migration %d cannot be both applied and undone", m.Version)
+		}
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	db, err := initDB(filepath.Join(t.TempDir(), "events.db"), testDefaults.TimeZone)
+	if err != nil {
+		t.Fatalf("initDB: %v", err)
+	}
+	defer db.Close()
+	store := &sqliteStore{db: db}
+	migrations := schemaMigrations(testDefaults.TimeZone)
+
+	tests := []struct {
+		name  string
+		steps int
+	}{
+		{name: "latest migration", steps: 1},
+		{name: "back to the untracked schema", steps: len(migrations) - 12},
+		{name: "everything", steps: len(migrations)},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := migrateDown(db, migrations, test.steps); err != nil {
+				t.Fatalf("migrateDown(%d): %v", test.steps, err)
+			}
+			if got, want := appliedVersions(t, db), len(migrations)-test.steps; got != want {
+				t.Errorf("%d migrations applied after undoing %d, want %d", got, test.steps, want)
+			}
+			if err := migrateUp(db, migrations); err != nil {
+				t.Fatalf("migrateUp: %v", err)
+			}
+			if got := appliedVersions(t, db); got != len(migrations) {
+				t.Errorf("%d migrations applied, want %d", got, len(migrations))
+			}
+
+			// The schema works again, whatever data survived
+			id := createTestEvent(t, store, Event{Capacity: 1})
+			rsvpAndVerify(t, store, id, "a@yale.edu", "")
+			if rsvp := rsvpAndVerify(t, store, id, "b@yale.edu", ""); rsvp.WaitlistPosition != 1 {
+				t.Errorf("waitlist position = %d, want 1", rsvp.WaitlistPosition)
+			}
+			if _, err := store.CreateUser("user"+strconv.Itoa(i)+"@yale.edu", "hash"); err != nil {
+				t.Errorf("CreateUser: %v", err)
+			}
+		})
+	}
+}
+
+func TestMigrateUpIsIdempotent(t *testing.T) {
+	db, err := initDB(filepath.Join(t.TempDir(), "events.db"), testDefaults.TimeZone)
+	if err != nil {
+		t.Fatalf("initDB: %v", err)
+	}
+	defer db.Close()
+	migrations := schemaMigrations(testDefaults.TimeZone)
+	if err := migrateUp(db, migrations); err != nil {
+		t.Fatalf("second migrateUp: %v", err)
+	}
+	if got := appliedVersions(t, db); got != len(migrations) {
+		t.Errorf("%d migrations applied, want %d", got, len(migrations))
+	}
+}
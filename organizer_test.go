@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestIsOrganizer(t *testing.T) {
+	token := newOrganizerToken()
+	tests := []struct {
+		name  string
+		hash  string
+		token string
+		want  bool
+	}{
+		{name: "matching token", hash: hashOrganizerToken(token), token: token, want: true},
+		{name: "other token", hash: hashOrganizerToken(token), token: newOrganizerToken()},
+		{name: "no token", hash: hashOrganizerToken(token)},
+		{name: "hash as token", hash: hashOrganizerToken(token), token: hashOrganizerToken(token)},
+		{name: "event without organizer", token: token},
+		{name: "empty token of event without organizer"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isOrganizer(Event{OrganizerTokenHash: test.hash}, test.token); got != test.want {
+				t.Errorf("isOrganizer = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOrganizerToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		form   string
+		header string
+		want   string
+	}{
+		{name: "none", method: http.MethodGet, url: "/events/1/edit"},
+		{name: "link", method: http.MethodGet, url: "/events/1/edit?token=abc", want: "abc"},
+		{name: "form", method: http.MethodPost, url: "/events/1/edit", form: "token=abc", want: "abc"},
+		{name: "header", method: http.MethodPatch, url: "/api/events/1", header: "abc", want: "abc"},
+		{name: "header before link", method: http.MethodGet, url: "/events/1/edit?token=abc", header: "xyz", want: "xyz"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.url, strings.NewReader(test.form))
+			if test.form != "" {
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if test.header != "" {
+				r.Header.Set("X-Organizer-Token", test.header)
+			}
+			if got := organizerToken(r); got != test.want {
+				t.Errorf("organizerToken = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEditAndDeletePages(t *testing.T) {
+	event := createAPIEvent(t)
+	path := "/events/" + event.PublicID
+	token := url.QueryEscape(event.OrganizerToken)
+
+	expectStatus(t, serve(t, http.MethodGet, path+"/edit", ""), http.StatusForbidden)
+	expectStatus(t, serve(t, http.MethodGet, path+"/edit?token=nope", ""), http.StatusForbidden)
+	expectStatus(t, serve(t, http.MethodGet, path+"/edit?token="+token, ""), http.StatusOK)
+	expectStatus(t, serve(t, http.MethodGet, path+"/delete?token="+token, ""), http.StatusOK)
+	expectStatus(t, serve(t, http.MethodPost, path+"/delete", "token=nope"), http.StatusForbidden)
+	expectStatus(t, serve(t, http.MethodGet, "/api"+path, ""), http.StatusOK)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// publicIDEncoding spells public IDs with lowercase letters and the digits
+// 2 to 7, so they contain no characters that need escaping in a URL.
+var publicIDEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// newPublicID - returns a fresh random ID for an event's URLs. Unlike the
+// sequential ID the event is stored under, it gives away neither how many
+// events there are nor which were deleted.
+func newPublicID() string {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return publicIDEncoding.EncodeToString(b)
+}
+
+// fillPublicIDs - gives each event saved before public IDs one, and keeps
+// its ID as LegacyID so that its old URLs can be redirected.
+func fillPublicIDs(tx *sql.Tx) error {
+	if _, err := tx.Exec("UPDATE Event SET LegacyID = ID WHERE PublicID IS NULL"); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT ID FROM Event WHERE PublicID IS NULL")
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		publicID, err := unusedPublicID(tx)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE Event SET PublicID = ? WHERE ID = ?", publicID, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventIDParam - resolves the {id} URL parameter of the current route to
+// the ID the event is stored under. Returns errEventNotFound if it names
+// no event.
+func (s *server) eventIDParam(r *http.Request) (int, error) {
+	return s.eventID(chi.URLParam(r, "id"))
+}
+
+// eventID - returns the ID of the event that `param` names: its public
+// ID, its slug, or both as in Event.PathID, in which case the slug must be
+// the event's own. Returns errEventNotFound if it names no event.
+func (s *server) eventID(param string) (int, error) {
+	id, found, err := s.store.EventIDByPublicID(param)
+	if publicID, slug, hasSlug := strings.Cut(param, "-"); err == nil && !found && hasSlug {
+		// Public IDs have no hyphens, so this may be {public ID}-{slug}
+		id, found, err = s.store.EventIDByPublicID(publicID)
+		if err == nil && found {
+			var slugID int
+			slugID, found, err = s.store.EventIDBySlug(slug)
+			found = found && slugID == id
+		}
+	}
+	if err == nil && !found {
+		id, found, err = s.store.EventIDBySlug(param)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, errEventNotFound
+	}
+	return id, nil
+}
+
+// eventRedirect - returns the Event.PathID that URLs naming an event by
+// `param` are redirected to, and whether they are: when `param` is the
+// number the event had before events got public IDs, or its public ID
+// followed by a slug that is not its own, e.g. a mistyped one.
+func (s *server) eventRedirect(param string) (string, bool, error) {
+	if legacyID, err := strconv.Atoi(param); err == nil {
+		return s.store.LegacyEventPathID(legacyID)
+	}
+
+	publicID, _, hasSlug := strings.Cut(param, "-")
+	if !hasSlug {
+		return "", false, nil
+	}
+	if _, err := s.eventID(param); err != errEventNotFound {
+		return "", false, err
+	}
+	id, found, err := s.store.EventIDByPublicID(publicID)
+	if err != nil || !found {
+		return "", false, err
+	}
+	event, found, err := s.store.GetEvent(id)
+	if err != nil || !found {
+		return "", false, err
+	}
+	return event.PathID(), true, nil
+}
+
+// redirectEventURLs - permanently redirects URLs that name an event by
+// an old or a wrong name to the same URL with its Event.PathID, so links
+// shared before events got public IDs, e.g. /events/5, keep working.
+// Events created since have no such number, so their URLs cannot be found
+// by counting.
+func (s *server) redirectEventURLs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		param := chi.URLParam(r, "id")
+		pathID, found, err := s.eventRedirect(param)
+		if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+		if !found {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		target := *r.URL
+		target.Path = strings.Replace(r.URL.Path, "/events/"+param, "/events/"+pathID, 1)
+		target.RawPath = ""
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			// A 301 may turn the request into a GET; 308 keeps its method
+			// and body
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, target.String(), status)
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewPublicID(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id := newPublicID()
+		if len(id) != 8 || strings.Trim(id, "abcdefghijklmnopqrstuvwxyz234567") != "" {
+			t.Fatalf("public ID %q is not 8 lowercase letters and digits", id)
+		}
+		if seen[id] {
+			t.Fatalf("public ID %q was issued twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestLegacyEventURLs(t *testing.T) {
+	// An event saved before public IDs, as migration 13 finds it
+	res, err := testDB.Exec("INSERT INTO Event (Title, Location, Image, Date, RSVPMessage, TimeZone) VALUES ('Old party', 'Evans Hall', '', ?, '', 'UTC')", time.Now().AddDate(1, 0, 0).UTC())
+	if err != nil {
+		t.Fatalf("inserting an event without a public ID: %v", err)
+	}
+	id, _ := res.LastInsertId()
+	tx, err := testDB.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	defer tx.Rollback()
+	if err := fillPublicIDs(tx); err != nil {
+		t.Fatalf("fillPublicIDs: %v", err)
+	}
+	if err := fillSlugs(tx); err != nil {
+		t.Fatalf("fillSlugs: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	event := mustGetEvent(t, int(id))
+	publicID := event.PublicID
+	if publicID == "" {
+		t.Fatal("fillPublicIDs did not give the event a public ID")
+	}
+	if event.LegacyID != int(id) {
+		t.Errorf("LegacyID = %d, want %d", event.LegacyID, id)
+	}
+	legacy := strconv.Itoa(int(id))
+	pathID := event.PathID()
+	if pathID == publicID {
+		t.Fatal("fillSlugs did not give the event a slug")
+	}
+
+	tests := []struct {
+		method     string
+		path       string
+		wantStatus int
+		wantTarget string
+	}{
+		{method: http.MethodGet, path: "/events/" + legacy + "?token=abc", wantStatus: http.StatusMovedPermanently, wantTarget: "/events/" + pathID + "?token=abc"},
+		{method: http.MethodGet, path: "/events/" + legacy + ".ics", wantStatus: http.StatusMovedPermanently, wantTarget: "/events/" + pathID + ".ics"},
+		{method: http.MethodGet, path: "/api/events/" + legacy, wantStatus: http.StatusMovedPermanently, wantTarget: "/api/events/" + pathID},
+		{method: http.MethodPost, path: "/api/events/" + legacy + "/rsvp", wantStatus: http.StatusPermanentRedirect, wantTarget: "/api/events/" + pathID + "/rsvp"},
+		{method: http.MethodGet, path: "/events/" + publicID, wantStatus: http.StatusOK},
+		{method: http.MethodGet, path: "/events/999999", wantStatus: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			w := serve(t, test.method, test.path, "")
+			expectStatus(t, w, test.wantStatus)
+			if got := w.Header().Get("Location"); got != test.wantTarget {
+				t.Errorf("Location = %q, want %q", got, test.wantTarget)
+			}
+		})
+	}
+
+	// Events created since have no number to be found by
+	newID := mustAddEvent(t, Event{Title: "New party", Date: time.Now().AddDate(1, 0, 0)})
+	expectStatus(t, serve(t, http.MethodGet, "/events/"+strconv.Itoa(newID), ""), http.StatusNotFound)
+}
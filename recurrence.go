@@ -0,0 +1,431 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence frequencies. An event without a Recurrence happens once.
+const (
+	recurDaily   = "daily"
+	recurWeekly  = "weekly"
+	recurMonthly = "monthly"
+)
+
+// occurrenceKeyLayout formats the key of an occurrence of a recurring
+// event, which is the date it starts on in the event's time zone.
+const occurrenceKeyLayout = "2006-01-02"
+
+// occurrenceWindow is how far ahead the occurrences of recurring events
+// are listed.
+const occurrenceWindow = 90 * 24 * time.Hour
+
+// maxOccurrenceSteps bounds how many occurrences are generated when
+// expanding a series that never ends.
+const maxOccurrenceSteps = 10000
+
+// Recurrence - the schedule of a recurring event, modelled on the RRULE of
+// RFC 5545. The event's Date is the first occurrence, and later ones are
+// every Interval days, weeks or months after it. The series stops after
+// Count occurrences or on the date Until, if either is set, and skips the
+// dates listed in Exceptions.
+type Recurrence struct {
+	Freq       string   `json:"freq"`
+	Interval   int      `json:"interval"`
+	Until      string   `json:"until,omitempty"`
+	Count      int      `json:"count,omitempty"`
+	Exceptions []string `json:"exceptions,omitempty"`
+}
+
+// Occurrence - a single date of a recurring event, with the people coming
+// to it: those who RSVP-ed to the whole series and those who RSVP-ed to
+// just this date.
+type Occurrence struct {
+	Key       string     `json:"key"`
+	Date      time.Time  `json:"date"`
+	EndDate   time.Time  `json:"end_date"`
+	Attending []Attendee `json:"attending"`
+
+	// AttendeeCount is only filled in by restrictAttendees.
+	AttendeeCount *int `json:"attendee_count,omitempty"`
+}
+
+// occurrenceRSVPs - the RSVPs to one occurrence of a recurring event
+// rather than to the whole series.
+type occurrenceRSVPs struct {
+	Attending []Attendee
+	Pending   []string
+	Waitlist  []string
+}
+
+// isValidRecurFreq - reports whether `freq` is one of the known recurrence
+// frequencies.
+func isValidRecurFreq(freq string) bool {
+	switch freq {
+	case recurDaily, recurWeekly, recurMonthly:
+		return true
+	}
+	return false
+}
+
+// newRecurrence - builds the recurrence stored in an event's Recur*
+// columns, or nil if the event does not recur.
+func newRecurrence(freq string, interval int, until string, count int, exceptions string) *Recurrence {
+	if freq == "" {
+		return nil
+	}
+	if interval < 1 {
+		interval = 1
+	}
+	return &Recurrence{
+		Freq:       freq,
+		Interval:   interval,
+		Until:      until,
+		Count:      count,
+		Exceptions: strings.FieldsFunc(exceptions, func(r rune) bool { return r == ',' }),
+	}
+}
+
+// recurrenceColumns - returns the values of the event's Recur* columns.
+func (event Event) recurrenceColumns() (freq string, interval int, until string, count int, exceptions string) {
+	if event.Recurrence == nil {
+		return "", 1, "", 0, ""
+	}
+	r := event.Recurrence
+	return r.Freq, r.Interval, r.Until, r.Count, strings.Join(r.Exceptions, ",")
+}
+
+// Describe - returns the schedule in words, e.g. "Every 2 weeks, 10 times".
+func (r Recurrence) Describe() string {
+	unit := map[string]string{recurDaily: "day", recurWeekly: "week", recurMonthly: "month"}[r.Freq]
+	description := "Every " + unit
+	if r.Interval > 1 {
+		description = "Every " + strconv.Itoa(r.Interval) + " " + unit + "s"
+	}
+	if r.Count > 0 {
+		description += ", " + strconv.Itoa(r.Count) + " times"
+	}
+	if r.Until != "" {
+		if until, err := time.Parse(occurrenceKeyLayout, r.Until); err == nil {
+			description += ", until " + until.Format("January 2, 2006")
+		}
+	}
+	if len(r.Exceptions) > 0 {
+		description += ", except " + strings.Join(r.Exceptions, ", ")
+	}
+	return description
+}
+
+// isException - reports whether the occurrence with `key` was cancelled.
+func (r Recurrence) isException(key string) bool {
+	for _, exception := range r.Exceptions {
+		if exception == key {
+			return true
+		}
+	}
+	return false
+}
+
+// occurrenceStarts - returns the start times of the occurrences of the
+// event that are still going on at `from` and start before `to`, at most
+// `limit` of them if `limit` is positive. An event that does not recur
+// has a single occurrence.
+func (event Event) occurrenceStarts(from time.Time, to time.Time, limit int) []time.Time {
+	duration := event.End().Sub(event.Date)
+	if event.Recurrence == nil {
+		if event.Date.Add(duration).After(from) && event.Date.Before(to) {
+			return []time.Time{event.Date}
+		}
+		return nil
+	}
+
+	r := *event.Recurrence
+	interval := r.Interval
+	if interval < 1 {
+		interval = 1
+	}
+
+	// Start from just before `from` rather than from the first occurrence,
+	// so a series that began long ago still reaches it
+	first := event.firstOccurrenceStep(from.Add(-duration), interval)
+	generated := first
+	if r.Freq == recurMonthly && r.Count > 0 {
+		// Skipped months do not count towards Count
+		generated = 0
+		for step := 0; step < first && generated <= r.Count; step++ {
+			if event.Date.AddDate(0, step*interval, 0).Day() == event.Date.Day() {
+				generated++
+			}
+		}
+	}
+
+	var starts []time.Time
+	for step := first; step < first+maxOccurrenceSteps; step++ {
+		// Dates are stepped in the event's time zone, so occurrences keep
+		// their wall-clock time across daylight saving changes
+		var start time.Time
+		switch r.Freq {
+		case recurDaily:
+			start = event.Date.AddDate(0, 0, step*interval)
+		case recurWeekly:
+			start = event.Date.AddDate(0, 0, 7*step*interval)
+		case recurMonthly:
+			start = event.Date.AddDate(0, step*interval, 0)
+			// Like RFC 5545, skip months that do not have the day, e.g.
+			// the 31st
+			if start.Day() != event.Date.Day() {
+				continue
+			}
+		default:
+			return starts
+		}
+
+		generated++
+		key := start.Format(occurrenceKeyLayout)
+		if (r.Count > 0 && generated > r.Count) || (r.Until != "" && key > r.Until) || !start.Before(to) {
+			break
+		}
+		if r.isException(key) || !start.Add(duration).After(from) {
+			continue
+		}
+		starts = append(starts, start)
+		if limit > 0 && len(starts) >= limit {
+			break
+		}
+	}
+	return starts
+}
+
+// firstOccurrenceStep - returns a step of the series, counted in
+// intervals from the event's Date, whose occurrence starts no later than
+// `threshold`. Every occurrence before it starts earlier still.
+func (event Event) firstOccurrenceStep(threshold time.Time, interval int) int {
+	if !threshold.After(event.Date) {
+		return 0
+	}
+	var steps int64
+	switch event.Recurrence.Freq {
+	case recurDaily:
+		steps = (threshold.Unix() - event.Date.Unix()) / (24 * 60 * 60) / int64(interval)
+	case recurWeekly:
+		steps = (threshold.Unix() - event.Date.Unix()) / (7 * 24 * 60 * 60) / int64(interval)
+	case recurMonthly:
+		date, local := event.Date, threshold.In(event.Date.Location())
+		steps = int64((local.Year()-date.Year())*12+int(local.Month())-int(date.Month())) / int64(interval)
+	}
+	// Step back one in case a daylight saving change or a short month
+	// puts that occurrence just after `threshold`
+	if steps > 0 {
+		steps--
+	}
+	return int(steps)
+}
+
+// seriesEnd - returns when the last occurrence of the event ends, or nil
+// for a recurring series that goes on forever. Stored as SeriesEnd so
+// events can be filtered by date in SQL.
+func (event Event) seriesEnd() *time.Time {
+	if event.Recurrence == nil {
+		end := event.End()
+		return &end
+	}
+	if event.Recurrence.Count == 0 && event.Recurrence.Until == "" {
+		return nil
+	}
+	starts := event.occurrenceStarts(event.Date, maxTime, 0)
+	if len(starts) == 0 {
+		end := event.End()
+		return &end
+	}
+	end := starts[len(starts)-1].Add(event.End().Sub(event.Date))
+	return &end
+}
+
+// maxTime is later than any event.
+var maxTime = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// fillSeriesEnds - sets SeriesEnd on events saved before it was stored.
+// Series that never end keep it NULL.
+func fillSeriesEnds(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT ID, Date, EndDate, COALESCE(TimeZone, ''), COALESCE(RecurFreq, ''), COALESCE(RecurInterval, 1), COALESCE(RecurUntil, ''), COALESCE(RecurCount, 0), COALESCE(RecurExceptions, '') FROM Event WHERE SeriesEnd IS NULL")
+	if err != nil {
+		return err
+	}
+	var events []Event
+	for rows.Next() {
+		var event Event
+		var endDate sql.NullTime
+		var recurFreq, recurUntil, recurExceptions string
+		var recurInterval, recurCount int
+		if err := rows.Scan(&event.ID, &event.Date, &endDate, &event.TimeZone, &recurFreq, &recurInterval, &recurUntil, &recurCount, &recurExceptions); err != nil {
+			rows.Close()
+			return err
+		}
+		if endDate.Valid {
+			event.EndDate = &endDate.Time
+		}
+		event.Recurrence = newRecurrence(recurFreq, recurInterval, recurUntil, recurCount, recurExceptions)
+		event.localizeTimes()
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		if end := event.seriesEnd(); end != nil {
+			if _, err := tx.Exec("UPDATE Event SET SeriesEnd = ? WHERE ID = ?", end.UTC(), event.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// findOccurrence - returns the start of the occurrence of the event with
+// `key` and a boolean indicating whether or not there is one.
+func (event Event) findOccurrence(key string) (time.Time, bool) {
+	day, err := time.ParseInLocation(occurrenceKeyLayout, key, event.location())
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, start := range event.occurrenceStarts(day, day.AddDate(0, 0, 1), 0) {
+		if start.Format(occurrenceKeyLayout) == key {
+			return start, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// listOccurrences - fills in the Occurrences of a recurring event that are
+// going on between `from` and `to`, at most `limit` of them if `limit` is
+// positive. Must be called before selectOccurrence.
+func (event *Event) listOccurrences(from time.Time, to time.Time, limit int) {
+	if event.Recurrence == nil {
+		return
+	}
+	duration := event.End().Sub(event.Date)
+	event.Occurrences = []Occurrence{}
+	for _, start := range event.occurrenceStarts(from, to, limit) {
+		occurrence := Occurrence{
+			Key:       start.Format(occurrenceKeyLayout),
+			Date:      start,
+			EndDate:   start.Add(duration),
+			Attending: append([]Attendee{}, event.Attending...),
+		}
+		if rsvps := event.OccurrenceRSVPs[occurrence.Key]; rsvps != nil {
+			occurrence.Attending = append(occurrence.Attending, rsvps.Attending...)
+		}
+		event.Occurrences = append(event.Occurrences, occurrence)
+	}
+}
+
+// selectOccurrence - narrows the event down to its occurrence with `key`:
+// its dates become those of the occurrence, and its attendees, pending
+// RSVPs and waitlist also include those of just that occurrence. Returns
+// false if the event has no such occurrence.
+func (event *Event) selectOccurrence(key string) bool {
+	start, ok := event.findOccurrence(key)
+	if !ok {
+		return false
+	}
+	if event.EndDate != nil {
+		end := start.Add(event.EndDate.Sub(event.Date))
+		event.EndDate = &end
+	}
+	event.Date = start
+	event.Occurrence = key
+	if rsvps := event.OccurrenceRSVPs[key]; rsvps != nil {
+		event.Attending = append(append([]Attendee{}, event.Attending...), rsvps.Attending...)
+		event.Pending = append(append([]string{}, event.Pending...), rsvps.Pending...)
+		event.Waitlist = append(append([]string{}, event.Waitlist...), rsvps.Waitlist...)
+	}
+	return true
+}
+
+// upcomingOccurrences - expands recurring events into one copy per
+// occurrence in the next occurrenceWindow, each with the dates and key of
+// its occurrence. Other events, and series with nothing coming up, are
+// kept as they are.
+func upcomingOccurrences(events []Event) []Event {
+	now := time.Now()
+	var expanded []Event
+	for _, event := range events {
+		starts := event.occurrenceStarts(now, now.Add(occurrenceWindow), 0)
+		if event.Recurrence == nil || len(starts) == 0 {
+			expanded = append(expanded, event)
+			continue
+		}
+		for _, start := range starts {
+			occurrence := event
+			occurrence.selectOccurrence(start.Format(occurrenceKeyLayout))
+			expanded = append(expanded, occurrence)
+		}
+	}
+	return expanded
+}
+
+// recurrenceFields - a recurrence as submitted through the HTML form or
+// the JSON API. An empty Freq, or "none", removes the recurrence.
+type recurrenceFields struct {
+	Freq       string   `json:"freq"`
+	Interval   int      `json:"interval"`
+	Until      string   `json:"until"`
+	Count      int      `json:"count"`
+	Exceptions []string `json:"exceptions"`
+}
+
+// parse - validates the submitted recurrence of `event`, whose Date must
+// already be set, and returns it, or nil if the event does not recur.
+func (f recurrenceFields) parse(event Event) (*Recurrence, *FieldError) {
+	bad := func(message string) (*Recurrence, *FieldError) {
+		return nil, &FieldError{Field: "recurrence", Message: "Bad Recurrence! " + message}
+	}
+
+	if f.Freq == "" || f.Freq == "none" {
+		return nil, nil
+	}
+	if !isValidRecurFreq(f.Freq) {
+		return bad("Must repeat daily, weekly or monthly.")
+	}
+	r := &Recurrence{Freq: f.Freq, Interval: f.Interval, Count: f.Count}
+	if r.Interval == 0 {
+		r.Interval = 1
+	}
+	if r.Interval < 0 {
+		return bad("The interval must be a whole number.")
+	}
+	if r.Count < 0 {
+		return bad("The number of occurrences must be a whole number.")
+	}
+
+	if f.Until != "" {
+		until, err := time.Parse(occurrenceKeyLayout, f.Until)
+		if err != nil {
+			return bad("The end date must be formatted as YYYY-MM-DD.")
+		}
+		r.Until = until.Format(occurrenceKeyLayout)
+		if r.Until < event.Date.Format(occurrenceKeyLayout) {
+			return bad("The end date must not be before the first occurrence.")
+		}
+	}
+	if r.Until != "" && r.Count > 0 {
+		return bad("Give either an end date or a number of occurrences, not both.")
+	}
+
+	for _, exception := range f.Exceptions {
+		exception = strings.TrimSpace(exception)
+		if exception == "" {
+			continue
+		}
+		date, err := time.Parse(occurrenceKeyLayout, exception)
+		if err != nil {
+			return bad("Exceptions must be dates formatted as YYYY-MM-DD.")
+		}
+		r.Exceptions = append(r.Exceptions, date.Format(occurrenceKeyLayout))
+	}
+	return r, nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// occurrenceKeys - returns the keys of `starts`.
+func occurrenceKeys(starts []time.Time) []string {
+	keys := []string{}
+	for _, start := range starts {
+		keys = append(keys, start.Format(occurrenceKeyLayout))
+	}
+	return keys
+}
+
+// recurringEvent - returns an event at 6 PM New York time on `date` that
+// repeats as `r` describes.
+func recurringEvent(t *testing.T, date string, r *Recurrence) Event {
+	t.Helper()
+	newYork, _ := loadTimeZone("America/New_York")
+	start, err := time.ParseInLocation("2006-01-02 15:04", date+" 18:00", newYork)
+	if err != nil {
+		t.Fatalf("parsing %q: %v", date, err)
+	}
+	return Event{Date: start, TimeZone: "America/New_York", Recurrence: r}
+}
+
+func TestOccurrenceStarts(t *testing.T) {
+	tests := []struct {
+		name  string
+		date  string
+		r     *Recurrence
+		from  string
+		to    string
+		limit int
+		want  []string
+	}{
+		{name: "once", date: "2030-01-07", from: "2030-01-01", to: "2030-02-01", want: []string{"2030-01-07"}},
+		{name: "once, outside the window", date: "2030-01-07", from: "2030-01-08", to: "2030-02-01", want: []string{}},
+		{name: "daily", date: "2030-01-07", r: &Recurrence{Freq: recurDaily, Interval: 1}, from: "2030-01-01", to: "2030-01-11", want: []string{"2030-01-07", "2030-01-08", "2030-01-09", "2030-01-10"}},
+		{name: "every other day", date: "2030-01-07", r: &Recurrence{Freq: recurDaily, Interval: 2}, from: "2030-01-01", to: "2030-01-14", want: []string{"2030-01-07", "2030-01-09", "2030-01-11", "2030-01-13"}},
+		{name: "weekly from later on", date: "2030-01-07", r: &Recurrence{Freq: recurWeekly, Interval: 1}, from: "2030-01-20", to: "2030-02-05", want: []string{"2030-01-21", "2030-01-28", "2030-02-04"}},
+		{name: "monthly skips short months", date: "2030-01-31", r: &Recurrence{Freq: recurMonthly, Interval: 1}, from: "2030-01-01", to: "2030-06-01", want: []string{"2030-01-31", "2030-03-31", "2030-05-31"}},
+		{name: "count", date: "2030-01-07", r: &Recurrence{Freq: recurWeekly, Interval: 1, Count: 3}, from: "2030-01-01", to: "2031-01-01", want: []string{"2030-01-07", "2030-01-14", "2030-01-21"}},
+		{name: "count includes exceptions", date: "2030-01-07", r: &Recurrence{Freq: recurWeekly, Interval: 1, Count: 3, Exceptions: []string{"2030-01-14"}}, from: "2030-01-01", to: "2031-01-01", want: []string{"2030-01-07", "2030-01-21"}},
+		{name: "until", date: "2030-01-07", r: &Recurrence{Freq: recurWeekly, Interval: 1, Until: "2030-01-21"}, from: "2030-01-01", to: "2031-01-01", want: []string{"2030-01-07", "2030-01-14", "2030-01-21"}},
+		{name: "far in the future", date: "2030-01-07", r: &Recurrence{Freq: recurDaily, Interval: 1}, from: "2100-01-01", to: "2100-01-03", want: []string{"2100-01-01", "2100-01-02"}},
+		{name: "monthly far in the future", date: "2030-01-31", r: &Recurrence{Freq: recurMonthly, Interval: 1}, from: "2100-01-01", to: "2100-04-01", want: []string{"2100-01-31", "2100-03-31"}},
+		{name: "count from later on", date: "2030-01-31", r: &Recurrence{Freq: recurMonthly, Interval: 1, Count: 3}, from: "2030-04-01", to: "2031-01-01", want: []string{"2030-05-31"}},
+		{name: "count ended", date: "2030-01-07", r: &Recurrence{Freq: recurWeekly, Interval: 2, Count: 3}, from: "2031-01-01", to: "2032-01-01", want: []string{}},
+		{name: "limit", date: "2030-01-07", r: &Recurrence{Freq: recurDaily, Interval: 1}, from: "2030-01-01", to: "2031-01-01", limit: 2, want: []string{"2030-01-07", "2030-01-08"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event := recurringEvent(t, test.date, test.r)
+			from, _ := time.ParseInLocation(occurrenceKeyLayout, test.from, event.location())
+			to, _ := time.ParseInLocation(occurrenceKeyLayout, test.to, event.location())
+			if got := occurrenceKeys(event.occurrenceStarts(from, to, test.limit)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("occurrences = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOccurrencesKeepWallClockTime(t *testing.T) {
+	// New York moves its clocks forward on March 10, 2030
+	event := recurringEvent(t, "2030-03-04", &Recurrence{Freq: recurWeekly, Interval: 1, Count: 2})
+	starts := event.occurrenceStarts(event.Date, event.Date.AddDate(0, 1, 0), 0)
+	if len(starts) != 2 {
+		t.Fatalf("%d occurrences, want 2", len(starts))
+	}
+	for _, start := range starts {
+		if start.Hour() != 18 {
+			t.Errorf("occurrence on %s starts at %s, want 18:00", start.Format(occurrenceKeyLayout), start.Format("15:04"))
+		}
+	}
+	if got := starts[1].Sub(starts[0]); got != 7*24*time.Hour-time.Hour {
+		t.Errorf("occurrences are %v apart across the change, want one hour less than a week", got)
+	}
+}
+
+func TestFindOccurrence(t *testing.T) {
+	event := recurringEvent(t, "2030-01-07", &Recurrence{Freq: recurWeekly, Interval: 1, Count: 4, Exceptions: []string{"2030-01-14"}})
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{key: "2030-01-07", want: true},
+		{key: "2030-01-21", want: true},
+		{key: "2030-01-08"},
+		{key: "2030-01-14"},
+		{key: "2030-02-04"},
+		{key: "2029-12-31"},
+		{key: "not a date"},
+	}
+	for _, test := range tests {
+		start, found := event.findOccurrence(test.key)
+		if found != test.want {
+			t.Errorf("findOccurrence(%q) found = %v, want %v", test.key, found, test.want)
+		}
+		if found && (start.Format(occurrenceKeyLayout) != test.key || start.Hour() != 18) {
+			t.Errorf("findOccurrence(%q) = %v", test.key, start)
+		}
+	}
+}
+
+func TestParseRecurrence(t *testing.T) {
+	event := recurringEvent(t, "2030-01-07", nil)
+	tests := []struct {
+		name    string
+		fields  recurrenceFields
+		want    *Recurrence
+		wantErr bool
+	}{
+		{name: "none", fields: recurrenceFields{Freq: "none"}},
+		{name: "weekly", fields: recurrenceFields{Freq: recurWeekly}, want: &Recurrence{Freq: recurWeekly, Interval: 1}},
+		{name: "exceptions", fields: recurrenceFields{Freq: recurDaily, Interval: 2, Count: 5, Exceptions: []string{" 2030-01-09 ", ""}}, want: &Recurrence{Freq: recurDaily, Interval: 2, Count: 5, Exceptions: []string{"2030-01-09"}}},
+		{name: "yearly", fields: recurrenceFields{Freq: "yearly"}, wantErr: true},
+		{name: "negative interval", fields: recurrenceFields{Freq: recurDaily, Interval: -1}, wantErr: true},
+		{name: "until before the start", fields: recurrenceFields{Freq: recurDaily, Until: "2030-01-06"}, wantErr: true},
+		{name: "until and count", fields: recurrenceFields{Freq: recurDaily, Until: "2030-02-01", Count: 3}, wantErr: true},
+		{name: "malformed exception", fields: recurrenceFields{Freq: recurDaily, Exceptions: []string{"Jan 9"}}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, fieldErr := test.fields.parse(event)
+			if (fieldErr != nil) != test.wantErr {
+				t.Fatalf("parse error = %v, want error: %v", fieldErr, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parse = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestICalRecurrence(t *testing.T) {
+	tests := []struct {
+		name        string
+		r           *Recurrence
+		wantRRule   string
+		wantExDates string
+	}{
+		{name: "weekly", r: &Recurrence{Freq: recurWeekly, Interval: 1}, wantRRule: "FREQ=WEEKLY"},
+		{name: "interval and count", r: &Recurrence{Freq: recurDaily, Interval: 2, Count: 5}, wantRRule: "FREQ=DAILY;INTERVAL=2;COUNT=5"},
+		{name: "until", r: &Recurrence{Freq: recurMonthly, Interval: 1, Until: "2030-06-07"}, wantRRule: "FREQ=MONTHLY;UNTIL=20300608T035959Z"},
+		{
+			name:        "exceptions",
+			r:           &Recurrence{Freq: recurWeekly, Interval: 1, Exceptions: []string{"2030-01-14", "2030-07-15"}},
+			wantRRule:   "FREQ=WEEKLY",
+			wantExDates: "20300114T180000,20300715T180000",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event := recurringEvent(t, "2030-01-07", test.r)
+			if got := icalRRule(event); got != test.wantRRule {
+				t.Errorf("icalRRule = %q, want %q", got, test.wantRRule)
+			}
+			if got := icalExDates(event); got != test.wantExDates {
+				t.Errorf("icalExDates = %q, want %q", got, test.wantExDates)
+			}
+
+			var buf bytes.Buffer
+			testServer.writeICalendar(&buf, "Series", []Event{event})
+			lines := unfoldICalendar(buf.String())
+			if got, _ := icalProperty(lines, "RRULE"); got != test.wantRRule {
+				t.Errorf("RRULE = %q, want %q", got, test.wantRRule)
+			}
+			if got, _ := icalProperty(lines, "EXDATE;TZID=America/New_York"); got != test.wantExDates {
+				t.Errorf("EXDATE = %q, want %q", got, test.wantExDates)
+			}
+			// The series repeats at 6 PM local time, summer and winter
+			if got, _ := icalProperty(lines, "DTSTART;TZID=America/New_York"); got != "20300107T180000" {
+				t.Errorf("DTSTART = %q, want 20300107T180000 in New York", got)
+			}
+			if got, _ := icalProperty(lines, "TZID"); got != "America/New_York" {
+				t.Errorf("VTIMEZONE TZID = %q, want America/New_York", got)
+			}
+		})
+	}
+}
+
+func TestOccurrenceRSVPs(t *testing.T) {
+	event := recurringEvent(t, "2030-01-07", &Recurrence{Freq: recurWeekly, Interval: 1, Count: 3})
+	event.Title = "Weekly party"
+	id := mustAddEvent(t, event)
+	rsvps := []struct {
+		email      string
+		occurrence string
+	}{
+		{email: "series@yale.edu"},
+		{email: "once@yale.edu", occurrence: "2030-01-14"},
+	}
+	for _, r := range rsvps {
+		rsvp, err := testServer.store.AddRSVP(id, Attendee{Email: r.email}, r.occurrence, testConfirmationCode(t, testServer.store, id, r.email))
+		if err != nil {
+			t.Fatalf("testServer.store.AddRSVP(%q, %q): %v", r.email, r.occurrence, err)
+		}
+		if _, _, err := testServer.store.VerifyRSVP(id, rsvp.VerifyToken); err != nil {
+			t.Fatalf("VerifyRSVP: %v", err)
+		}
+	}
+
+	path := "/api" + eventPath(t, id) + "/rsvp"
+	expectStatus(t, serve(t, http.MethodPost, path, `{"email":"never@yale.edu","name":"Nev","occurrence":"2030-01-08"}`), http.StatusUnprocessableEntity)
+
+	event = mustGetEvent(t, id)
+	event.listOccurrences(event.Date, event.Date.AddDate(0, 1, 0), 0)
+	want := map[string][]string{
+		"2030-01-07": {"series@yale.edu"},
+		"2030-01-14": {"once@yale.edu", "series@yale.edu"},
+		"2030-01-21": {"series@yale.edu"},
+	}
+	if len(event.Occurrences) != len(want) {
+		t.Fatalf("%d occurrences, want %d", len(event.Occurrences), len(want))
+	}
+	for _, occurrence := range event.Occurrences {
+		if !reflect.DeepEqual(attendeeEmails(occurrence.Attending), want[occurrence.Key]) {
+			t.Errorf("attending %s = %v, want %v", occurrence.Key, occurrence.Attending, want[occurrence.Key])
+		}
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
-func createRoutes() chi.Router {
+func createRoutes(s *server) chi.Router {
 	// We're using chi as the router. You'll want to read
 	// the documentation https://github.com/go-chi/chi
 	// so that you can capture parameters like /events/5
@@ -12,22 +12,58 @@ func createRoutes() chi.Router {
 	// event id (5 and 4, respectively).
 
 	r := chi.NewRouter()
-	r.Get("/", indexController)
+	r.Use(s.recoverer)
+	r.Get("/", s.indexController)
 	addStaticFileServer(r, "/static/", "staticfiles")
 
-	r.Get("/events/new", createEventController)
-	r.Post("/events/new", createEventController)
+	r.Get("/events/new", s.createEventController)
+	r.Post("/events/new", s.createEventController)
 
-	r.Get("/events/{id}", accessEventController)
-	r.Post("/events/{id}", accessEventController)
-	//r.Post("/events/{id}/rsvp", rsvpController)
+	r.Get("/calendar.ics", s.calendarController)
 
-	r.Get("/events/{id}/donate", donateController)
+	r.Get("/about", s.aboutController)
 
-	r.Get("/about", aboutController)
+	r.Get("/signup", s.signupController)
+	r.Post("/signup", s.signupController)
+	r.Get("/login", s.loginController)
+	r.Post("/login", s.loginController)
+	r.Post("/logout", s.logoutController)
+	r.Get("/me", s.meController)
+	r.Post("/me/verify-email", s.resendEmailVerificationController)
+	r.Get("/verify-email", s.verifyEmailController)
 
-	r.Get("/api/events", apiController)
-	r.Get("/api/events/{id}", apiController)
+	r.Get("/api/events", s.apiListEventsController)
+	r.Post("/api/events", s.apiCreateEventController)
+
+	// Routes that name an event by its public ID in {id}
+	r.Group(func(r chi.Router) {
+		r.Use(s.redirectEventURLs)
+
+		r.Get("/events/{id}.ics", s.eventICSController)
+		r.Get("/events/{id}", s.accessEventController)
+		r.Post("/events/{id}", s.accessEventController)
+		//r.Post("/events/{id}/rsvp", rsvpController)
+		r.Get("/events/{id}/verify", s.verifyRSVPController)
+		r.Get("/events/{id}/cancel", s.cancelRSVPController)
+		r.Post("/events/{id}/cancel", s.cancelRSVPController)
+
+		r.Get("/events/{id}/edit", s.editEventController)
+		r.Post("/events/{id}/edit", s.editEventController)
+		r.Get("/events/{id}/delete", s.deleteEventController)
+		r.Post("/events/{id}/delete", s.deleteEventController)
+
+		r.Get("/events/{id}/donate", s.donateController)
+
+		r.Get("/events/{id}/attendees.csv", s.attendeeExportController("csv"))
+		r.Get("/events/{id}/attendees.json", s.attendeeExportController("json"))
+
+		r.Get("/api/events/{id}", s.apiController)
+		r.Put("/api/events/{id}", s.apiUpdateEventController)
+		r.Patch("/api/events/{id}", s.apiUpdateEventController)
+		r.Delete("/api/events/{id}", s.apiDeleteEventController)
+		r.Post("/api/events/{id}/rsvp", s.apiRSVPController)
+		r.Delete("/api/events/{id}/rsvp", s.apiCancelRSVPController)
+	})
 
 	return r
 }
@@ -1,8 +1,16 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func getEnv(key string, fallback string) string {
@@ -12,8 +20,173 @@ func getEnv(key string, fallback string) string {
 	return fallback
 }
 
+// server - what the handlers need to serve a request. Handlers are methods
+// on it, so they reach events and users through `store` rather than a
+// global database.
+type server struct {
+	store  Store
+	mailer Mailer
+
+	// templates are the parsed pages, by the names in templateFiles.
+	templates map[string]*template.Template
+
+	// baseURL is the address of the server as seen by the people it
+	// emails, used to build the links in those emails.
+	baseURL string
+
+	// icalDomain is the right-hand side of the UIDs given to events in
+	// iCalendar files. It must not change once calendars have subscribed,
+	// or every event would be duplicated.
+	icalDomain string
+
+	// confirmationKey is the secret that confirmation codes are derived
+	// from; see loadConfirmationKey.
+	confirmationKey []byte
+
+	// defaults apply to events that do not set their own time zone or
+	// email policy.
+	defaults eventDefaults
+}
+
+// eventDefaults - the time zone and email policy of events that do not
+// set their own.
+type eventDefaults struct {
+	TimeZone     string
+	EmailPolicy  string
+	EmailDomains []string
+}
+
+// location - returns the default time zone, or UTC if it cannot be
+// loaded.
+func (d eventDefaults) location() *time.Location {
+	if loc, ok := loadTimeZone(d.TimeZone); ok {
+		return loc
+	}
+	return time.UTC
+}
+
+// config - how the server is started. Each setting comes from a flag,
+// falling back to an environment variable and then a default.
+type config struct {
+	Port    string
+	Store   string
+	DBPath  string
+	Seed    bool
+	BaseURL string
+
+	Defaults eventDefaults
+}
+
+// loadConfig - reads the configuration from the command line `args`
+// (without the program name) and the environment. The arguments left
+// after the flags, e.g. a `migrate` command, are returned with it.
+func loadConfig(args []string, output io.Writer) (config, []string, error) {
+	seed, err := strconv.ParseBool(getEnv("SEED", "true"))
+	if err != nil {
+		return config{}, nil, fmt.Errorf("invalid SEED %q: %w", getEnv("SEED", ""), err)
+	}
+
+	var c config
+	flags := flag.NewFlagSet("classproject", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.StringVar(&c.Port, "port", getEnv("PORT", "8080"), "port to listen on (PORT)")
+	flags.StringVar(&c.Store, "store", getEnv("STORE", "sqlite"), `where to keep data: "sqlite" or "memory" (STORE)`)
+	flags.StringVar(&c.DBPath, "db", getEnv("DB_PATH", "./events.db"), `path of the SQLite database, or ":memory:" (DB_PATH)`)
+	flags.BoolVar(&c.Seed, "seed", seed, "add example events to an empty store (SEED)")
+	flags.StringVar(&c.BaseURL, "base-url", getEnv("BASE_URL", ""), "address of the server used in emailed links (BASE_URL, default http://localhost:PORT)")
+	flags.StringVar(&c.Defaults.TimeZone, "time-zone", getEnv("DEFAULT_TIMEZONE", "America/New_York"), "IANA time zone of events that do not set their own (DEFAULT_TIMEZONE)")
+	flags.StringVar(&c.Defaults.EmailPolicy, "email-policy", getEnv("DEFAULT_EMAIL_POLICY", emailPolicyAllowlist), `who may RSVP to events that do not say: "open", "allowlist" or "blocklist" (DEFAULT_EMAIL_POLICY)`)
+	emailDomains := flags.String("email-domains", getEnv("DEFAULT_EMAIL_DOMAINS", "yale.edu"), "comma-separated domains of the default email policy (DEFAULT_EMAIL_DOMAINS)")
+	if err := flags.Parse(args); err != nil {
+		return config{}, nil, err
+	}
+
+	if c.Store != "sqlite" && c.Store != "memory" {
+		return config{}, nil, fmt.Errorf("invalid store %q: must be \"sqlite\" or \"memory\"", c.Store)
+	}
+	if _, ok := loadTimeZone(c.Defaults.TimeZone); !ok {
+		return config{}, nil, fmt.Errorf("invalid time zone %q: must be an IANA time zone such as America/New_York", c.Defaults.TimeZone)
+	}
+	if !isValidEmailPolicy(c.Defaults.EmailPolicy) {
+		return config{}, nil, fmt.Errorf("invalid email policy %q: must be \"open\", \"allowlist\" or \"blocklist\"", c.Defaults.EmailPolicy)
+	}
+	c.Defaults.EmailDomains = parseEmailDomains(*emailDomains)
+	if c.BaseURL == "" {
+		c.BaseURL = "http://localhost:" + c.Port
+	}
+	c.BaseURL = strings.TrimSuffix(c.BaseURL, "/")
+	return c, flags.Args(), nil
+}
+
+// openStore - opens the store chosen by `c`: the SQLite database at
+// c.DBPath, migrated to the latest schema, or one in memory that is lost
+// on exit. The returned function releases it.
+func openStore(c config) (Store, func() error, error) {
+	if c.Store == "memory" {
+		return newMemoryStore(), func() error { return nil }, nil
+	}
+	db, err := initDB(c.DBPath, c.Defaults.TimeZone)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &sqliteStore{db: db}, db.Close, nil
+}
+
 func main() {
-	defer db.Close()
-	r := createRoutes()
-	http.ListenAndServe(":"+getEnv("PORT", "8080"), r)
+	c, args, err := loadConfig(os.Args[1:], os.Stderr)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// `migrate up`, `migrate down [steps]` and `migrate status` manage the
+	// schema of the database instead of starting the server
+	if len(args) > 0 && args[0] == "migrate" {
+		db, err := openDB(c.DBPath)
+		if err != nil {
+			log.Fatalf("could not open the database: %v", err)
+		}
+		defer db.Close()
+		if err := runMigrateCommand(db, schemaMigrations(c.Defaults.TimeZone), args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(args) > 0 {
+		log.Fatalf("unknown command %q", args[0])
+	}
+
+	templates, err := loadTemplates("templates", c.Defaults)
+	if err != nil {
+		log.Fatalf("could not load the templates: %v", err)
+	}
+	mailer, err := newMailerFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store, closeStore, err := openStore(c)
+	if err != nil {
+		log.Fatalf("could not open the store: %v", err)
+	}
+	defer closeStore()
+	if c.Seed {
+		if err := seedEvents(store); err != nil {
+			log.Fatalf("could not add the example events: %v", err)
+		}
+	}
+
+	s := &server{
+		store:           store,
+		mailer:          mailer,
+		templates:       templates,
+		baseURL:         c.BaseURL,
+		icalDomain:      getEnv("ICAL_DOMAIN", icalDomainFromURL(c.BaseURL)),
+		confirmationKey: loadConfirmationKey(),
+		defaults:        c.Defaults,
+	}
+	r := createRoutes(s)
+	http.ListenAndServe(":"+c.Port, r)
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	yale := eventDefaults{TimeZone: "America/New_York", EmailPolicy: emailPolicyAllowlist, EmailDomains: []string{"yale.edu"}}
+	tests := []struct {
+		name     string
+		env      map[string]string
+		args     []string
+		want     config
+		wantArgs []string
+		wantErr  bool
+	}{
+		{
+			name: "defaults",
+			want: config{Port: "8080", Store: "sqlite", DBPath: "./events.db", Seed: true, BaseURL: "http://localhost:8080", Defaults: yale},
+		},
+		{
+			name: "environment",
+			env: map[string]string{
+				"PORT": "9000", "STORE": "memory", "SEED": "false", "BASE_URL": "https://events.example.com/",
+				"DEFAULT_TIMEZONE": "Europe/Paris", "DEFAULT_EMAIL_POLICY": emailPolicyBlocklist, "DEFAULT_EMAIL_DOMAINS": "gmail.com, @Example.com",
+			},
+			want: config{
+				Port: "9000", Store: "memory", DBPath: "./events.db", BaseURL: "https://events.example.com",
+				Defaults: eventDefaults{TimeZone: "Europe/Paris", EmailPolicy: emailPolicyBlocklist, EmailDomains: []string{"gmail.com", "example.com"}},
+			},
+		},
+		{
+			name:     "flags beat the environment",
+			env:      map[string]string{"PORT": "9000", "DB_PATH": "env.db"},
+			args:     []string{"-port", "9100", "-db", ":memory:", "migrate", "status"},
+			want:     config{Port: "9100", Store: "sqlite", DBPath: ":memory:", Seed: true, BaseURL: "http://localhost:9100", Defaults: yale},
+			wantArgs: []string{"migrate", "status"},
+		},
+		{name: "unknown store", args: []string{"-store", "postgres"}, wantErr: true},
+		{name: "malformed SEED", env: map[string]string{"SEED": "sometimes"}, wantErr: true},
+		{name: "unknown time zone", env: map[string]string{"DEFAULT_TIMEZONE": "Europe/Atlantis"}, wantErr: true},
+		{name: "server's local time zone", args: []string{"-time-zone", "Local"}, wantErr: true},
+		{name: "unknown email policy", env: map[string]string{"DEFAULT_EMAIL_POLICY": "yale-only"}, wantErr: true},
+		{name: "email policy in the wrong case", args: []string{"-email-policy", "Open"}, wantErr: true},
+		{name: "unknown flag", args: []string{"-verbose"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, key := range []string{"PORT", "STORE", "DB_PATH", "SEED", "BASE_URL", "DEFAULT_TIMEZONE", "DEFAULT_EMAIL_POLICY", "DEFAULT_EMAIL_DOMAINS"} {
+				// Setenv restores the variable after the test, even if it
+				// is then unset
+				t.Setenv(key, test.env[key])
+				if _, set := test.env[key]; !set {
+					os.Unsetenv(key)
+				}
+			}
+			got, args, err := loadConfig(test.args, io.Discard)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("loadConfig error = %v, want error: %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("config = %+v, want %+v", got, test.want)
+			}
+			if len(args) > 0 || len(test.wantArgs) > 0 {
+				if !reflect.DeepEqual(args, test.wantArgs) {
+					t.Errorf("args = %v, want %v", args, test.wantArgs)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// maxSlugLength is the longest a slug gets before any collision suffix.
+const maxSlugLength = 60
+
+// slugify - turns `title` into the readable part of an event's URLs:
+// lowercase letters and digits with a hyphen between words, e.g. "BBQ for
+// managers!" becomes "bbq-for-managers". Slugs are never all digits, so
+// they cannot be mistaken for the numbers events used to be addressed by.
+func slugify(title string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, c := range strings.ToLower(title) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(c)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+	slug := b.String()
+
+	if len(slug) > maxSlugLength {
+		slug = slug[:maxSlugLength]
+		if i := strings.LastIndexByte(slug, '-'); i > 0 {
+			slug = slug[:i]
+		}
+	}
+	if slug == "" {
+		return "event"
+	}
+	if strings.Trim(slug, "0123456789") == "" {
+		return "event-" + slug
+	}
+	return slug
+}
+
+// uniqueSlug - returns the slug of `title`, followed by -2, -3 and so on if
+// `taken` reports that it is already used by another event.
+func uniqueSlug(title string, taken func(slug string) (bool, error)) (string, error) {
+	base := slugify(title)
+	slug := base
+	for n := 2; ; n++ {
+		used, err := taken(slug)
+		if err != nil {
+			return "", err
+		}
+		if !used {
+			return slug, nil
+		}
+		slug = base + "-" + strconv.Itoa(n)
+	}
+}
+
+// unusedSlug - returns a slug for `title` that no event in the database
+// has yet, as its slug or as its public ID, which would shadow it in URLs.
+func unusedSlug(tx *sql.Tx, title string) (string, error) {
+	return uniqueSlug(title, func(slug string) (bool, error) {
+		var taken bool
+		err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM Event WHERE Slug = ? OR PublicID = ?)", slug, slug).Scan(&taken)
+		return taken, err
+	})
+}
+
+// fillSlugs - gives each event saved before slugs one, in the order they
+// were created, so the oldest keeps the slug without a suffix.
+func fillSlugs(tx *sql.Tx) error {
+	type unslugged struct {
+		id    int
+		title string
+	}
+	rows, err := tx.Query("SELECT ID, Title FROM Event WHERE Slug IS NULL ORDER BY ID")
+	if err != nil {
+		return err
+	}
+	var events []unslugged
+	for rows.Next() {
+		var event unslugged
+		if err := rows.Scan(&event.id, &event.title); err != nil {
+			rows.Close()
+			return err
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		slug, err := unusedSlug(tx, event.title)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE Event SET Slug = ? WHERE ID = ?", slug, event.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PathID - returns what names the event in the URLs of its pages: its
+// public ID followed by its slug, e.g. "k3xq7vnb-bbq-for-managers". The
+// public ID alone, or the slug alone, lead to the same pages, and the
+// public ID followed by any other slug redirects to them.
+func (event Event) PathID() string {
+	if event.Slug == "" {
+		return event.PublicID
+	}
+	return event.PublicID + "-" + event.Slug
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{title: "BBQ for managers!", want: "bbq-for-managers"},
+		{title: "  SOM -- House   Party  ", want: "som-house-party"},
+		{title: "Café & crêpes", want: "caf-cr-pes"},
+		{title: "🎉🎉", want: "event"},
+		{title: "2030", want: "event-2030"},
+		{title: "Class of 2030", want: "class-of-2030"},
+		{title: strings.Repeat("party ", 20), want: strings.TrimSuffix(strings.Repeat("party-", 10), "-")},
+	}
+	for _, test := range tests {
+		if got := slugify(test.title); got != test.want {
+			t.Errorf("slugify(%q) = %q, want %q", test.title, got, test.want)
+		}
+	}
+}
+
+func TestUniqueSlug(t *testing.T) {
+	taken := map[string]bool{"party": true, "party-2": true}
+	got, err := uniqueSlug("Party", func(slug string) (bool, error) { return taken[slug], nil })
+	if err != nil || got != "party-3" {
+		t.Errorf("uniqueSlug = %q, %v, want party-3", got, err)
+	}
+}
+
+func TestSlugURLs(t *testing.T) {
+	first := mustGetEvent(t, mustAddEvent(t, Event{Title: "Slug party", Date: time.Now().AddDate(1, 0, 0)}))
+	second := mustGetEvent(t, mustAddEvent(t, Event{Title: "Slug party", Date: time.Now().AddDate(1, 0, 0)}))
+	if first.Slug == second.Slug {
+		t.Fatalf("two events share the slug %q", first.Slug)
+	}
+	if want := first.PublicID + "-" + first.Slug; first.PathID() != want {
+		t.Errorf("PathID = %q, want %q", first.PathID(), want)
+	}
+
+	for _, path := range []string{
+		"/events/" + first.PathID(),
+		"/events/" + first.PublicID,
+		"/events/" + first.Slug,
+		"/api/events/" + first.PathID(),
+	} {
+		w := serve(t, http.MethodGet, path, "")
+		expectStatus(t, w, http.StatusOK)
+		if strings.Contains(w.Body.String(), second.PublicID) {
+			t.Errorf("%s shows the other event with the same title", path)
+		}
+	}
+	expectStatus(t, serve(t, http.MethodGet, "/events/no-such-party", ""), http.StatusNotFound)
+
+	// The public ID with a slug that is not the event's own, even another
+	// event's, redirects to the event's own path
+	for _, slug := range []string{"no-such-party", second.Slug} {
+		w := serve(t, http.MethodGet, "/events/"+first.PublicID+"-"+slug+"?token=abc", "")
+		expectStatus(t, w, http.StatusMovedPermanently)
+		if got, want := w.Header().Get("Location"), "/events/"+first.PathID()+"?token=abc"; got != want {
+			t.Errorf("Location = %q, want %q", got, want)
+		}
+	}
+
+	// A title that is another event's public ID does not get it as its slug
+	lookalike := mustGetEvent(t, mustAddEvent(t, Event{Title: first.PublicID, Date: time.Now().AddDate(1, 0, 0)}))
+	if lookalike.Slug == first.PublicID {
+		t.Errorf("slug %q is the public ID of another event", lookalike.Slug)
+	}
+	w := serve(t, http.MethodGet, "/events/"+lookalike.Slug, "")
+	expectStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), lookalike.PublicID) {
+		t.Errorf("/events/%s does not show the event with that slug", lookalike.Slug)
+	}
+
+	w = serve(t, http.MethodGet, "/events/"+first.PathID()+".ics", "")
+	expectStatus(t, w, http.StatusOK)
+	if got, want := w.Header().Get("Content-Disposition"), `filename="`+first.Slug+`.ics"`; !strings.Contains(got, want) {
+		t.Errorf("Content-Disposition = %q, want it to contain %q", got, want)
+	}
+}
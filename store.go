@@ -0,0 +1,185 @@
+package main
+
+import (
+	"time"
+)
+
+// EventStore - where events and their RSVPs are kept. sqliteStore keeps
+// them in the SQLite database and memoryStore in memory, e.g. for tests.
+type EventStore interface {
+	// GetEvent returns the event with the specified id along with its
+	// attendees, pending RSVPs and waitlist, and a boolean indicating
+	// whether or not it was found.
+	GetEvent(id int) (Event, bool, error)
+	// EventIDByPublicID returns the ID of the event whose public ID, the
+	// one in its URLs, is `publicID`, and whether there is one.
+	EventIDByPublicID(publicID string) (int, bool, error)
+	// EventIDBySlug returns the ID of the event whose slug is `slug`, and
+	// whether there is one.
+	EventIDBySlug(slug string) (int, bool, error)
+	// LegacyEventPathID returns the Event.PathID of the event that had the
+	// number `id` in its URLs before events had public IDs, and whether
+	// there is one.
+	LegacyEventPathID(id int) (string, bool, error)
+	// ListEvents returns a page of the events matching `q` and a cursor
+	// for the next page, or nil if this is the last.
+	ListEvents(q eventQuery) ([]Event, *eventCursor, error)
+	// EventsByOwner returns the events created by the user with the
+	// specified id, soonest first. Attendees are not loaded.
+	EventsByOwner(userID int) ([]Event, error)
+	// EventsAttendedBy returns the events that `email` has a confirmed
+	// RSVP to, soonest first. Attendees are not loaded.
+	EventsAttendedBy(email string) ([]Event, error)
+
+	// CreateEvent adds `event` and returns its ID. The ID, public ID and
+	// slug are assigned unless set. Its Attending list, if any, is added as
+	// already verified RSVPs.
+	CreateEvent(event Event) (int, error)
+	// UpdateEvent overwrites the editable fields of the event with the
+	// same ID as `event`, giving any new spots to the waitlist. Returns
+	// errEventNotFound if there is no such event.
+	UpdateEvent(event Event) error
+	// DeleteEvent removes the event with the specified id and its RSVPs.
+	// Returns errEventNotFound if there is no such event.
+	DeleteEvent(id int) error
+
+	// AddRSVP adds a pending RSVP of `attendee` to the event, or to its
+	// occurrence with key `occurrence`, putting it on the waitlist if the
+	// event is full. A new RSVP is issued confirmation code `code`. If the
+	// attendee had already RSVP-ed, their existing RSVP is returned, without
+	// a VerifyToken.
+	AddRSVP(eventID int, attendee Attendee, occurrence string, code string) (RSVP, error)
+	// VerifyRSVP confirms the pending RSVP whose verification token is
+	// `token`. Pending RSVPs take no spot, so it joins the waitlist if the
+	// event has filled up since. The boolean is false if there is none,
+	// e.g. because it expired.
+	VerifyRSVP(eventID int, token string) (RSVP, bool, error)
+	// CancelRSVP removes the RSVP of `email` to the event or occurrence,
+	// or takes them off the waitlist, handing any freed spot on. Returns
+	// false if they had not RSVP-ed.
+	CancelRSVP(eventID int, email string, occurrence string) (bool, error)
+	// ConfirmationCode returns the confirmation code of the RSVP of
+	// `email` to the event, and false if there is no such RSVP.
+	ConfirmationCode(eventID int, email string) (string, bool, error)
+	// EachAttendeeRecord calls `fn` with each RSVP to the event that is
+	// attending or pending verification, oldest first, and then with each
+	// person on its waitlist, first in line first, as it reads them.
+	// It stops at the first error `fn` returns and returns that error.
+	EachAttendeeRecord(eventID int, fn func(AttendeeRecord) error) error
+}
+
+// UserStore - where accounts and their login sessions are kept.
+type UserStore interface {
+	// CreateUser adds an account and returns it. Returns errEmailTaken if
+	// the email is already registered.
+	CreateUser(email string, passwordHash string) (User, error)
+	// UserByEmail returns the account registered with `email` and a
+	// boolean indicating whether or not it was found.
+	UserByEmail(email string) (User, bool, error)
+	// CreateSession starts a session for the user with the specified id
+	// that lasts until `expires`. Only the hash of its token is stored.
+	CreateSession(tokenHash string, userID int, expires time.Time) error
+	// SessionUser returns the user whose unexpired session has the token
+	// hash `tokenHash`, and a boolean indicating whether there was one.
+	SessionUser(tokenHash string) (User, bool, error)
+	// DeleteSession ends the session with the token hash `tokenHash`.
+	DeleteSession(tokenHash string) error
+	// SetEmailVerifyToken replaces the token that verifies the email of
+	// the user with the specified id. Only its hash is stored.
+	SetEmailVerifyToken(userID int, tokenHash string) error
+	// VerifyUserEmail marks the email of the user whose verification
+	// token has the hash `tokenHash` as verified. Returns false if there
+	// is no such user.
+	VerifyUserEmail(tokenHash string) (bool, error)
+}
+
+// Store - everything the server keeps.
+type Store interface {
+	EventStore
+	UserStore
+}
+
+// pageEvents - trims `events`, which were fetched with one more than the
+// limit of `q`, to a page, and returns the cursor for the next page, or
+// nil if there is none.
+func pageEvents(q eventQuery, events []Event) ([]Event, *eventCursor) {
+	if q.Limit <= 0 || len(events) <= q.Limit {
+		return events, nil
+	}
+	events = events[:q.Limit]
+	last := events[len(events)-1]
+	next := &eventCursor{Sort: q.Sort, Date: last.Date.UTC(), Title: last.Title, ID: last.ID}
+	if next.Sort == "" {
+		next.Sort = eventSortDate
+	}
+	return events, next
+}
+
+// seedEvents - adds a few example events if `store` has none yet.
+func seedEvents(store EventStore) error {
+	existing, _, err := store.ListEvents(eventQuery{Limit: 1})
+	if err != nil || len(existing) > 0 {
+		return err
+	}
+
+	newYorkTimeZone, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		return err
+	}
+
+	defaultEvents := []Event{
+		{
+			ID:       1,
+			Title:    "SOM House Party",
+			TimeZone: "America/New_York",
+			Date:     time.Date(2025, 10, 17, 16, 30, 0, 0, newYorkTimeZone),
+			Image:    "http://i.imgur.com/pXjrQ.gif",
+			Location: "Kyle's house",
+			Attending: []Attendee{
+				{Email: "kyle.jensen@yale.edu", DisplayName: "Kyle Jensen", Affiliation: "Yale SOM"},
+				{Email: "kim.kardashian@yale.edu", DisplayName: "Kim Kardashian"},
+			},
+		},
+		{
+			ID:       2,
+			Title:    "BBQ party for hackers and nerds",
+			TimeZone: "America/New_York",
+			Date:     time.Date(2025, 10, 19, 19, 0, 0, 0, newYorkTimeZone),
+			Image:    "http://i.imgur.com/7pe2k.gif",
+			Location: "Judy Chevalier's house",
+			Attending: []Attendee{
+				{Email: "kyle.jensen@yale.edu", DisplayName: "Kyle Jensen", Affiliation: "Yale SOM"},
+				{Email: "kim.kardashian@yale.edu", DisplayName: "Kim Kardashian"},
+			},
+		},
+		{
+			ID:        3,
+			Title:     "BBQ for managers",
+			TimeZone:  "America/New_York",
+			Date:      time.Date(2025, 12, 2, 18, 0, 0, 0, newYorkTimeZone),
+			Image:     "http://i.imgur.com/CJLrRqh.gif",
+			Location:  "Barry Nalebuff's house",
+			Attending: []Attendee{{Email: "kim.kardashian@yale.edu", DisplayName: "Kim Kardashian"}},
+		},
+		// Here I didn't include an even #4 just to show that
+		// events in a real system might be deleted and so you
+		// would need to handle such cases. Eg. if somebody
+		// tries to get event #4, you would typically return
+		// a 404 error which means "not found".
+		{
+			ID:        5,
+			Title:     "Cooking lessons for the busy business student",
+			TimeZone:  "America/New_York",
+			Date:      time.Date(2025, 12, 21, 19, 0, 0, 0, newYorkTimeZone),
+			Image:     "http://i.imgur.com/02KT9.gif",
+			Location:  "Yale Farm",
+			Attending: []Attendee{{Email: "homer.simpson@yale.edu", DisplayName: "Homer Simpson"}},
+		},
+	}
+	for _, event := range defaultEvents {
+		if _, err := store.CreateEvent(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
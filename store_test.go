@@ -0,0 +1,524 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testStore - a Store under test, with a way to make the pending RSVPs to
+// an event older than pendingRSVPLifetime.
+type testStore struct {
+	name   string
+	store  Store
+	expire func(t *testing.T, eventID int)
+}
+
+// newTestStores - returns an empty sqliteStore, on a database in a
+// temporary directory, and an empty memoryStore, so each test can run the same cases
+// against both.
+func newTestStores(t *testing.T) []testStore {
+	t.Helper()
+	db, err := initDB(filepath.Join(t.TempDir(), "events.db"), testDefaults.TimeZone)
+	if err != nil {
+		t.Fatalf("initDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	expired := time.Now().UTC().Add(-pendingRSVPLifetime - time.Hour)
+	memory := newMemoryStore()
+	return []testStore{
+		{
+			name:  "sqlite",
+			store: &sqliteStore{db: db},
+			expire: func(t *testing.T, eventID int) {
+				for _, table := range []string{"Event_Attendee", "Waitlist"} {
+					if _, err := db.Exec("UPDATE "+table+" SET CreatedAt = ? WHERE EventID = ? AND Confirmed = 0", expired, eventID); err != nil {
+						t.Fatalf("expiring RSVPs: %v", err)
+					}
+				}
+			},
+		},
+		{
+			name:  "memory",
+			store: memory,
+			expire: func(t *testing.T, eventID int) {
+				memory.mu.Lock()
+				defer memory.mu.Unlock()
+				for _, list := range [][]*memoryRSVP{memory.events[eventID].attending, memory.events[eventID].waitlist} {
+					for _, rsvp := range list {
+						if !rsvp.confirmed {
+							rsvp.createdAt = expired
+						}
+					}
+				}
+			},
+		},
+	}
+}
+
+// forEachStore - runs `test` as a subtest against each of the stores.
+func forEachStore(t *testing.T, test func(t *testing.T, ts testStore)) {
+	for _, ts := range newTestStores(t) {
+		ts := ts
+		t.Run(ts.name, func(t *testing.T) { test(t, ts) })
+	}
+}
+
+// createTestEvent - adds `event` to `store`, filling in what every event
+// needs, and returns its ID.
+func createTestEvent(t *testing.T, store Store, event Event) int {
+	t.Helper()
+	if event.Title == "" {
+		event.Title = "Test event"
+	}
+	if event.Date.IsZero() {
+		event.Date = time.Date(2030, 1, 7, 18, 0, 0, 0, time.UTC)
+	}
+	event.Location = "Evans Hall"
+	event.Image = "http://i.imgur.com/pXjrQ.gif"
+	id, err := store.CreateEvent(event)
+	if err != nil {
+		t.Fatalf("CreateEvent: %v", err)
+	}
+	return id
+}
+
+// rsvpAndVerify - RSVPs `email` to the event or occurrence and follows the
+// verification link, returning the verified RSVP.
+func rsvpAndVerify(t *testing.T, store Store, eventID int, email string, occurrence string) RSVP {
+	t.Helper()
+	rsvp, err := store.AddRSVP(eventID, Attendee{Email: email, DisplayName: email}, occurrence, testConfirmationCode(t, store, eventID, email))
+	if err != nil {
+		t.Fatalf("AddRSVP(%s): %v", email, err)
+	}
+	verified, found, err := store.VerifyRSVP(eventID, rsvp.VerifyToken)
+	if err != nil || !found {
+		t.Fatalf("VerifyRSVP(%s) = %v, %v", email, found, err)
+	}
+	return verified
+}
+
+// attendeeEmails - returns the emails of `attendees`, sorted, as the
+// stores do not promise an order.
+func attendeeEmails(attendees []Attendee) []string {
+	emails := []string{}
+	for _, attendee := range attendees {
+		emails = append(emails, attendee.Email)
+	}
+	sort.Strings(emails)
+	return emails
+}
+
+// orEmpty - returns `s`, or an empty slice if it is nil, so results can
+// be compared with reflect.DeepEqual.
+func orEmpty(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func TestStoreCapacityAndWaitlist(t *testing.T) {
+	tests := []struct {
+		name          string
+		capacity      int
+		rsvps         []string
+		cancel        []string
+		wantAttending []string
+		wantWaitlist  []string
+	}{
+		{
+			name:          "no limit",
+			rsvps:         []string{"a@yale.edu", "b@yale.edu", "c@yale.edu"},
+			wantAttending: []string{"a@yale.edu", "b@yale.edu", "c@yale.edu"},
+			wantWaitlist:  []string{},
+		},
+		{
+			name:          "full event waitlists in order",
+			capacity:      2,
+			rsvps:         []string{"a@yale.edu", "b@yale.edu", "c@yale.edu", "d@yale.edu"},
+			wantAttending: []string{"a@yale.edu", "b@yale.edu"},
+			wantWaitlist:  []string{"c@yale.edu", "d@yale.edu"},
+		},
+		{
+			name:          "repeated RSVP takes one spot",
+			capacity:      2,
+			rsvps:         []string{"a@yale.edu", "a@yale.edu", "b@yale.edu"},
+			wantAttending: []string{"a@yale.edu", "b@yale.edu"},
+			wantWaitlist:  []string{},
+		},
+		{
+			name:          "cancelling promotes the first in line",
+			capacity:      2,
+			rsvps:         []string{"a@yale.edu", "b@yale.edu", "c@yale.edu", "d@yale.edu"},
+			cancel:        []string{"a@yale.edu"},
+			wantAttending: []string{"b@yale.edu", "c@yale.edu"},
+			wantWaitlist:  []string{"d@yale.edu"},
+		},
+		{
+			name:          "leaving the waitlist promotes no one",
+			capacity:      2,
+			rsvps:         []string{"a@yale.edu", "b@yale.edu", "c@yale.edu", "d@yale.edu"},
+			cancel:        []string{"c@yale.edu"},
+			wantAttending: []string{"a@yale.edu", "b@yale.edu"},
+			wantWaitlist:  []string{"d@yale.edu"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			forEachStore(t, func(t *testing.T, ts testStore) {
+				id := createTestEvent(t, ts.store, Event{Capacity: test.capacity})
+				for _, email := range test.rsvps {
+					rsvp, err := ts.store.AddRSVP(id, Attendee{Email: email}, "", testConfirmationCode(t, ts.store, id, email))
+					if err != nil {
+						t.Fatalf("AddRSVP(%s): %v", email, err)
+					}
+					// A repeated RSVP returns the first, which has no new link
+					if rsvp.VerifyToken != "" {
+						if _, found, err := ts.store.VerifyRSVP(id, rsvp.VerifyToken); err != nil || !found {
+							t.Fatalf("VerifyRSVP(%s) = %v, %v", email, found, err)
+						}
+					}
+				}
+				for _, email := range test.cancel {
+					if cancelled, err := ts.store.CancelRSVP(id, email, ""); err != nil || !cancelled {
+						t.Fatalf("CancelRSVP(%s) = %v, %v", email, cancelled, err)
+					}
+				}
+
+				event, found, err := ts.store.GetEvent(id)
+				if err != nil || !found {
+					t.Fatalf("GetEvent = %v, %v", found, err)
+				}
+				if got := attendeeEmails(event.Attending); !reflect.DeepEqual(got, test.wantAttending) {
+					t.Errorf("attending = %v, want %v", got, test.wantAttending)
+				}
+				if got := orEmpty(event.Waitlist); !reflect.DeepEqual(got, test.wantWaitlist) {
+					t.Errorf("waitlist = %v, want %v", got, test.wantWaitlist)
+				}
+			})
+		})
+	}
+}
+
+func TestStoreWaitlistPosition(t *testing.T) {
+	forEachStore(t, func(t *testing.T, ts testStore) {
+		id := createTestEvent(t, ts.store, Event{Capacity: 1})
+		rsvpAndVerify(t, ts.store, id, "a@yale.edu", "")
+		for i, email := range []string{"b@yale.edu", "c@yale.edu"} {
+			rsvp, err := ts.store.AddRSVP(id, Attendee{Email: email}, "", testConfirmationCode(t, ts.store, id, email))
+			if err != nil {
+				t.Fatalf("AddRSVP(%s): %v", email, err)
+			}
+			if rsvp.WaitlistPosition != i+1 {
+				t.Errorf("waitlist position of %s = %d, want %d", email, rsvp.WaitlistPosition, i+1)
+			}
+			if rsvp.Confirmed {
+				t.Errorf("RSVP of %s is confirmed before it was verified", email)
+			}
+		}
+	})
+}
+
+func TestStoreExpiredRSVPs(t *testing.T) {
+	forEachStore(t, func(t *testing.T, ts testStore) {
+		id := createTestEvent(t, ts.store, Event{Capacity: 1})
+		stale, err := ts.store.AddRSVP(id, Attendee{Email: "stale@yale.edu"}, "", testConfirmationCode(t, ts.store, id, "stale@yale.edu"))
+		if err != nil {
+			t.Fatalf("AddRSVP: %v", err)
+		}
+		// A pending RSVP holds no spot
+		if rsvp := rsvpAndVerify(t, ts.store, id, "waiting@yale.edu", ""); rsvp.WaitlistPosition != 0 {
+			t.Fatalf("waitlist position = %d, want 0", rsvp.WaitlistPosition)
+		}
+
+		ts.expire(t, id)
+
+		// The next RSVP drops the expired one
+		late, err := ts.store.AddRSVP(id, Attendee{Email: "late@yale.edu"}, "", testConfirmationCode(t, ts.store, id, "late@yale.edu"))
+		if err != nil {
+			t.Fatalf("AddRSVP: %v", err)
+		}
+		if late.WaitlistPosition != 1 {
+			t.Errorf("waitlist position of late RSVP = %d, want 1", late.WaitlistPosition)
+		}
+		if _, found, err := ts.store.VerifyRSVP(id, stale.VerifyToken); err != nil || found {
+			t.Errorf("VerifyRSVP of expired RSVP = %v, %v, want false", found, err)
+		}
+
+		event, _, err := ts.store.GetEvent(id)
+		if err != nil {
+			t.Fatalf("GetEvent: %v", err)
+		}
+		if got, want := attendeeEmails(event.Attending), []string{"waiting@yale.edu"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("attending = %v, want %v", got, want)
+		}
+		if got, want := orEmpty(event.Waitlist), []string{"late@yale.edu"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("waitlist = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestStoreOccurrenceRSVPs(t *testing.T) {
+	forEachStore(t, func(t *testing.T, ts testStore) {
+		id := createTestEvent(t, ts.store, Event{
+			Capacity:   2,
+			TimeZone:   "America/New_York",
+			Recurrence: &Recurrence{Freq: recurWeekly, Interval: 1, Count: 4},
+		})
+		const first, second = "2030-01-07", "2030-01-14"
+
+		// A series RSVP takes a spot at every occurrence
+		rsvpAndVerify(t, ts.store, id, "series@yale.edu", "")
+		rsvpAndVerify(t, ts.store, id, "a@yale.edu", first)
+		if rsvp := rsvpAndVerify(t, ts.store, id, "b@yale.edu", first); rsvp.WaitlistPosition != 1 {
+			t.Errorf("waitlist position at first occurrence = %d, want 1", rsvp.WaitlistPosition)
+		}
+		if rsvp := rsvpAndVerify(t, ts.store, id, "b@yale.edu", second); rsvp.WaitlistPosition != 0 {
+			t.Errorf("waitlist position at second occurrence = %d, want 0", rsvp.WaitlistPosition)
+		}
+
+		if cancelled, err := ts.store.CancelRSVP(id, "a@yale.edu", first); err != nil || !cancelled {
+			t.Fatalf("CancelRSVP = %v, %v", cancelled, err)
+		}
+
+		event, _, err := ts.store.GetEvent(id)
+		if err != nil {
+			t.Fatalf("GetEvent: %v", err)
+		}
+		if got, want := attendeeEmails(event.Attending), []string{"series@yale.edu"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("series attending = %v, want %v", got, want)
+		}
+		for _, key := range []string{first, second} {
+			rsvps := event.OccurrenceRSVPs[key]
+			if rsvps == nil {
+				t.Fatalf("no RSVPs to %s", key)
+			}
+			if got, want := attendeeEmails(rsvps.Attending), []string{"b@yale.edu"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("attending %s = %v, want %v", key, got, want)
+			}
+			if len(rsvps.Waitlist) != 0 {
+				t.Errorf("waitlist of %s = %v, want none", key, rsvps.Waitlist)
+			}
+		}
+
+		event.listOccurrences(event.Date, event.Date.AddDate(0, 2, 0), 0)
+		if len(event.Occurrences) != 4 {
+			t.Fatalf("got %d occurrences, want 4", len(event.Occurrences))
+		}
+		if got, want := attendeeEmails(event.Occurrences[0].Attending), []string{"b@yale.edu", "series@yale.edu"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("attending first occurrence = %v, want %v", got, want)
+		}
+		if got, want := attendeeEmails(event.Occurrences[2].Attending), []string{"series@yale.edu"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("attending third occurrence = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestStoreListEventsPagination(t *testing.T) {
+	titles := []string{"Delta", "alpha", "Charlie", "bravo", "Echo"}
+	tests := []struct {
+		name string
+		sort string
+		want []string
+	}{
+		{name: "by date", sort: eventSortDate, want: []string{"Delta", "alpha", "Charlie", "bravo", "Echo"}},
+		{name: "by date, latest first", sort: eventSortDateDesc, want: []string{"Echo", "bravo", "Charlie", "alpha", "Delta"}},
+		{name: "by title", sort: eventSortTitle, want: []string{"alpha", "bravo", "Charlie", "Delta", "Echo"}},
+	}
+
+	forEachStore(t, func(t *testing.T, ts testStore) {
+		start := time.Date(2030, 3, 1, 18, 0, 0, 0, time.UTC)
+		for i, title := range titles {
+			createTestEvent(t, ts.store, Event{Title: title, Date: start.AddDate(0, 0, i)})
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				q := eventQuery{Sort: test.sort, Limit: 2}
+				var got []string
+				for pages := 0; ; pages++ {
+					if pages > len(titles) {
+						t.Fatal("listing does not end")
+					}
+					events, next, err := ts.store.ListEvents(q)
+					if err != nil {
+						t.Fatalf("ListEvents: %v", err)
+					}
+					if len(events) > q.Limit {
+						t.Fatalf("got %d events on a page, want at most %d", len(events), q.Limit)
+					}
+					for _, event := range events {
+						got = append(got, event.Title)
+					}
+					if next == nil {
+						break
+					}
+					q.After = next
+				}
+				if !reflect.DeepEqual(got, test.want) {
+					t.Errorf("titles = %v, want %v", got, test.want)
+				}
+			})
+		}
+
+		events, _, err := ts.store.ListEvents(eventQuery{Search: "RAV"})
+		if err != nil {
+			t.Fatalf("ListEvents: %v", err)
+		}
+		if len(events) != 1 || events[0].Title != "bravo" {
+			t.Errorf("search found %v, want bravo", events)
+		}
+	})
+}
+
+func TestStoreListEventsFrom(t *testing.T) {
+	forEachStore(t, func(t *testing.T, ts testStore) {
+		newYork, _ := loadTimeZone("America/New_York")
+		createTestEvent(t, ts.store, Event{Title: "Past party", Date: time.Date(2029, 12, 1, 18, 0, 0, 0, time.UTC)})
+		// The last occurrence is at 10 PM on January 3 in New York, which
+		// is already January 4 in UTC
+		lateEnd := time.Date(2030, 1, 1, 23, 0, 0, 0, newYork)
+		createTestEvent(t, ts.store, Event{
+			Title:      "Late series",
+			Date:       time.Date(2030, 1, 1, 22, 0, 0, 0, newYork),
+			EndDate:    &lateEnd,
+			TimeZone:   "America/New_York",
+			Recurrence: &Recurrence{Freq: recurDaily, Interval: 1, Until: "2030-01-03"},
+		})
+		createTestEvent(t, ts.store, Event{Title: "Later party", Date: time.Date(2030, 2, 1, 18, 0, 0, 0, time.UTC)})
+
+		events, _, err := ts.store.ListEvents(eventQuery{From: time.Date(2030, 1, 3, 12, 0, 0, 0, time.UTC)})
+		if err != nil {
+			t.Fatalf("ListEvents: %v", err)
+		}
+		var got []string
+		for _, event := range events {
+			got = append(got, event.Title)
+		}
+		if want := []string{"Late series", "Later party"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("titles = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestStoreAttendeeRecords(t *testing.T) {
+	forEachStore(t, func(t *testing.T, ts testStore) {
+		id := createTestEvent(t, ts.store, Event{Capacity: 2})
+		rsvpAndVerify(t, ts.store, id, "a@yale.edu", "")
+		addRSVP := func(attendee Attendee) {
+			if _, err := ts.store.AddRSVP(id, attendee, "", testConfirmationCode(t, ts.store, id, attendee.Email)); err != nil {
+				t.Fatalf("AddRSVP(%s): %v", attendee.Email, err)
+			}
+		}
+		addRSVP(Attendee{Email: "b@yale.edu", DisplayName: "Bea", Affiliation: "SOM"})
+		rsvpAndVerify(t, ts.store, id, "c@yale.edu", "")
+		addRSVP(Attendee{Email: "d@yale.edu", DisplayName: "Di"})
+		addRSVP(Attendee{Email: "e@yale.edu", DisplayName: "Ed"})
+
+		var got []AttendeeRecord
+		err := ts.store.EachAttendeeRecord(id, func(record AttendeeRecord) error {
+			got = append(got, record)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("EachAttendeeRecord: %v", err)
+		}
+		want := []struct {
+			email    string
+			status   string
+			position int
+		}{
+			{"a@yale.edu", rsvpStatusAttending, 0},
+			{"b@yale.edu", rsvpStatusPending, 0},
+			{"c@yale.edu", rsvpStatusAttending, 0},
+			{"d@yale.edu", rsvpStatusWaitlisted, 1},
+			{"e@yale.edu", rsvpStatusWaitlisted, 2},
+		}
+		if len(got) != len(want) {
+			t.Fatalf("got %d records, want %d", len(got), len(want))
+		}
+		for i, w := range want {
+			if got[i].Email != w.email || got[i].Status != w.status || got[i].WaitlistPosition != w.position {
+				t.Errorf("record %d = %s %s %d, want %s %s %d", i, got[i].Email, got[i].Status, got[i].WaitlistPosition, w.email, w.status, w.position)
+			}
+			if got[i].RSVPAt == nil {
+				t.Errorf("record %d has no RSVP time", i)
+			}
+		}
+		if got[0].ConfirmationCode == "" {
+			t.Error("the attending record has no confirmation code")
+		}
+		if got[1].Name != "Bea" || got[1].Affiliation != "SOM" {
+			t.Errorf("pending record = %+v, want Bea from SOM", got[1])
+		}
+	})
+}
+
+func TestSQLiteDeleteCascades(t *testing.T) {
+	db, err := initDB(filepath.Join(t.TempDir(), "events.db"), testDefaults.TimeZone)
+	if err != nil {
+		t.Fatalf("initDB: %v", err)
+	}
+	defer db.Close()
+	store := &sqliteStore{db: db}
+
+	var journalMode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil || journalMode != "wal" {
+		t.Errorf("journal mode = %q, %v, want wal", journalMode, err)
+	}
+
+	id := createTestEvent(t, store, Event{Capacity: 1})
+	rsvpAndVerify(t, store, id, "a@yale.edu", "")
+	if _, err := store.AddRSVP(id, Attendee{Email: "b@yale.edu"}, "", testConfirmationCode(t, store, id, "b@yale.edu")); err != nil {
+		t.Fatalf("AddRSVP: %v", err)
+	}
+	if err := store.DeleteEvent(id); err != nil {
+		t.Fatalf("DeleteEvent: %v", err)
+	}
+	for _, table := range []string{"Event_Attendee", "Waitlist"} {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE EventID = ?", id).Scan(&n); err != nil {
+			t.Fatalf("counting %s: %v", table, err)
+		}
+		if n != 0 {
+			t.Errorf("%d rows left in %s after the event was deleted", n, table)
+		}
+	}
+}
+
+func TestStoreCreateUserRace(t *testing.T) {
+	forEachStore(t, func(t *testing.T, ts testStore) {
+		// Sign-ups with the same email at the same time: one wins, and the
+		// others are told the email is taken
+		const signUps = 8
+		errs := make(chan error, signUps)
+		for i := 0; i < signUps; i++ {
+			go func() {
+				_, err := ts.store.CreateUser("Race@yale.edu", "hash")
+				errs <- err
+			}()
+		}
+		created := 0
+		for i := 0; i < signUps; i++ {
+			switch err := <-errs; err {
+			case nil:
+				created++
+			case errEmailTaken:
+			default:
+				t.Errorf("CreateUser: %v", err)
+			}
+		}
+		if created != 1 {
+			t.Errorf("%d accounts were created, want 1", created)
+		}
+		if _, err := ts.store.CreateUser("race@YALE.edu", "hash"); err != errEmailTaken {
+			t.Errorf("CreateUser with the email in another case = %v, want errEmailTaken", err)
+		}
+	})
+}
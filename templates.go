@@ -2,16 +2,38 @@ package main
 
 import (
 	"html/template"
+	"path/filepath"
 )
 
-var tmpl = make(map[string]*template.Template)
+// templateFiles maps the name each page is rendered by to its template.
+var templateFiles = map[string]string{
+	"index":   "index.gohtml",
+	"create":  "create.gohtml",
+	"created": "created.gohtml",
+	"delete":  "delete.gohtml",
+	"access":  "event.gohtml",
+	"cancel":  "cancel.gohtml",
+	"about":   "about.gohtml",
+	"donate":  "donate.gohtml",
+	"login":   "login.gohtml",
+	"me":      "me.gohtml",
+	"error":   "error.gohtml",
+}
 
-func init() {
-	m := template.Must
-	p := template.ParseFiles
-	tmpl["index"] = m(p("templates/index.gohtml", "templates/layout.gohtml"))
-	tmpl["create"] = m(p("templates/create.gohtml", "templates/layout.gohtml"))
-	tmpl["access"] = m(p("templates/event.gohtml", "templates/layout.gohtml"))
-	tmpl["about"] = m(p("templates/about.gohtml", "templates/layout.gohtml"))
-	tmpl["donate"] = m(p("templates/donate.gohtml", "templates/layout.gohtml"))
+// loadTemplates - parses every page in `dir`, each with layout.gohtml,
+// and returns them by name. Pages describe events that do not set their
+// own email policy with that of `defaults`.
+func loadTemplates(dir string, defaults eventDefaults) (map[string]*template.Template, error) {
+	funcs := template.FuncMap{
+		"whoCanRSVP": func(event Event) string { return event.whoCanRSVP(defaults) },
+	}
+	templates := make(map[string]*template.Template)
+	for name, file := range templateFiles {
+		t, err := template.New(file).Funcs(funcs).ParseFiles(filepath.Join(dir, file), filepath.Join(dir, "layout.gohtml"))
+		if err != nil {
+			return nil, err
+		}
+		templates[name] = t
+	}
+	return templates, nil
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	// Embed the time zone database so event time zones work on systems
+	// without one installed.
+	_ "time/tzdata"
+)
+
+// commonTimeZones are offered as suggestions on the event form. Any IANA
+// time zone name is accepted.
+var commonTimeZones = []string{
+	"America/New_York",
+	"America/Chicago",
+	"America/Denver",
+	"America/Phoenix",
+	"America/Los_Angeles",
+	"America/Anchorage",
+	"Pacific/Honolulu",
+	"Europe/London",
+	"Europe/Paris",
+	"Asia/Kolkata",
+	"Asia/Shanghai",
+	"Asia/Tokyo",
+	"Australia/Sydney",
+	"UTC",
+}
+
+// loadTimeZone - returns the location named `name` and a boolean
+// indicating whether it is a valid IANA time zone. The server's own
+// "Local" zone is not accepted, since it differs between machines.
+func loadTimeZone(name string) (*time.Location, bool) {
+	if name == "" || name == "Local" {
+		return nil, false
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, false
+	}
+	return loc, true
+}
+
+// location - returns the event's time zone, or UTC if it has none or it
+// cannot be loaded. The handlers give new events the default time zone.
+func (event Event) location() *time.Location {
+	if loc, ok := loadTimeZone(event.TimeZone); ok {
+		return loc
+	}
+	return time.UTC
+}
+
+// localizeTimes - converts the event's times, which are stored in UTC, to
+// its own time zone so they are shown and serialized with its offset.
+func (event *Event) localizeTimes() {
+	loc := event.location()
+	event.Date = event.Date.In(loc)
+	if event.EndDate != nil {
+		end := event.EndDate.In(loc)
+		event.EndDate = &end
+	}
+}
+
+// parseEventTime - parses a submitted event time. The "YYYY-MM-DDTHH:MM"
+// of the datetime-local input is read as a wall-clock time in `loc`; an
+// RFC 3339 time with an explicit offset is also accepted.
+func parseEventTime(value string, loc *time.Location) (time.Time, error) {
+	t, err := time.ParseInLocation("2006-01-02T15:04", value, loc)
+	if err != nil {
+		if t, rfcErr := time.Parse(time.RFC3339, value); rfcErr == nil {
+			return t, nil
+		}
+	}
+	return t, err
+}
+
+// wallClockIn - returns the time that has the same wall-clock reading in
+// `loc` as `t` has in its own location.
+func wallClockIn(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+// utcTime - returns `t` in UTC for storing, keeping nil as nil.
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+// normalizeEventTimes - returns a migration step that gives events saved
+// before time zones were stored the time zone `timeZone`, and rewrites
+// their times in UTC. Times saved with a real offset keep their instant.
+// The form used to save the wall-clock time it was given as UTC, so a
+// +00:00 time, e.g. 19:00 for 7 PM, is read as that wall-clock time in
+// `timeZone` instead.
+func normalizeEventTimes(timeZone string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		loc, ok := loadTimeZone(timeZone)
+		if !ok {
+			loc = time.UTC
+		}
+		normalize := func(t time.Time) time.Time {
+			if _, offset := t.Zone(); offset == 0 {
+				t = wallClockIn(t, loc)
+			}
+			return t.UTC()
+		}
+
+		rows, err := tx.Query("SELECT ID, Date, EndDate FROM Event WHERE TimeZone IS NULL")
+		if err != nil {
+			return err
+		}
+		type eventTimes struct {
+			id      int
+			date    time.Time
+			endDate sql.NullTime
+		}
+		var events []eventTimes
+		for rows.Next() {
+			var e eventTimes
+			if err := rows.Scan(&e.id, &e.date, &e.endDate); err != nil {
+				rows.Close()
+				return err
+			}
+			events = append(events, e)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, e := range events {
+			var endDate interface{}
+			if e.endDate.Valid {
+				endDate = normalize(e.endDate.Time)
+			}
+			if _, err := tx.Exec("UPDATE Event SET Date = ?, EndDate = ?, TimeZone = ? WHERE ID = ?", normalize(e.date), endDate, timeZone, e.id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadTimeZone(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "America/New_York", want: true},
+		{name: "Asia/Kolkata", want: true},
+		{name: "UTC", want: true},
+		{name: ""},
+		{name: "Local"},
+		{name: "Mars/Olympus_Mons"},
+		{name: "../../etc/passwd"},
+	}
+	for _, test := range tests {
+		if _, got := loadTimeZone(test.name); got != test.want {
+			t.Errorf("loadTimeZone(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseEventTime(t *testing.T) {
+	newYork, _ := loadTimeZone("America/New_York")
+	kolkata, _ := loadTimeZone("Asia/Kolkata")
+	tests := []struct {
+		name    string
+		value   string
+		loc     *time.Location
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "winter in New York", value: "2030-01-07T18:00", loc: newYork, want: time.Date(2030, 1, 7, 23, 0, 0, 0, time.UTC)},
+		{name: "summer in New York", value: "2030-07-07T18:00", loc: newYork, want: time.Date(2030, 7, 7, 22, 0, 0, 0, time.UTC)},
+		{name: "half-hour offset", value: "2030-01-07T18:00", loc: kolkata, want: time.Date(2030, 1, 7, 12, 30, 0, 0, time.UTC)},
+		{name: "explicit offset", value: "2030-01-07T18:00:00+01:00", loc: newYork, want: time.Date(2030, 1, 7, 17, 0, 0, 0, time.UTC)},
+		{name: "malformed", value: "next Tuesday", loc: newYork, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseEventTime(test.value, test.loc)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseEventTime(%q) error = %v, want error: %v", test.value, err, test.wantErr)
+			}
+			if !test.wantErr && !got.Equal(test.want) {
+				t.Errorf("parseEventTime(%q) = %v, want %v", test.value, got.UTC(), test.want)
+			}
+		})
+	}
+}
+
+func TestLocalizeTimes(t *testing.T) {
+	end := time.Date(2030, 1, 8, 1, 0, 0, 0, time.UTC)
+	tests := []struct {
+		timeZone     string
+		wantTimeZone string
+		wantDate     string
+	}{
+		{timeZone: "Asia/Tokyo", wantTimeZone: "Asia/Tokyo", wantDate: "2030-01-08 08:00 JST"},
+		{timeZone: "", wantTimeZone: "", wantDate: "2030-01-07 23:00 UTC"},
+	}
+	for _, test := range tests {
+		event := Event{
+			Date:     time.Date(2030, 1, 7, 23, 0, 0, 0, time.UTC),
+			EndDate:  &end,
+			TimeZone: test.timeZone,
+		}
+		event.localizeTimes()
+		if event.TimeZone != test.wantTimeZone {
+			t.Errorf("time zone = %q, want %q", event.TimeZone, test.wantTimeZone)
+		}
+		if got := event.Date.Format("2006-01-02 15:04 MST"); got != test.wantDate {
+			t.Errorf("date in %q = %s, want %s", test.timeZone, got, test.wantDate)
+		}
+		if event.EndDate.Location() != event.Date.Location() || !event.EndDate.Equal(end) {
+			t.Errorf("end date in %q = %v, want %v in the event's zone", test.timeZone, event.EndDate, end)
+		}
+	}
+}
+
+func TestApplyTimeZone(t *testing.T) {
+	str := func(s string) *string { return &s }
+	newYork, _ := loadTimeZone("America/New_York")
+	tests := []struct {
+		name         string
+		timeZone     *string
+		date         *string
+		wantTimeZone string
+		wantDate     time.Time
+		wantEndDate  time.Time
+		wantError    string
+	}{
+		{name: "event's zone", date: str("2030-01-07T18:00"), wantTimeZone: "America/New_York", wantDate: time.Date(2030, 1, 7, 23, 0, 0, 0, time.UTC), wantEndDate: time.Date(2030, 1, 8, 1, 0, 0, 0, time.UTC)},
+		{name: "submitted zone", timeZone: str("Europe/Paris"), date: str("2030-01-07T18:00"), wantTimeZone: "Europe/Paris", wantDate: time.Date(2030, 1, 7, 17, 0, 0, 0, time.UTC), wantEndDate: time.Date(2030, 1, 7, 19, 0, 0, 0, time.UTC)},
+		{name: "submitted zone and date", timeZone: str("Europe/Paris"), date: str("2030-01-07T19:00"), wantTimeZone: "Europe/Paris", wantDate: time.Date(2030, 1, 7, 18, 0, 0, 0, time.UTC), wantEndDate: time.Date(2030, 1, 7, 19, 0, 0, 0, time.UTC)},
+		// The event keeps its wall-clock times in the new zone
+		{name: "zone without a date", timeZone: str("Europe/Paris"), wantTimeZone: "Europe/Paris", wantDate: time.Date(2030, 1, 7, 17, 0, 0, 0, time.UTC), wantEndDate: time.Date(2030, 1, 7, 19, 0, 0, 0, time.UTC)},
+		{name: "unknown zone", timeZone: str("Europe/Atlantis"), date: str("2030-01-07T18:00"), wantError: "time_zone"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			end := time.Date(2030, 1, 7, 20, 0, 0, 0, newYork)
+			event := Event{TimeZone: "America/New_York", Date: time.Date(2030, 1, 7, 18, 0, 0, 0, newYork), EndDate: &end}
+			errs := eventFields{TimeZone: test.timeZone, Date: test.date}.apply(&event, true)
+			if test.wantError != "" {
+				if len(errs) != 1 || errs[0].Field != test.wantError {
+					t.Errorf("apply errors = %v, want one for %s", errs, test.wantError)
+				}
+				return
+			}
+			if len(errs) > 0 {
+				t.Fatalf("apply errors = %v", errs)
+			}
+			if event.TimeZone != test.wantTimeZone {
+				t.Errorf("time zone = %q, want %q", event.TimeZone, test.wantTimeZone)
+			}
+			if !event.Date.Equal(test.wantDate) {
+				t.Errorf("date = %v, want %v", event.Date.UTC(), test.wantDate)
+			}
+			if !event.EndDate.Equal(test.wantEndDate) {
+				t.Errorf("end date = %v, want %v", event.EndDate.UTC(), test.wantEndDate)
+			}
+		})
+	}
+}
+
+func TestNormalizeEventTimes(t *testing.T) {
+	newYork, _ := loadTimeZone("America/New_York")
+	tokyo, _ := loadTimeZone("Asia/Tokyo")
+	tests := []struct {
+		name  string
+		saved time.Time
+		want  time.Time
+	}{
+		{name: "with an offset", saved: time.Date(2030, 1, 7, 18, 0, 0, 0, newYork), want: time.Date(2030, 1, 7, 18, 0, 0, 0, newYork)},
+		// The form saved 7 PM as 19:00 UTC
+		{name: "from the form", saved: time.Date(2030, 1, 7, 19, 0, 0, 0, time.UTC), want: time.Date(2030, 1, 7, 19, 0, 0, 0, tokyo)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := testDB.Exec("INSERT INTO Event (PublicID, Title, Location, Image, Date, RSVPMessage, TimeZone) VALUES (?, 'Old party', 'Evans Hall', '', ?, '', NULL)", newPublicID(), test.saved)
+			if err != nil {
+				t.Fatalf("inserting an event without a time zone: %v", err)
+			}
+			id, _ := res.LastInsertId()
+
+			tx, err := testDB.Begin()
+			if err != nil {
+				t.Fatalf("Begin: %v", err)
+			}
+			defer tx.Rollback()
+			if err := normalizeEventTimes("Asia/Tokyo")(tx); err != nil {
+				t.Fatalf("normalizeEventTimes: %v", err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatalf("Commit: %v", err)
+			}
+			event := mustGetEvent(t, int(id))
+			if event.TimeZone != "Asia/Tokyo" {
+				t.Errorf("time zone = %q, want Asia/Tokyo", event.TimeZone)
+			}
+			if !event.Date.Equal(test.want) {
+				t.Errorf("date = %v, want %v", event.Date, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"time"
+)
+
+// authForm - the data rendered by login.gohtml, used for both the login
+// and the sign up pages.
+type authForm struct {
+	Heading      string
+	Action       string
+	SubmitLabel  string
+	ErrorMessage string
+	Email        string
+	SwitchURL    string
+	SwitchLabel  string
+}
+
+func loginForm() authForm {
+	return authForm{
+		Heading:     "Log In",
+		Action:      "/login",
+		SubmitLabel: "Log In",
+		SwitchURL:   "/signup",
+		SwitchLabel: "Need an account? Sign up",
+	}
+}
+
+func signupForm() authForm {
+	return authForm{
+		Heading:     "Sign Up",
+		Action:      "/signup",
+		SubmitLabel: "Create Account",
+		SwitchURL:   "/login",
+		SwitchLabel: "Already have an account? Log in",
+	}
+}
+
+// currentUser - returns the user logged in on this request, if any, and a
+// boolean indicating whether there is one.
+func (s *server) currentUser(r *http.Request) (User, bool, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return User{}, false, nil
+	}
+	return s.store.SessionUser(hashSessionToken(cookie.Value))
+}
+
+// logIn - starts a session for `user` and sets its cookie on the response.
+func (s *server) logIn(w http.ResponseWriter, user User) error {
+	token, err := newSessionToken()
+	if err != nil {
+		return err
+	}
+	expires := time.Now().Add(sessionDuration)
+	if err := s.store.CreateSession(hashSessionToken(token), user.ID, expires); err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func (s *server) signupController(w http.ResponseWriter, r *http.Request) {
+	form := signupForm()
+	if r.Method != http.MethodPost {
+		s.templates["login"].Execute(w, form)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, http.StatusBadRequest, "Invalid form submission")
+		return
+	}
+	form.Email = r.FormValue("email")
+	password := r.FormValue("password")
+
+	if _, err := mail.ParseAddress(form.Email); err != nil {
+		form.ErrorMessage = "Invalid email format. Please enter a valid email address."
+	} else if len(password) < 8 {
+		form.ErrorMessage = "Your password must be at least 8 characters long."
+	}
+	if form.ErrorMessage != "" {
+		s.templates["login"].Execute(w, form)
+		return
+	}
+
+	user, err := s.store.CreateUser(form.Email, hashPassword(password))
+	if err == errEmailTaken {
+		form.ErrorMessage = "An account with that email already exists."
+		s.templates["login"].Execute(w, form)
+		return
+	}
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+
+	if err := s.logIn(w, user); err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+
+	// The account works without it, so a failed email can be resent from
+	// the account page
+	if err := s.sendEmailVerification(user); err != nil {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+	}
+	http.Redirect(w, r, "/me", http.StatusSeeOther)
+}
+
+// sendEmailVerification - emails `user` a link that proves they own their
+// email address, replacing any link sent before.
+func (s *server) sendEmailVerification(user User) error {
+	token := newVerifyToken()
+	if err := s.store.SetEmailVerifyToken(user.ID, hashVerifyToken(token)); err != nil {
+		return err
+	}
+	link := s.baseURL + "/verify-email?token=" + url.QueryEscape(token)
+	body := "Hi,\n\nPlease confirm that " + user.Email + " is your email address by visiting:\n\n" +
+		link + "\n\nUntil you do, your account will not list the events you RSVP-ed to. If you did not sign up, you can ignore this email.\n"
+	return s.mailer.Send(user.Email, "Confirm your email address", body)
+}
+
+// verifyEmailController - handles GET /verify-email, the link emailed to
+// new accounts.
+func (s *server) verifyEmailController(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		s.renderError(w, http.StatusBadRequest, "This confirmation link is invalid or has already been used.")
+		return
+	}
+	verified, err := s.store.VerifyUserEmail(hashVerifyToken(token))
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	if !verified {
+		s.renderError(w, http.StatusBadRequest, "This confirmation link is invalid or has already been used.")
+		return
+	}
+	http.Redirect(w, r, "/me", http.StatusSeeOther)
+}
+
+// resendEmailVerificationController - handles POST /me/verify-email,
+// sending the logged-in user a new link to verify their email.
+func (s *server) resendEmailVerificationController(w http.ResponseWriter, r *http.Request) {
+	user, loggedIn, err := s.currentUser(r)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	if !loggedIn {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if !user.EmailVerified {
+		if err := s.sendEmailVerification(user); err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+	}
+	http.Redirect(w, r, "/me?verification=sent", http.StatusSeeOther)
+}
+
+func (s *server) loginController(w http.ResponseWriter, r *http.Request) {
+	form := loginForm()
+	if r.Method != http.MethodPost {
+		s.templates["login"].Execute(w, form)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, http.StatusBadRequest, "Invalid form submission")
+		return
+	}
+	form.Email = r.FormValue("email")
+	password := r.FormValue("password")
+
+	user, found, err := s.store.UserByEmail(form.Email)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	if !found || !checkPassword(user.PasswordHash, password) {
+		form.ErrorMessage = "Wrong email or password."
+		s.templates["login"].Execute(w, form)
+		return
+	}
+
+	if err := s.logIn(w, user); err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/me", http.StatusSeeOther)
+}
+
+func (s *server) logoutController(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if err := s.store.DeleteSession(hashSessionToken(cookie.Value)); err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// meController - shows the logged-in user the events they created and,
+// once they have verified their email, the events they have RSVP-ed to.
+// Anyone can sign up with any email, so until then the RSVPs made with it
+// may not be theirs.
+func (s *server) meController(w http.ResponseWriter, r *http.Request) {
+	user, loggedIn, err := s.currentUser(r)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	if !loggedIn {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	type meContextData struct {
+		User             User
+		MyEvents         []Event
+		MyRSVPs          []Event
+		VerificationSent bool
+	}
+
+	myEvents, err := s.store.EventsByOwner(user.ID)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+	var myRSVPs []Event
+	if user.EmailVerified {
+		myRSVPs, err = s.store.EventsAttendedBy(user.Email)
+		if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+	}
+
+	s.templates["me"].Execute(w, meContextData{
+		User:             user,
+		MyEvents:         myEvents,
+		MyRSVPs:          myRSVPs,
+		VerificationSent: r.URL.Query().Get("verification") == "sent",
+	})
+}
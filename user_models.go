@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// User - an account that can create and manage events
+type User struct {
+	ID           int
+	Email        string
+	PasswordHash string
+	CreatedAt    time.Time
+	// EmailVerified is whether the user has followed the link sent to
+	// their email address, proving that it is theirs.
+	EmailVerified bool
+}
+
+// sessionCookieName is the cookie that carries the session token of a
+// logged-in user.
+const sessionCookieName = "session"
+
+// sessionDuration is how long a login lasts.
+const sessionDuration = 30 * 24 * time.Hour
+
+// passwordIterations is the PBKDF2 work factor used for new passwords.
+// It is stored with each hash so it can be raised later.
+const passwordIterations = 210000
+
+var errEmailTaken = errors.New("an account with that email already exists")
+
+// hashPassword - returns a salted PBKDF2 hash of `password` in the form
+// "pbkdf2-sha256$<iterations>$<salt>$<hash>".
+func hashPassword(password string) string {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	key := pbkdf2.Key([]byte(password), salt, passwordIterations, 32, sha256.New)
+	return "pbkdf2-sha256$" + strconv.Itoa(passwordIterations) + "$" +
+		base64.RawStdEncoding.EncodeToString(salt) + "$" +
+		base64.RawStdEncoding.EncodeToString(key)
+}
+
+// checkPassword - reports whether `password` matches `encoded`, a hash
+// produced by hashPassword.
+func checkPassword(encoded string, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	key := pbkdf2.Key([]byte(password), salt, iterations, len(expected), sha256.New)
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+// isUniqueViolation - reports whether `err` is SQLite refusing a row
+// because it breaks a UNIQUE constraint.
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// CreateUser - adds a new account and returns it. Returns errEmailTaken if
+// the email is already registered.
+func (s *sqliteStore) CreateUser(email string, passwordHash string) (User, error) {
+	user := User{
+		Email:        strings.ToLower(email),
+		PasswordHash: passwordHash,
+		CreatedAt:    time.Now(),
+	}
+	// The UNIQUE constraint on Email, rather than a lookup first, decides
+	// which of two sign-ups with the same email wins
+	res, err := s.db.Exec("INSERT INTO User (Email, PasswordHash, CreatedAt) VALUES (?, ?, ?)", user.Email, user.PasswordHash, user.CreatedAt)
+	if isUniqueViolation(err) {
+		return User{}, errEmailTaken
+	} else if err != nil {
+		return User{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+	user.ID = int(id)
+	return user, nil
+}
+
+// UserByEmail - returns the account registered with `email` and a
+// boolean indicating whether or not it was found.
+func (s *sqliteStore) UserByEmail(email string) (User, bool, error) {
+	var user User
+	err := s.db.QueryRow("SELECT ID, Email, PasswordHash, CreatedAt, EmailVerified FROM User WHERE Email = ?", strings.ToLower(email)).
+		Scan(&user.ID, &user.Email, &user.PasswordHash, &user.CreatedAt, &user.EmailVerified)
+	if err == sql.ErrNoRows {
+		return User{}, false, nil
+	}
+	if err != nil {
+		return User{}, false, err
+	}
+	return user, true, nil
+}
+
+// newSessionToken - returns a fresh random session token.
+func newSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateSession - starts a new session for the user with the specified id
+// whose token has the hash `tokenHash`.
+func (s *sqliteStore) CreateSession(tokenHash string, userID int, expires time.Time) error {
+	_, err := s.db.Exec("INSERT INTO Session (TokenHash, UserID, ExpiresAt) VALUES (?, ?, ?)", tokenHash, userID, expires)
+	return err
+}
+
+// SessionUser - returns the user whose unexpired session has the token
+// hash `tokenHash` and a boolean indicating whether or not there was one.
+func (s *sqliteStore) SessionUser(tokenHash string) (User, bool, error) {
+	var user User
+	var expires time.Time
+	err := s.db.QueryRow("SELECT User.ID, User.Email, User.PasswordHash, User.CreatedAt, User.EmailVerified, Session.ExpiresAt FROM Session INNER JOIN User ON User.ID = Session.UserID WHERE Session.TokenHash = ?", tokenHash).
+		Scan(&user.ID, &user.Email, &user.PasswordHash, &user.CreatedAt, &user.EmailVerified, &expires)
+	if err == sql.ErrNoRows {
+		return User{}, false, nil
+	}
+	if err != nil {
+		return User{}, false, err
+	}
+	if time.Now().After(expires) {
+		return User{}, false, s.DeleteSession(tokenHash)
+	}
+	return user, true, nil
+}
+
+// DeleteSession - ends the session whose token has the hash `tokenHash`.
+func (s *sqliteStore) DeleteSession(tokenHash string) error {
+	_, err := s.db.Exec("DELETE FROM Session WHERE TokenHash = ?", tokenHash)
+	return err
+}
+
+// hashSessionToken - returns the form of a session token that is stored.
+func hashSessionToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return base64.RawStdEncoding.EncodeToString(hash[:])
+}
+
+// newVerifyToken - returns a fresh random token for a link that verifies
+// an email address.
+func newVerifyToken() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// hashVerifyToken - returns the form of a verification token that is
+// stored, so the database alone cannot be used to verify an address.
+func hashVerifyToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// SetEmailVerifyToken - replaces the token that verifies the email of the
+// user with the specified id. Only its hash is stored.
+func (s *sqliteStore) SetEmailVerifyToken(userID int, tokenHash string) error {
+	_, err := s.db.Exec("UPDATE User SET VerifyTokenHash = ? WHERE ID = ?", tokenHash, userID)
+	return err
+}
+
+// VerifyUserEmail - marks the email of the user whose verification token
+// has the hash `tokenHash` as verified. Returns false if there is no such
+// user.
+func (s *sqliteStore) VerifyUserEmail(tokenHash string) (bool, error) {
+	res, err := s.db.Exec("UPDATE User SET EmailVerified = 1, VerifyTokenHash = NULL WHERE VerifyTokenHash = ?", tokenHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// signUp - creates an account for `email` through the sign up form and
+// returns it with the cookie of the session it started.
+func signUp(t *testing.T, email string) (User, *http.Cookie) {
+	t.Helper()
+	form := url.Values{"email": {email}, "password": {"correct horse"}}
+	w := serve(t, http.MethodPost, "/signup", form.Encode())
+	expectStatus(t, w, http.StatusSeeOther)
+	user, found, err := testServer.store.UserByEmail(email)
+	if err != nil || !found {
+		t.Fatalf("UserByEmail(%q) = %v, %v", email, found, err)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == sessionCookieName {
+			return user, cookie
+		}
+	}
+	t.Fatal("signing up did not start a session")
+	return User{}, nil
+}
+
+// serveUser - sends a request without a body for `path` with the session
+// `cookie`.
+func serveUser(t *testing.T, method string, path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, path, nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	createRoutes(testServer).ServeHTTP(w, r)
+	return w
+}
+
+func TestPasswordHash(t *testing.T) {
+	hash := hashPassword("correct horse")
+	if hash == hashPassword("correct horse") {
+		t.Error("two hashes of the same password are equal, so they are not salted")
+	}
+	tests := []struct {
+		name     string
+		encoded  string
+		password string
+		want     bool
+	}{
+		{name: "right password", encoded: hash, password: "correct horse", want: true},
+		{name: "wrong password", encoded: hash, password: "correct horsE"},
+		{name: "empty password", encoded: hash},
+		{name: "not a hash", encoded: "correct horse", password: "correct horse"},
+		// PBKDF2-HMAC-SHA256 test vector from RFC 7914, section 11
+		{name: "stored hash", encoded: "pbkdf2-sha256$1$c2FsdA$VawEblbjCJ/sFpHCJUS2BflBhSFt3gRl5oudV8INrLxJypzM8Xm2RZkWZLOdd+8xfHG4RbHjC9UJESBB06GXgw", password: "passwd", want: true},
+		{name: "no iterations", encoded: strings.Replace(hash, "$"+strconv.Itoa(passwordIterations)+"$", "$0$", 1), password: "correct horse"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := checkPassword(test.encoded, test.password); got != test.want {
+				t.Errorf("checkPassword = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSignUpAndLogIn(t *testing.T) {
+	user, _ := signUp(t, "Owner@Yale.edu")
+	if user.Email != "owner@yale.edu" {
+		t.Errorf("email = %q, want it lowercased", user.Email)
+	}
+	if user.EmailVerified {
+		t.Error("a new account has a verified email")
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		email      string
+		password   string
+		wantStatus int
+		wantError  string
+	}{
+		{name: "sign up again", path: "/signup", email: "owner@yale.edu", password: "correct horse", wantStatus: http.StatusOK, wantError: "already exists"},
+		{name: "short password", path: "/signup", email: "short@yale.edu", password: "short", wantStatus: http.StatusOK, wantError: "at least 8 characters"},
+		{name: "log in", path: "/login", email: "OWNER@yale.edu", password: "correct horse", wantStatus: http.StatusSeeOther},
+		{name: "wrong password", path: "/login", email: "owner@yale.edu", password: "wrong horse", wantStatus: http.StatusOK, wantError: "Wrong email or password"},
+		{name: "no account", path: "/login", email: "nobody@yale.edu", password: "correct horse", wantStatus: http.StatusOK, wantError: "Wrong email or password"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			form := url.Values{"email": {test.email}, "password": {test.password}}
+			w := serve(t, http.MethodPost, test.path, form.Encode())
+			expectStatus(t, w, test.wantStatus)
+			if !strings.Contains(w.Body.String(), test.wantError) {
+				t.Errorf("body does not say %q: %s", test.wantError, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCanManageEvent(t *testing.T) {
+	owner, ownerCookie := signUp(t, "manager@yale.edu")
+	_, otherCookie := signUp(t, "other@yale.edu")
+	token := newOrganizerToken()
+	event := Event{OwnerID: owner.ID, OrganizerTokenHash: hashOrganizerToken(token)}
+
+	tests := []struct {
+		name   string
+		event  Event
+		cookie *http.Cookie
+		token  string
+		want   bool
+	}{
+		{name: "owner", event: event, cookie: ownerCookie, want: true},
+		{name: "organizer link", event: event, token: token, want: true},
+		{name: "other user", event: event, cookie: otherCookie},
+		{name: "logged out", event: event},
+		{name: "event without owner", event: Event{OrganizerTokenHash: event.OrganizerTokenHash}, cookie: ownerCookie},
+		{name: "unknown session", event: event, cookie: &http.Cookie{Name: sessionCookieName, Value: "not a session"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.cookie != nil {
+				r.AddCookie(test.cookie)
+			}
+			if test.token != "" {
+				r.Header.Set("X-Organizer-Token", test.token)
+			}
+			if got := testServer.canManageEvent(r, test.event); got != test.want {
+				t.Errorf("canManageEvent = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// brokenSessionStore - a Store that cannot look up sessions.
+type brokenSessionStore struct {
+	Store
+}
+
+// SessionUser - fails.
+func (brokenSessionStore) SessionUser(tokenHash string) (User, bool, error) {
+	return User{}, false, errors.New("database went away")
+}
+
+func TestSessionLookupError(t *testing.T) {
+	_, cookie := signUp(t, "unlucky@yale.edu")
+	s := *testServer
+	s.store = brokenSessionStore{testServer.store}
+
+	// A logged-in user is not sent to log in again, nor are events created
+	// without their owner
+	form := url.Values{"title": {"Unlucky party"}, "location": {"Evans Hall"}, "image": {"http://i.imgur.com/pXjrQ.gif"}, "date": {futureDate()}}
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/me", nil),
+		httptest.NewRequest(http.MethodPost, "/events/new", strings.NewReader(form.Encode())),
+	} {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		createRoutes(&s).ServeHTTP(w, r)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("%s %s: status = %d, want %d", r.Method, r.URL.Path, w.Code, http.StatusInternalServerError)
+		}
+	}
+}
+
+func TestAccountRSVPsNeedVerifiedEmail(t *testing.T) {
+	user, cookie := signUp(t, "rsvper@yale.edu")
+	event := createAPIEvent(t)
+	id := event.ID
+	rsvp, err := testServer.store.AddRSVP(id, Attendee{Email: user.Email}, "", testConfirmationCode(t, testServer.store, id, user.Email))
+	if err != nil {
+		t.Fatalf("AddRSVP: %v", err)
+	}
+	if _, _, err := testServer.store.VerifyRSVP(id, rsvp.VerifyToken); err != nil {
+		t.Fatalf("VerifyRSVP: %v", err)
+	}
+	link := `href="/events/` + event.PathID() + `"`
+
+	w := serveUser(t, http.MethodGet, "/me", cookie)
+	expectStatus(t, w, http.StatusOK)
+	if strings.Contains(w.Body.String(), link) {
+		t.Error("the account page lists RSVPs before the email is verified")
+	}
+
+	firstToken := mailedToken(t, user.Email, "/verify-email")
+	expectStatus(t, serveUser(t, http.MethodPost, "/me/verify-email", cookie), http.StatusSeeOther)
+	token := mailedToken(t, user.Email, "/verify-email")
+	expectStatus(t, serve(t, http.MethodGet, "/verify-email?token=wrong", ""), http.StatusBadRequest)
+	expectStatus(t, serve(t, http.MethodGet, "/verify-email?token="+url.QueryEscape(firstToken), ""), http.StatusBadRequest)
+	expectStatus(t, serve(t, http.MethodGet, "/verify-email?token="+url.QueryEscape(token), ""), http.StatusSeeOther)
+	expectStatus(t, serve(t, http.MethodGet, "/verify-email?token="+url.QueryEscape(token), ""), http.StatusBadRequest)
+
+	w = serveUser(t, http.MethodGet, "/me", cookie)
+	expectStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), link) {
+		t.Errorf("the account page does not list the RSVP once the email is verified: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// rsvpPosition - returns the waitlist position of `email` for the event
+// with the specified id, RSVP-ing them, pending verification, if they have
+// not yet.
+func rsvpPosition(t *testing.T, eventID int, email string) int {
+	t.Helper()
+	rsvp, err := testServer.store.AddRSVP(eventID, Attendee{Email: email}, "", testConfirmationCode(t, testServer.store, eventID, email))
+	if err != nil {
+		t.Fatalf("testServer.store.AddRSVP(%q): %v", email, err)
+	}
+	return rsvp.WaitlistPosition
+}
+
+func TestWaitlist(t *testing.T) {
+	id := mustAddEvent(t, Event{Title: "Small party", Date: time.Now().AddDate(1, 0, 0), Capacity: 2})
+
+	// The steps run in order against the same event
+	steps := []struct {
+		name         string
+		rsvp         string
+		cancel       string
+		capacity     int
+		wantPosition map[string]int
+	}{
+		{name: "first spot", rsvp: "a@yale.edu", wantPosition: map[string]int{"a@yale.edu": 0}},
+		{name: "last spot", rsvp: "b@yale.edu", wantPosition: map[string]int{"b@yale.edu": 0}},
+		{name: "full", rsvp: "c@yale.edu", wantPosition: map[string]int{"c@yale.edu": 1}},
+		{name: "in line", rsvp: "d@yale.edu", wantPosition: map[string]int{"c@yale.edu": 1, "d@yale.edu": 2}},
+		{name: "RSVP again", wantPosition: map[string]int{"C@yale.edu": 1}},
+		{name: "attendee cancels", cancel: "a@yale.edu", wantPosition: map[string]int{"c@yale.edu": 0, "d@yale.edu": 1}},
+		{name: "waitlisted cancels", cancel: "d@yale.edu", wantPosition: map[string]int{"b@yale.edu": 0, "c@yale.edu": 0}},
+		{name: "full again", rsvp: "e@yale.edu", wantPosition: map[string]int{"e@yale.edu": 1}},
+		{name: "capacity raised", capacity: 3, wantPosition: map[string]int{"e@yale.edu": 0}},
+	}
+	for _, step := range steps {
+		if step.capacity != 0 {
+			event := mustGetEvent(t, id)
+			event.Capacity = step.capacity
+			if err := testServer.store.UpdateEvent(event); err != nil {
+				t.Fatalf("%s: updateEvent: %v", step.name, err)
+			}
+		}
+		if step.cancel != "" {
+			if removed, err := testServer.store.CancelRSVP(id, step.cancel, ""); err != nil || !removed {
+				t.Fatalf("%s: removeAttendee = %v, %v", step.name, removed, err)
+			}
+		}
+		if step.rsvp != "" {
+			rsvpAndVerify(t, testServer.store, id, step.rsvp, "")
+		}
+		for email, want := range step.wantPosition {
+			if got := rsvpPosition(t, id, email); got != want {
+				t.Errorf("%s: waitlist position of %s = %d, want %d", step.name, email, got, want)
+			}
+		}
+	}
+}
+
+func TestPendingRSVPsTakeNoSpot(t *testing.T) {
+	forEachStore(t, func(t *testing.T, ts testStore) {
+		id := createTestEvent(t, ts.store, Event{Capacity: 1})
+		add := func(email string) RSVP {
+			t.Helper()
+			rsvp, err := ts.store.AddRSVP(id, Attendee{Email: email}, "", testConfirmationCode(t, ts.store, id, email))
+			if err != nil {
+				t.Fatalf("AddRSVP(%s): %v", email, err)
+			}
+			return rsvp
+		}
+		verify := func(rsvp RSVP) RSVP {
+			t.Helper()
+			verified, found, err := ts.store.VerifyRSVP(id, rsvp.VerifyToken)
+			if err != nil || !found {
+				t.Fatalf("VerifyRSVP = %v, %v", found, err)
+			}
+			return verified
+		}
+
+		a := add("a@yale.edu")
+		b := add("b@yale.edu")
+		if a.WaitlistPosition != 0 || b.WaitlistPosition != 0 {
+			t.Fatalf("waitlist positions = %d, %d, want a pending RSVP not to fill the event", a.WaitlistPosition, b.WaitlistPosition)
+		}
+
+		// The spot goes to whoever verifies first
+		if rsvp := verify(b); rsvp.WaitlistPosition != 0 {
+			t.Errorf("first verified waitlist position = %d, want 0", rsvp.WaitlistPosition)
+		}
+		if rsvp := verify(a); rsvp.WaitlistPosition != 1 || !rsvp.Confirmed {
+			t.Errorf("second verified = %+v, want confirmed at #1 on the waitlist", rsvp)
+		}
+		if c := add("c@yale.edu"); c.WaitlistPosition != 2 {
+			t.Errorf("waitlist position once full = %d, want 2", c.WaitlistPosition)
+		}
+
+		// A freed spot goes to the first verified person in line
+		if _, err := ts.store.CancelRSVP(id, "b@yale.edu", ""); err != nil {
+			t.Fatalf("CancelRSVP: %v", err)
+		}
+		event, _, err := ts.store.GetEvent(id)
+		if err != nil {
+			t.Fatalf("GetEvent: %v", err)
+		}
+		if !reflect.DeepEqual(attendeeEmails(event.Attending), []string{"a@yale.edu"}) || !reflect.DeepEqual(event.Waitlist, []string{"c@yale.edu"}) {
+			t.Errorf("attending %v, waitlist %v; want a@yale.edu attending and c@yale.edu waiting", attendeeEmails(event.Attending), event.Waitlist)
+		}
+	})
+}